@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"clipsync/backend/internal/atrest"
+	"clipsync/backend/internal/blob"
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/db"
+)
+
+// reencrypt moves stored clip and message content, attachments and contact photos onto
+// the current at-rest keys: it re-wraps data keys under AT_REST_ACTIVE_KEY, optionally
+// rotates the data keys, and seals legacy plaintext and values under retired data keys.
+func main() {
+	rotate := flag.Bool("rotate-data-keys", false, "retire every user's data key and re-encrypt under fresh ones")
+	batch := flag.Int("batch", 500, "rows per batch")
+	flag.Parse()
+
+	if err := config.Load(); err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+
+	if _, err := db.Initialize(); err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+	blobs, err := blob.NewLocal(config.Get().BlobDir)
+	if err != nil {
+		log.Fatal("Failed to open blob storage:", err)
+	}
+	if !atrest.Enabled() {
+		log.Fatal("At-rest encryption is not configured; set AT_REST_KEYS or AT_REST_KEY_FILE")
+	}
+
+	rewrapped, err := atrest.RewrapDataKeys()
+	if err != nil {
+		log.Fatal("Failed to re-wrap data keys:", err)
+	}
+	log.Printf("Re-wrapped %d data keys under the active master key", rewrapped)
+
+	if *rotate {
+		retired, err := atrest.RetireDataKeys()
+		if err != nil {
+			log.Fatal("Failed to retire data keys:", err)
+		}
+		log.Printf("Retired %d data keys", retired)
+
+		// Servers seal under their cached active key until it expires; values written
+		// before then would otherwise stay under retired keys
+		log.Printf("Waiting %s for running servers to pick up the new data keys...", atrest.ActiveKeyTTL)
+		time.Sleep(atrest.ActiveKeyTTL)
+	}
+
+	reencryptAll(blobs, *batch)
+	if *rotate {
+		// Catches values sealed by requests still in flight when the caches expired
+		log.Println("Running a final pass...")
+		reencryptAll(blobs, *batch)
+	}
+
+	log.Println("Re-encryption completed")
+}
+
+func reencryptAll(blobs blob.Store, batch int) {
+	for _, t := range atrest.Targets {
+		log.Printf("Re-encrypting %s.%s...", t.Table, t.Column)
+		n, err := atrest.Reencrypt(t, batch)
+		if err != nil {
+			log.Fatalf("Failed to re-encrypt %s.%s after %d rows: %v", t.Table, t.Column, n, err)
+		}
+		log.Printf("Re-encrypted %d rows in %s.%s", n, t.Table, t.Column)
+	}
+	for _, t := range atrest.BlobTargets {
+		log.Printf("Re-encrypting blobs of %s.%s...", t.Table, t.Column)
+		n, err := atrest.ReencryptBlobs(context.Background(), blobs, t, batch)
+		if err != nil {
+			log.Fatalf("Failed to re-encrypt blobs of %s.%s after %d blobs: %v", t.Table, t.Column, n, err)
+		}
+		log.Printf("Re-encrypted %d blobs of %s.%s", n, t.Table, t.Column)
+	}
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 
 	"clipsync/backend/internal/api"
+	"clipsync/backend/internal/blob"
 	"clipsync/backend/internal/config"
 	"clipsync/backend/internal/db"
+	"clipsync/backend/internal/retention"
 )
 
 func main() {
 	if err := config.Load(); err != nil {
 		log.Fatal("Failed to load config:", err)
 	}
+	if err := config.Get().Validate(); err != nil {
+		log.Fatal("Refusing to start: ", err)
+	}
 
 	database, err := db.Initialize()
 	if err != nil {
@@ -27,7 +33,14 @@ func main() {
 		return
 	}
 
-	router := api.InitializeRouter(database)
+	blobs, err := blob.NewLocal(config.Get().BlobDir)
+	if err != nil {
+		log.Fatal("Failed to open blob storage:", err)
+	}
+
+	go retention.Run(context.Background(), database, blobs, config.Get().MessageRetentionInterval)
+
+	router := api.InitializeRouter(database, blobs)
 
 	// Start server
 	port := config.Get().Port
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipsync/backend/internal/atrest"
+	"clipsync/backend/internal/blob"
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/events"
+	"clipsync/backend/internal/media"
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EventAttachmentReady is published when the last chunk of an attachment arrives.
+const EventAttachmentReady = "messages.attachment"
+
+// Download variants of an attachment.
+const (
+	variantOriginal  = "original"
+	variantThumbnail = "thumbnail"
+)
+
+var (
+	errAttachmentComplete = errors.New("attachment already uploaded")
+	errAttachmentOffset   = errors.New("upload offset mismatch")
+)
+
+// AttachmentSpec announces an attachment in a message push. Its content is uploaded
+// afterwards to the returned upload URL.
+type AttachmentSpec struct {
+	FileName string `json:"fileName" binding:"max=255"`
+	MimeType string `json:"mimeType" binding:"required,max=127"`
+	Size     int64  `json:"size" binding:"required,min=1"`
+	Nonce    string `json:"nonce" binding:"max=32"` // Required when the message is encrypted
+
+	// Key of the encrypted content, wrapped under the vault key, so a vault re-key only
+	// re-wraps it instead of re-uploading the content
+	WrappedKey string `json:"wrappedKey"`
+	KeyNonce   string `json:"keyNonce" binding:"max=32"`
+}
+
+// AttachmentUpload tells the phone where and from which offset to upload an attachment.
+type AttachmentUpload struct {
+	ID        string `json:"id"`
+	Position  int    `json:"position"`
+	Status    string `json:"status"`
+	Size      int64  `json:"size"`
+	Offset    int64  `json:"offset"`
+	UploadURL string `json:"uploadUrl"`
+}
+
+func attachmentTypeAllowed(mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+	for _, t := range config.Get().AttachmentTypes {
+		if mimeType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mimeType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAttachments checks announced attachments against the limits. Attachments of an
+// encrypted message are encrypted too, each under its own nonce and preferably its own
+// wrapped content key.
+func validateAttachments(specs []AttachmentSpec, encrypted bool) error {
+	cfg := config.Get()
+	if len(specs) > cfg.AttachmentMaxCount {
+		return fmt.Errorf("at most %d attachments per message", cfg.AttachmentMaxCount)
+	}
+	for _, a := range specs {
+		if a.Size > int64(cfg.AttachmentMaxSize) {
+			return fmt.Errorf("attachment %q exceeds %d bytes", a.FileName, cfg.AttachmentMaxSize)
+		}
+		if !attachmentTypeAllowed(a.MimeType) {
+			return fmt.Errorf("attachment type %q is not accepted", a.MimeType)
+		}
+		if encrypted != (a.Nonce != "") {
+			return errors.New("attachments carry a nonce exactly when their message is encrypted")
+		}
+		if (a.WrappedKey != "") != (a.KeyNonce != "") || (a.WrappedKey != "" && !encrypted) {
+			return errors.New("a wrapped attachment key needs a key nonce and an encrypted message")
+		}
+	}
+	return nil
+}
+
+// announceAttachments records the attachments of a pushed message and returns where to
+// upload them. A re-pushed message keeps the attachments it already has, so the phone
+// learns the offsets to resume from.
+func (h *MessagesHandler) announceAttachments(msg *models.SyncedMessage, specs []AttachmentSpec) ([]AttachmentUpload, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	var attachments []models.MessageAttachment
+	if err := h.db.Where("message_id = ?", msg.ID).Order("position ASC").Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		for i, spec := range specs {
+			a := models.MessageAttachment{
+				UserID:    msg.UserID,
+				MessageID: msg.ID,
+				Position:  i,
+				FileName:  spec.FileName,
+				MimeType:  strings.ToLower(spec.MimeType),
+				Size:      spec.Size,
+				Encrypted: spec.Nonce != "",
+			}
+			if spec.Nonce != "" {
+				nonce := spec.Nonce
+				a.Nonce = &nonce
+			}
+			if spec.WrappedKey != "" {
+				wrappedKey, keyNonce := spec.WrappedKey, spec.KeyNonce
+				a.WrappedKey, a.KeyNonce = &wrappedKey, &keyNonce
+			}
+			attachments = append(attachments, a)
+		}
+		if err := h.db.Create(&attachments).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	uploads := make([]AttachmentUpload, 0, len(attachments))
+	for _, a := range attachments {
+		uploads = append(uploads, AttachmentUpload{
+			ID:        a.ID.String(),
+			Position:  a.Position,
+			Status:    a.Status,
+			Size:      a.Size,
+			Offset:    a.UploadedSize,
+			UploadURL: "/api/messages/attachments/" + a.ID.String(),
+		})
+	}
+	return uploads, nil
+}
+
+// attachmentUploadHeaders reports upload progress in the resumable upload headers.
+func attachmentUploadHeaders(c *gin.Context, a *models.MessageAttachment) {
+	c.Header("Upload-Offset", strconv.FormatInt(a.UploadedSize, 10))
+	c.Header("Upload-Length", strconv.FormatInt(a.Size, 10))
+	c.Header("Cache-Control", "no-store")
+}
+
+// AttachmentUploadStatus answers HEAD with the offset an interrupted upload resumes from.
+func (h *MessagesHandler) AttachmentUploadStatus(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var a models.MessageAttachment
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userIDStr).First(&a).Error; err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	attachmentUploadHeaders(c, &a)
+	c.Status(http.StatusOK)
+}
+
+// UploadAttachment appends a chunk of an attachment (PATCH with an Upload-Offset header).
+// A chunk at the wrong offset is rejected with 409 and the current offset, so clients
+// resume after an interruption by asking HEAD or replaying from the returned offset. The
+// last chunk completes the upload: images get a thumbnail and clients are notified.
+func (h *MessagesHandler) UploadAttachment(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header required"})
+		return
+	}
+
+	var a models.MessageAttachment
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// The row lock serializes chunks of one attachment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", c.Param("id"), userIDStr).First(&a).Error; err != nil {
+			return err
+		}
+		if a.Status != models.AttachmentUploading {
+			return errAttachmentComplete
+		}
+		if offset != a.UploadedSize {
+			return errAttachmentOffset
+		}
+
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, a.Size-a.UploadedSize)
+		size, err := h.blobs.Append(c.Request.Context(), a.StorageKey, offset, body)
+		if err != nil {
+			return err
+		}
+
+		a.UploadedSize = size
+		updates := map[string]interface{}{"uploaded_size": size}
+		if size == a.Size {
+			h.finishAttachment(c, &a)
+			now := time.Now()
+			a.Status = models.AttachmentReady
+			a.CompletedAt = &now
+			updates["status"] = a.Status
+			updates["completed_at"] = now
+			updates["mime_type"] = a.MimeType
+			updates["thumbnail_key"] = a.ThumbnailKey
+			updates["width"] = a.Width
+			updates["height"] = a.Height
+		}
+		return tx.Model(&a).Updates(updates).Error
+	})
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		case err == errAttachmentComplete:
+			attachmentUploadHeaders(c, &a)
+			c.JSON(http.StatusConflict, gin.H{"error": "Attachment already uploaded"})
+		case err == errAttachmentOffset:
+			attachmentUploadHeaders(c, &a)
+			c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match", "offset": a.UploadedSize})
+		case errors.As(err, &tooLarge):
+			attachmentUploadHeaders(c, &a)
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk exceeds the announced attachment size"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store attachment"})
+		}
+		return
+	}
+
+	attachmentUploadHeaders(c, &a)
+	if a.Status == models.AttachmentReady {
+		// Chunks are stored as plaintext so uploads resume at any offset, and sealed once the
+		// upload is committed; the reencrypt command seals any left behind by a failure here
+		if _, err := atrest.SealStoredBlob(c.Request.Context(), h.blobs, a.UserID, a.StorageKey); err != nil {
+			log.Printf("Failed to seal attachment %s: %v", a.ID, err)
+		}
+		h.signAttachment(&a)
+		h.hub.Publish(userIDStr, events.Event{
+			Type:  EventAttachmentReady,
+			Scope: models.ScopeMessagesRead,
+			Data:  a,
+		})
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// finishAttachment checks the content of a completed upload. A declared image type the
+// content does not match is replaced by the sniffed type, so it is neither thumbnailed
+// nor served inline as an image. Encrypted content is opaque and kept as announced.
+func (h *MessagesHandler) finishAttachment(c *gin.Context, a *models.MessageAttachment) {
+	if a.Encrypted {
+		return
+	}
+	r, err := h.blobs.Open(c.Request.Context(), a.StorageKey)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	detected, err := media.DetectType(r)
+	if err != nil {
+		return
+	}
+	if strings.HasPrefix(a.MimeType, "image/") && detected != a.MimeType {
+		a.MimeType = detected
+	}
+	if !media.IsImage(a.MimeType) {
+		return
+	}
+
+	thumb, width, height, err := media.Thumbnail(r)
+	if err != nil {
+		return // Served without a thumbnail
+	}
+	key := a.StorageKey + ".thumb"
+	if err := atrest.PutBlob(c.Request.Context(), h.blobs, a.UserID, key, thumb); err != nil {
+		return
+	}
+	a.ThumbnailKey = &key
+	a.Width, a.Height = width, height
+}
+
+// GetAttachment returns an attachment's metadata and upload state, with download URLs
+// once it is ready.
+func (h *MessagesHandler) GetAttachment(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var a models.MessageAttachment
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userIDStr).First(&a).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	h.signAttachment(&a)
+	c.JSON(http.StatusOK, a)
+}
+
+// loadAttachments fills in the attachments of messages, with signed download URLs.
+func (h *MessagesHandler) loadAttachments(messages []models.SyncedMessage) {
+	if len(messages) == 0 {
+		return
+	}
+	ids := make([]uuid.UUID, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	var attachments []models.MessageAttachment
+	if err := h.db.Where("message_id IN ?", ids).Order("position ASC").Find(&attachments).Error; err != nil {
+		return
+	}
+	byMessage := make(map[uuid.UUID][]models.MessageAttachment, len(messages))
+	for _, a := range attachments {
+		h.signAttachment(&a)
+		byMessage[a.MessageID] = append(byMessage[a.MessageID], a)
+	}
+	for i := range messages {
+		messages[i].Attachments = byMessage[messages[i].ID]
+	}
+}
+
+// signAttachment sets short-lived signed download URLs on a ready attachment.
+func (h *MessagesHandler) signAttachment(a *models.MessageAttachment) {
+	if a.Status != models.AttachmentReady {
+		return
+	}
+	expiresAt := signedURLExpiry()
+	a.URLExpiresAt = &expiresAt
+	a.URL = signedURL("/api/attachments/"+a.ID.String()+"/"+variantOriginal, expiresAt)
+	if a.ThumbnailKey != nil {
+		a.ThumbnailURL = signedURL("/api/attachments/"+a.ID.String()+"/"+variantThumbnail, expiresAt)
+	}
+}
+
+// DownloadAttachment serves attachment content from a signed URL. It needs no other
+// authentication. Range requests are supported for media players.
+func (h *MessagesHandler) DownloadAttachment(c *gin.Context) {
+	expiresAt, ok := verifySignedURL(c)
+	if !ok {
+		return
+	}
+	id, variant := c.Param("id"), c.Param("variant")
+
+	var a models.MessageAttachment
+	if err := h.db.Where("id = ? AND status = ?", id, models.AttachmentReady).First(&a).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	key, contentType := a.StorageKey, a.MimeType
+	switch variant {
+	case variantOriginal:
+	case variantThumbnail:
+		if a.ThumbnailKey == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment has no thumbnail"})
+			return
+		}
+		key, contentType = *a.ThumbnailKey, "image/jpeg"
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown variant"})
+		return
+	}
+
+	r, err := atrest.OpenBlob(c.Request.Context(), h.blobs, key)
+	if err == blob.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment content missing"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read attachment"})
+		return
+	}
+	defer r.Close()
+
+	if a.Encrypted {
+		contentType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if !a.Encrypted && (media.IsImage(contentType) || strings.HasPrefix(contentType, "video/") || strings.HasPrefix(contentType, "audio/")) {
+		disposition = "inline"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, strings.ReplaceAll(a.FileName, `"`, "")))
+	c.Header("X-Content-Type-Options", "nosniff")
+	privateCacheUntil(c, expiresAt)
+	http.ServeContent(c.Writer, c.Request, "", a.UpdatedAt, r)
+}
@@ -2,11 +2,10 @@ package handlers
 
 import (
 	"net/http"
-	"time"
 
-	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/auth"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -33,11 +32,67 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	})
 }
 
+// Logout revokes the refresh token family of the calling device. The family is taken
+// from the access token, or from a refresh token in the body for tokens without one.
 func (h *AuthHandler) Logout(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	familyID := uuid.Nil
+	if fid, ok := c.Get("familyId"); ok {
+		if parsed, err := uuid.Parse(fid.(string)); err == nil {
+			familyID = parsed
+		}
+	}
+	if familyID == uuid.Nil && req.RefreshToken != "" {
+		if fid, err := auth.FamilyOf(h.db, userIDStr, req.RefreshToken); err == nil {
+			familyID = fid
+		}
+	}
+
+	if familyID != uuid.Nil {
+		if err := auth.RevokeFamily(h.db, userIDStr, familyID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
+// Refresh exchanges a refresh token for a new access/refresh token pair (rotation).
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refreshToken is required"})
+		return
+	}
+
+	tokens, err := auth.Refresh(h.db, req.RefreshToken)
+	if err != nil {
+		switch err {
+		case auth.ErrRefreshTokenReused:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected; session revoked"})
+		case auth.ErrInvalidRefreshToken:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Me returns the caller's Better-Auth profile, loading it from the user table when the
+// authenticator did not already attach it.
 func (h *AuthHandler) Me(c *gin.Context) {
 	userID, exists := c.Get("userId")
 	if !exists {
@@ -47,22 +102,20 @@ func (h *AuthHandler) Me(c *gin.Context) {
 
 	userIDStr := userID.(string)
 
-
-	c.JSON(http.StatusOK, gin.H{
-		"id": userIDStr,
-		"message": "User details are managed by Better-Auth. Use /api/auth/me for full user info",
-	})
-}
-
-func (h *AuthHandler) generateToken(userID string) string {
-	claims := jwt.MapClaims{
-		"userId": userID,
-		"exp":    time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
-		"iat":    time.Now().Unix(),
+	if user, ok := c.Get("user"); ok {
+		c.JSON(http.StatusOK, user)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, _ := token.SignedString([]byte(config.Get().JWTSecret))
+	profile, err := auth.LoadUserProfile(h.db, userIDStr)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+		return
+	}
 
-	return tokenString
+	c.JSON(http.StatusOK, profile)
 }
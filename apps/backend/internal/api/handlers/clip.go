@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"clipsync/backend/internal/atrest"
 	"clipsync/backend/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -19,9 +20,10 @@ func NewClipHandler(db *gorm.DB) *ClipHandler {
 }
 
 type CreateClipRequest struct {
-	Content    string   `json:"content" binding:"required"`
+	Content    string   `json:"content" binding:"required"` // Ciphertext when encrypted
 	DeviceName string   `json:"deviceName"`
 	Tags       []string `json:"tags"`
+	E2EContent
 }
 
 type UpdateClipRequest struct {
@@ -39,11 +41,20 @@ func (h *ClipHandler) GetClips(c *gin.Context) {
 	pageSize := c.DefaultQuery("pageSize", "20")
 	search := c.Query("search")
 	favorite := c.Query("favorite")
+	encrypted := c.Query("encrypted")
+	blind, err := parseBlindQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	query := h.db.Where("user_id = ?", userIDStr)
+	query := h.db.Scopes(unexpiredClips).Where("user_id = ?", userIDStr)
 
-	if search != "" {
-		query = query.Where("content ILIKE ? OR content_preview ILIKE ?", "%"+search+"%", "%"+search+"%")
+	// Plaintext search only sees plaintext clips; encrypted ones match on blind index tokens
+	query = applyContentSearch(query, userIDStr, []string{"content", "content_preview"}, nil, search, blind)
+
+	if encrypted == "true" || encrypted == "false" {
+		query = query.Where("encrypted = ?", encrypted == "true")
 	}
 
 	if favorite == "true" {
@@ -80,7 +91,7 @@ func (h *ClipHandler) GetClip(c *gin.Context) {
 	clipID := c.Param("id")
 
 	var clip models.Clip
-	if err := h.db.Where("id = ? AND user_id = ?", clipID, userID).First(&clip).Error; err != nil {
+	if err := h.db.Scopes(unexpiredClips).Where("id = ? AND user_id = ?", clipID, userID).First(&clip).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Clip not found"})
 		return
 	}
@@ -97,26 +108,23 @@ func (h *ClipHandler) CreateClip(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	preview := req.Content
-	if len(preview) > 200 {
-		preview = preview[:200]
+	if err := req.E2EContent.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	clip := models.Clip{
-		ID:            uuid.New(),
-		UserID:        userIDStr,
-		Content:       req.Content,
-		ContentPreview: preview,
-		CopiedAt:      time.Now(),
-		IsFavorite:    false,
-		Tags:          req.Tags,
-		DeviceName:    &req.DeviceName,
-		Synced:        true,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+	policy, err := loadE2EPolicy(h.db, userIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create clip"})
+		return
+	}
+	if err := policy.check(req.E2EContent); err != nil {
+		respondE2EError(c, err)
+		return
 	}
 
+	clip := newClip(userIDStr, req, time.Now())
+
 	if err := h.db.Create(&clip).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create clip"})
 		return
@@ -214,26 +222,25 @@ func (h *ClipHandler) SyncClips(c *gin.Context) {
 		return
 	}
 
-	var synced int
+	policy, err := loadE2EPolicy(h.db, userIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync clips"})
+		return
+	}
 	for _, clipReq := range req.Clips {
-		preview := clipReq.Content
-		if len(preview) > 200 {
-			preview = preview[:200]
+		if err := clipReq.E2EContent.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-
-		clip := models.Clip{
-			ID:            uuid.New(),
-			UserID:        userIDStr,
-			Content:       clipReq.Content,
-			ContentPreview: preview,
-			CopiedAt:      time.Now(),
-			IsFavorite:    false,
-			Tags:          clipReq.Tags,
-			DeviceName:    &clipReq.DeviceName,
-			Synced:        true,
-			CreatedAt:     time.Now(),
-			UpdatedAt:     time.Now(),
+		if err := policy.check(clipReq.E2EContent); err != nil {
+			respondE2EError(c, err)
+			return
 		}
+	}
+
+	var synced int
+	for _, clipReq := range req.Clips {
+		clip := newClip(userIDStr, clipReq, time.Now())
 
 		if err := h.db.Create(&clip).Error; err == nil {
 			synced++
@@ -256,6 +263,47 @@ func (h *ClipHandler) SyncClips(c *gin.Context) {
 	})
 }
 
+// EncryptClips replaces existing plaintext clips with client-encrypted content, for
+// migrating a history to end-to-end mode.
+func (h *ClipHandler) EncryptClips(c *gin.Context) {
+	encryptInPlace(c, h.db, &models.Clip{}, "content", "content_preview")
+}
+
+// unexpiredClips hides short-lived clips past their expiry.
+func unexpiredClips(db *gorm.DB) *gorm.DB {
+	return db.Where("expires_at IS NULL OR expires_at > ?", time.Now())
+}
+
+// newClip builds a clip from a create request. Encrypted clips get no server-side preview
+// or search index.
+func newClip(userID string, req CreateClipRequest, copiedAt time.Time) models.Clip {
+	clip := models.Clip{
+		ID:         uuid.New(),
+		UserID:     userID,
+		Content:    req.Content,
+		CopiedAt:   copiedAt,
+		IsFavorite: false,
+		Tags:       req.Tags,
+		DeviceName: &req.DeviceName,
+		Synced:     true,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if req.Encrypted {
+		nonce := req.Nonce
+		clip.Encrypted = true
+		clip.Nonce = &nonce
+		clip.BlindIndex = blindIndexString(req.BlindIndex)
+	} else {
+		clip.ContentPreview = req.Content
+		if len(clip.ContentPreview) > 200 {
+			clip.ContentPreview = clip.ContentPreview[:200]
+		}
+		clip.SearchIndex = atrest.SearchIndex(userID, req.Content)
+	}
+	return clip
+}
+
 func parseInt(s string) int {
 	var result int
 	for _, char := range s {
@@ -0,0 +1,537 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"clipsync/backend/internal/atrest"
+	"clipsync/backend/internal/blob"
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/events"
+	"clipsync/backend/internal/media"
+	"clipsync/backend/internal/models"
+	"clipsync/backend/internal/phone"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EventContactsChanged is published after a contacts sync changed anything, so clients
+// refresh the names they show.
+const EventContactsChanged = "contacts.changed"
+
+const (
+	// maxContactPhoto bounds a decoded contact photo.
+	maxContactPhoto = 256 << 10
+	// maxContactsBatch bounds the contacts and deletions of one sync request.
+	maxContactsBatch = 500
+)
+
+type ContactsHandler struct {
+	db    *gorm.DB
+	hub   *events.Hub
+	blobs blob.Store
+}
+
+func NewContactsHandler(db *gorm.DB, hub *events.Hub, blobs blob.Store) *ContactsHandler {
+	return &ContactsHandler{db: db, hub: hub, blobs: blobs}
+}
+
+type ContactAddressItem struct {
+	Value string `json:"value" binding:"required,max=255"`
+	Label string `json:"label" binding:"max=64"`
+}
+
+// ContactItem is a new or changed contact. Photo is base64 JPEG or PNG; omit it to keep
+// the stored photo and send "" to remove it.
+type ContactItem struct {
+	Key         string               `json:"key" binding:"required,max=255"`
+	DisplayName string               `json:"displayName" binding:"max=255"`
+	Phones      []ContactAddressItem `json:"phones" binding:"max=32,dive"`
+	Emails      []ContactAddressItem `json:"emails" binding:"max=32,dive"`
+	Photo       *string              `json:"photo"`
+}
+
+// SyncContactsRequest carries contacts changed on the phone since its last sync and the
+// keys of deleted ones. A full sync sends every contact over one or more requests with the
+// same generation; the request with complete set deletes contacts the generation did not
+// include.
+type SyncContactsRequest struct {
+	DeviceID   string        `json:"deviceId" binding:"required"`
+	Contacts   []ContactItem `json:"contacts" binding:"dive"`
+	Deleted    []string      `json:"deleted"`
+	Generation string        `json:"generation" binding:"max=64"`
+	Complete   bool          `json:"complete"`
+}
+
+var errPhotoInvalid = errors.New("photo must be a base64 JPEG or PNG of at most 256 KiB")
+
+// decodeContactPhoto validates a pushed photo. A nil result removes the photo.
+func decodeContactPhoto(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	if base64.StdEncoding.DecodedLen(len(encoded)) > maxContactPhoto+3 {
+		return nil, errPhotoInvalid
+	}
+	photo, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(photo) > maxContactPhoto {
+		return nil, errPhotoInvalid
+	}
+	switch mimeType, _ := media.DetectType(bytes.NewReader(photo)); mimeType {
+	case "image/jpeg", "image/png":
+		return photo, nil
+	}
+	return nil, errPhotoInvalid
+}
+
+// Sync applies a batch of contact changes from the phone.
+func (h *ContactsHandler) Sync(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req SyncContactsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Contacts)+len(req.Deleted) > maxContactsBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d contacts and deletions per request", maxContactsBatch)})
+		return
+	}
+	if req.Complete && req.Generation == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "complete requires a generation"})
+		return
+	}
+	photos := make(map[int][]byte)
+	for i, item := range req.Contacts {
+		if item.Photo == nil {
+			continue
+		}
+		photo, err := decodeContactPhoto(*item.Photo)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		photos[i] = photo
+	}
+
+	region := config.Get().DefaultPhoneRegion
+	var upserted, deleted int64
+	var photoChanges []contactPhotoChange
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		for i, item := range req.Contacts {
+			var contact models.Contact
+			err := tx.Unscoped().Where("user_id = ? AND contact_key = ?", userIDStr, item.Key).First(&contact).Error
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return err
+			}
+			if err == gorm.ErrRecordNotFound {
+				contact = models.Contact{ID: uuid.New(), UserID: userIDStr, Key: item.Key}
+			}
+			contact.DeviceID = req.DeviceID
+			contact.DisplayName = item.DisplayName
+			contact.SearchIndex = atrest.SearchIndex(userIDStr, item.DisplayName)
+			contact.DeletedAt = gorm.DeletedAt{}
+			if req.Generation != "" {
+				contact.Generation = req.Generation
+			}
+
+			if photo, ok := photos[i]; ok {
+				photoChanges = append(photoChanges, setContactPhoto(&contact, photo))
+			}
+
+			// Saving unscoped revives tombstones; addresses are replaced below
+			if contact.CreatedAt.IsZero() {
+				err = tx.Create(&contact).Error
+			} else {
+				err = tx.Unscoped().Omit(clause.Associations).Save(&contact).Error
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.Where("contact_id = ?", contact.ID).Delete(&models.ContactAddress{}).Error; err != nil {
+				return err
+			}
+			var addresses []models.ContactAddress
+			for _, p := range item.Phones {
+				addresses = append(addresses, contactAddress(contact, models.ContactPhone, p, region))
+			}
+			for _, e := range item.Emails {
+				addresses = append(addresses, contactAddress(contact, models.ContactEmail, e, region))
+			}
+			if len(addresses) > 0 {
+				if err := tx.Create(&addresses).Error; err != nil {
+					return err
+				}
+			}
+			upserted++
+		}
+
+		if len(req.Deleted) > 0 {
+			n, err := deleteContacts(tx, tx.Where("user_id = ? AND contact_key IN ?", userIDStr, req.Deleted))
+			if err != nil {
+				return err
+			}
+			deleted += n
+		}
+		if req.Complete {
+			n, err := deleteContacts(tx, tx.Where("user_id = ? AND (generation IS NULL OR generation <> ?)", userIDStr, req.Generation))
+			if err != nil {
+				return err
+			}
+			deleted += n
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync contacts"})
+		return
+	}
+	photosStored := h.applyPhotoChanges(c, photoChanges)
+
+	if upserted > 0 || deleted > 0 {
+		h.hub.Publish(userIDStr, events.Event{
+			Type:  EventContactsChanged,
+			Scope: models.ScopeContactsRead,
+			Data:  gin.H{"upserted": upserted, "deleted": deleted},
+		})
+	}
+	if !photosStored {
+		// The rows are committed; syncing the same contacts again stores the photos
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store contact photos"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"upserted": upserted, "deleted": deleted, "syncedAt": time.Now()})
+}
+
+func contactAddress(contact models.Contact, kind string, item ContactAddressItem, region string) models.ContactAddress {
+	return models.ContactAddress{
+		ContactID: contact.ID,
+		UserID:    contact.UserID,
+		Kind:      kind,
+		Value:     item.Value,
+		Label:     item.Label,
+		ThreadKey: phone.Normalize(item.Value, region),
+	}
+}
+
+// contactPhotoChange is a photo write or removal deferred until the contact rows are
+// committed, since the blob store cannot take part in the transaction.
+type contactPhotoChange struct {
+	contactID uuid.UUID
+	userID    string
+	key       string // Blob key of the new photo; "" when it was removed
+	photo     []byte
+	oldKey    string // Photo no longer referenced once the rows are committed
+	oldHash   string
+}
+
+// setContactPhoto points contact at a new photo, or none for a nil photo. Keys include the
+// photo hash, so a failed write never leaves the row pointing at other content and a retry
+// of the same sync writes the same key.
+func setContactPhoto(contact *models.Contact, photo []byte) contactPhotoChange {
+	change := contactPhotoChange{contactID: contact.ID, userID: contact.UserID, oldHash: contact.PhotoHash}
+	if contact.PhotoKey != nil {
+		change.oldKey = *contact.PhotoKey
+	}
+	if photo == nil {
+		contact.PhotoKey = nil
+		contact.PhotoHash = ""
+		return change
+	}
+	sum := sha256.Sum256(photo)
+	hash := hex.EncodeToString(sum[:])
+	key := "contacts/" + contact.ID.String() + "/" + hash + ".photo"
+	contact.PhotoKey = &key
+	contact.PhotoHash = hash
+	change.key, change.photo = key, photo
+	if change.oldKey == key {
+		change.oldKey = ""
+	}
+	return change
+}
+
+// applyPhotoChanges writes the new photos of a committed sync and removes replaced ones.
+// A photo that cannot be stored puts the contact back on its previous photo, so the phone
+// sees a hash other than its own and sends the photo again. It reports whether every
+// photo was stored.
+func (h *ContactsHandler) applyPhotoChanges(c *gin.Context, changes []contactPhotoChange) bool {
+	ctx := c.Request.Context()
+	stored := true
+	for _, change := range changes {
+		if change.key != "" {
+			if err := atrest.PutBlob(ctx, h.blobs, change.userID, change.key, change.photo); err != nil {
+				stored = false
+				h.revertPhoto(change)
+				continue
+			}
+		}
+		if change.oldKey != "" {
+			h.blobs.Delete(ctx, change.oldKey)
+		}
+	}
+	return stored
+}
+
+// revertPhoto restores the photo a contact had before a change whose write failed, or
+// none, unless a later sync has replaced it since.
+func (h *ContactsHandler) revertPhoto(change contactPhotoChange) {
+	updates := map[string]interface{}{"photo_key": nil, "photo_hash": "", "updated_at": time.Now()}
+	if change.oldKey != "" {
+		updates["photo_key"], updates["photo_hash"] = change.oldKey, change.oldHash
+	}
+	if err := h.db.Model(&models.Contact{}).Unscoped().
+		Where("id = ? AND photo_key = ?", change.contactID, change.key).
+		Updates(updates).Error; err != nil {
+		log.Printf("Failed to revert photo of contact %s: %v", change.contactID, err)
+	}
+}
+
+// deleteContacts turns the contacts matched by query into tombstones and drops their
+// addresses, so they stop resolving senders. Photos are kept until the contact is cleared.
+func deleteContacts(tx *gorm.DB, query *gorm.DB) (int64, error) {
+	var ids []uuid.UUID
+	if err := query.Model(&models.Contact{}).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := tx.Where("contact_id IN ?", ids).Delete(&models.ContactAddress{}).Error; err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	result := tx.Model(&models.Contact{}).Where("id IN ?", ids).
+		Updates(map[string]interface{}{"deleted_at": now, "updated_at": now})
+	return result.RowsAffected, result.Error
+}
+
+// List returns contacts. With ?since= it returns changes after that time instead, oldest
+// first and including tombstones (deletedAt set), for clients keeping a local copy; later
+// pages and polls pass the returned nextCursor as ?cursor=.
+func (h *ContactsHandler) List(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	if c.Query("since") != "" || c.Query("cursor") != "" {
+		start, err := parseFeedStart(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var contacts []models.Contact
+		query := h.db.Unscoped().Preload("Addresses").Where("user_id = ?", userIDStr)
+		if err := start.after(query, "updated_at").Limit(maxStateChanges).Find(&contacts).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch contacts"})
+			return
+		}
+		signContactPhotos(contacts)
+
+		next := start
+		if len(contacts) > 0 {
+			last := contacts[len(contacts)-1]
+			next = keysetCursor{At: last.UpdatedAt, ID: last.ID}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data":       contacts,
+			"hasMore":    len(contacts) == maxStateChanges,
+			"nextCursor": next.String(),
+		})
+		return
+	}
+
+	page := parseInt(c.DefaultQuery("page", "1"))
+	limit := parseInt(c.DefaultQuery("pageSize", "50"))
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > maxMessagesPageSize {
+		limit = maxMessagesPageSize
+	}
+
+	query := h.db.Model(&models.Contact{}).Where("user_id = ?", userIDStr)
+	var total int64
+	query.Count(&total)
+
+	var contacts []models.Contact
+	if err := query.Preload("Addresses").Order("created_at ASC, id ASC").
+		Offset((page - 1) * limit).Limit(limit).Find(&contacts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch contacts"})
+		return
+	}
+	signContactPhotos(contacts)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       contacts,
+		"total":      total,
+		"page":       page,
+		"pageSize":   limit,
+		"totalPages": (int(total) + limit - 1) / limit,
+	})
+}
+
+// Get returns one contact.
+func (h *ContactsHandler) Get(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var contact models.Contact
+	if err := h.db.Preload("Addresses").Where("id = ? AND user_id = ?", c.Param("id"), userIDStr).
+		First(&contact).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contact not found"})
+		return
+	}
+	contacts := []models.Contact{contact}
+	signContactPhotos(contacts)
+	c.JSON(http.StatusOK, contacts[0])
+}
+
+// ClearAll deletes every contact of the user, tombstones and photos included.
+func (h *ContactsHandler) ClearAll(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var photoKeys []string
+	h.db.Unscoped().Model(&models.Contact{}).Where("user_id = ? AND photo_key IS NOT NULL", userIDStr).
+		Pluck("photo_key", &photoKeys)
+
+	var deleted int64
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userIDStr).Delete(&models.ContactAddress{}).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("user_id = ?", userIDStr).Delete(&models.Contact{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear contacts"})
+		return
+	}
+	for _, key := range photoKeys {
+		h.blobs.Delete(c.Request.Context(), key)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "All contacts cleared", "deleted": deleted})
+}
+
+// Photo serves a contact photo from a signed URL.
+func (h *ContactsHandler) Photo(c *gin.Context) {
+	expiresAt, ok := verifySignedURL(c)
+	if !ok {
+		return
+	}
+
+	var contact models.Contact
+	if err := h.db.Where("id = ? AND photo_key IS NOT NULL", c.Param("id")).First(&contact).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
+		return
+	}
+	r, err := atrest.OpenBlob(c.Request.Context(), h.blobs, *contact.PhotoKey)
+	if err == blob.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read photo"})
+		return
+	}
+	defer r.Close()
+
+	contentType, err := media.DetectType(r)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read photo"})
+		return
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	privateCacheUntil(c, expiresAt)
+	http.ServeContent(c.Writer, c.Request, "", contact.UpdatedAt, r)
+}
+
+func contactPhotoURL(contact *models.Contact, expiresAt time.Time) string {
+	if contact.PhotoKey == nil || contact.DeletedAt.Valid {
+		return ""
+	}
+	return signedURL("/api/contact-photos/"+contact.ID.String(), expiresAt)
+}
+
+func signContactPhotos(contacts []models.Contact) {
+	expiresAt := signedURLExpiry()
+	for i := range contacts {
+		contacts[i].PhotoURL = contactPhotoURL(&contacts[i], expiresAt)
+		if contacts[i].Addresses == nil {
+			contacts[i].Addresses = []models.ContactAddress{}
+		}
+	}
+}
+
+// resolveContacts maps message thread keys to the user's contacts, matching the
+// normalized addresses. When several contacts share an address the most recently
+// updated one wins.
+func resolveContacts(db *gorm.DB, userID string, threadKeys []string) map[string]*models.Contact {
+	resolved := make(map[string]*models.Contact)
+	if len(threadKeys) == 0 {
+		return resolved
+	}
+
+	var matches []struct {
+		ThreadKey string
+		ContactID uuid.UUID
+	}
+	if err := db.Raw(`SELECT DISTINCT ON (a.thread_key) a.thread_key, a.contact_id
+		FROM contact_addresses a JOIN contacts c ON c.id = a.contact_id AND c.deleted_at IS NULL
+		WHERE a.user_id = ? AND a.thread_key IN ?
+		ORDER BY a.thread_key, c.updated_at DESC`, userID, threadKeys).Scan(&matches).Error; err != nil || len(matches) == 0 {
+		return resolved
+	}
+
+	ids := make([]uuid.UUID, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, m.ContactID)
+	}
+	var contacts []models.Contact
+	if err := db.Where("id IN ?", ids).Find(&contacts).Error; err != nil {
+		return resolved
+	}
+	expiresAt := signedURLExpiry()
+	byID := make(map[uuid.UUID]*models.Contact, len(contacts))
+	for i := range contacts {
+		contacts[i].PhotoURL = contactPhotoURL(&contacts[i], expiresAt)
+		byID[contacts[i].ID] = &contacts[i]
+	}
+	for _, m := range matches {
+		if contact, ok := byID[m.ContactID]; ok {
+			resolved[m.ThreadKey] = contact
+		}
+	}
+	return resolved
+}
+
+// resolveSenders sets the contact name of each message sender.
+func resolveSenders(db *gorm.DB, userID string, messages []models.SyncedMessage) {
+	keys := make([]string, 0, len(messages))
+	seen := make(map[string]bool, len(messages))
+	for _, m := range messages {
+		if m.ThreadKey != "" && !seen[m.ThreadKey] {
+			seen[m.ThreadKey] = true
+			keys = append(keys, m.ThreadKey)
+		}
+	}
+	contacts := resolveContacts(db, userID, keys)
+	for i := range messages {
+		if contact, ok := contacts[messages[i].ThreadKey]; ok {
+			messages[i].SenderName = contact.DisplayName
+			messages[i].ContactID = contact.ID.String()
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Conversation is one message thread: every message whose address normalizes to ThreadKey.
+type Conversation struct {
+	ThreadKey      string                `json:"threadKey"`
+	Address        string                `json:"address"`     // Address as last received
+	DisplayName    string                `json:"displayName"` // Contact name, else the latest sender name, else the address
+	ContactID      string                `json:"contactId,omitempty"`
+	PhotoURL       string                `json:"photoUrl,omitempty"` // Signed URL of the contact photo
+	LastMessage    *models.SyncedMessage `json:"lastMessage"`
+	MessageCount   int64                 `json:"messageCount"`
+	UnreadCount    int64                 `json:"unreadCount"`
+	Starred        bool                  `json:"starred"` // Exempt from message retention
+	LastReceivedAt time.Time             `json:"lastReceivedAt"`
+}
+
+// ListConversations returns the user's message threads, most recent first. Archived
+// messages are left out unless ?archived= says otherwise, so fully archived threads disappear.
+func (h *MessagesHandler) ListConversations(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	page := parseInt(c.DefaultQuery("page", "1"))
+	limit := parseInt(c.DefaultQuery("pageSize", "50"))
+	if limit > maxMessagesPageSize {
+		limit = maxMessagesPageSize
+	}
+
+	// Filters (unread, archived) apply to the messages a thread is built from
+	scope := func(db *gorm.DB) *gorm.DB {
+		return applyMessageFilters(c, db.Where("user_id = ?", userIDStr))
+	}
+
+	var total int64
+	h.db.Model(&models.SyncedMessage{}).Scopes(scope).Distinct("thread_key").Count(&total)
+
+	var stats []struct {
+		ThreadKey      string
+		MessageCount   int64
+		UnreadCount    int64
+		LastReceivedAt time.Time
+	}
+	if err := h.db.Model(&models.SyncedMessage{}).
+		Select("thread_key, COUNT(*) AS message_count, COUNT(*) FILTER (WHERE read_at IS NULL) AS unread_count, MAX(received_at) AS last_received_at").
+		Scopes(scope).
+		Group("thread_key").
+		Order("last_received_at DESC").
+		Offset((page - 1) * limit).Limit(limit).
+		Scan(&stats).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+		return
+	}
+
+	keys := make([]string, 0, len(stats))
+	for _, s := range stats {
+		keys = append(keys, s.ThreadKey)
+	}
+
+	// Latest message of each thread
+	lastByThread := make(map[string]*models.SyncedMessage, len(keys))
+	if len(keys) > 0 {
+		latest := h.db.Model(&models.SyncedMessage{}).Scopes(scope).
+			Select("DISTINCT ON (thread_key) id").Where("thread_key IN ?", keys).
+			Order("thread_key, received_at DESC, created_at DESC")
+		var last []models.SyncedMessage
+		if err := h.db.Where("id IN (?)", latest).Find(&last).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+			return
+		}
+		for i := range last {
+			lastByThread[last[i].ThreadKey] = &last[i]
+		}
+	}
+
+	// Latest sender that is a name rather than a number
+	names := make(map[string]string, len(keys))
+	if len(keys) > 0 {
+		var rows []struct {
+			ThreadKey string
+			Sender    string
+		}
+		h.db.Raw(`SELECT DISTINCT ON (thread_key) thread_key, sender FROM synced_messages
+			WHERE user_id = ? AND thread_key IN ? AND sender <> '' AND sender !~ '^[+0-9 ().-]+$'
+			ORDER BY thread_key, received_at DESC`, userIDStr, keys).Scan(&rows)
+		for _, r := range rows {
+			names[r.ThreadKey] = r.Sender
+		}
+	}
+
+	starred := make(map[string]bool, len(keys))
+	if len(keys) > 0 {
+		var starredKeys []string
+		h.db.Model(&models.StarredThread{}).Where("user_id = ? AND thread_key IN ?", userIDStr, keys).
+			Pluck("thread_key", &starredKeys)
+		for _, k := range starredKeys {
+			starred[k] = true
+		}
+	}
+
+	contacts := resolveContacts(h.db, userIDStr, keys)
+
+	conversations := make([]Conversation, 0, len(stats))
+	for _, s := range stats {
+		conv := Conversation{
+			ThreadKey:      s.ThreadKey,
+			DisplayName:    names[s.ThreadKey],
+			LastMessage:    lastByThread[s.ThreadKey],
+			MessageCount:   s.MessageCount,
+			UnreadCount:    s.UnreadCount,
+			Starred:        starred[s.ThreadKey],
+			LastReceivedAt: s.LastReceivedAt,
+		}
+		if conv.LastMessage != nil {
+			conv.Address = conv.LastMessage.Address
+		}
+		if contact, ok := contacts[s.ThreadKey]; ok {
+			conv.ContactID = contact.ID.String()
+			conv.PhotoURL = contact.PhotoURL
+			if contact.DisplayName != "" {
+				conv.DisplayName = contact.DisplayName
+			}
+			if conv.LastMessage != nil {
+				conv.LastMessage.SenderName = contact.DisplayName
+				conv.LastMessage.ContactID = conv.ContactID
+			}
+		}
+		if conv.DisplayName == "" {
+			conv.DisplayName = conv.Address
+		}
+		conversations = append(conversations, conv)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       conversations,
+		"total":      total,
+		"page":       page,
+		"pageSize":   limit,
+		"totalPages": (int(total) + limit - 1) / limit,
+	})
+}
+
+// ListThreadMessages returns one thread's messages, newest first unless sorted otherwise.
+// The thread key is the normalized address from ListConversations (URL-encoded, since it
+// usually starts with "+"). It takes the search parameters of List.
+func (h *MessagesHandler) ListThreadMessages(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+	threadKey := c.Param("threadKey")
+	order, err := messageOrder(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page := parseInt(c.DefaultQuery("page", "1"))
+	limit := parseInt(c.DefaultQuery("pageSize", "50"))
+	if limit > maxMessagesPageSize {
+		limit = maxMessagesPageSize
+	}
+
+	query := applyMessageFilters(c, h.db.Model(&models.SyncedMessage{}).Where("user_id = ? AND thread_key = ?", userIDStr, threadKey))
+	query, err = applyMessageSearch(c, query, userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total int64
+	query.Count(&total)
+	if total == 0 {
+		// Filters may leave nothing of a thread that exists
+		var exists int64
+		h.db.Model(&models.SyncedMessage{}).Where("user_id = ? AND thread_key = ?", userIDStr, threadKey).Limit(1).Count(&exists)
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+	}
+
+	var messages []models.SyncedMessage
+	if err := query.Order(order).
+		Offset((page - 1) * limit).Limit(limit).Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+	h.loadAttachments(messages)
+	resolveSenders(h.db, userIDStr, messages)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       messages,
+		"total":      total,
+		"page":       page,
+		"pageSize":   limit,
+		"totalPages": (int(total) + limit - 1) / limit,
+	})
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// keysetCursor is a position in a change feed ordered by (timestamp, id). Bulk updates
+// stamp many rows with the same time, so paging on the timestamp alone would skip the
+// rest of a batch once a page ends inside it.
+type keysetCursor struct {
+	At time.Time
+	ID uuid.UUID
+}
+
+// String encodes the cursor as an opaque token for the client to send back.
+func (k keysetCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(k.At.UTC().Format(time.RFC3339Nano) + "|" + k.ID.String()))
+}
+
+func parseKeysetCursor(s string) (keysetCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return keysetCursor{}, errInvalidCursor
+	}
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return keysetCursor{}, errInvalidCursor
+	}
+	k := keysetCursor{}
+	if k.At, err = time.Parse(time.RFC3339Nano, at); err != nil {
+		return keysetCursor{}, errInvalidCursor
+	}
+	if k.ID, err = uuid.Parse(id); err != nil {
+		return keysetCursor{}, errInvalidCursor
+	}
+	return k, nil
+}
+
+// parseFeedStart reads where a change feed resumes: ?cursor= from a previous page, or
+// ?since= (RFC3339) to start after a point in time.
+func parseFeedStart(c *gin.Context) (keysetCursor, error) {
+	if cursor := c.Query("cursor"); cursor != "" {
+		return parseKeysetCursor(cursor)
+	}
+	since, err := time.Parse(time.RFC3339Nano, c.Query("since"))
+	if err != nil {
+		return keysetCursor{}, errors.New("cursor or since (RFC3339) query param required")
+	}
+	return keysetCursor{At: since, ID: uuid.Nil}, nil
+}
+
+// after restricts query to rows past the cursor on (column, id), in feed order.
+func (k keysetCursor) after(query *gorm.DB, column string) *gorm.DB {
+	return query.Where("("+column+", id) > (?, ?)", k.At, k.ID).Order(column + " ASC, id ASC")
+}
+
+// commitCursor is a position in a change feed ordered by (transaction ID, id). Feeds only
+// return changes of transactions older than every running one (committedBefore), so a
+// change committed late cannot land behind a cursor a reader has already moved past.
+type commitCursor struct {
+	XID int64
+	ID  uuid.UUID
+}
+
+// committedBefore is the oldest transaction still running; every change with a lower
+// transaction ID is final.
+const committedBefore = "pg_snapshot_xmin(pg_current_snapshot())::text::bigint"
+
+// currentXID stamps a change with the transaction making it.
+var currentXID = gorm.Expr("pg_current_xact_id()::text::bigint")
+
+func (k commitCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(k.XID, 10) + "|" + k.ID.String()))
+}
+
+func parseCommitCursor(s string) (commitCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return commitCursor{}, errInvalidCursor
+	}
+	xid, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return commitCursor{}, errInvalidCursor
+	}
+	k := commitCursor{}
+	if k.XID, err = strconv.ParseInt(xid, 10, 64); err != nil || k.XID < 0 {
+		return commitCursor{}, errInvalidCursor
+	}
+	if k.ID, err = uuid.Parse(id); err != nil {
+		return commitCursor{}, errInvalidCursor
+	}
+	return k, nil
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestCommitCursor(t *testing.T) {
+	id := uuid.New()
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name    string
+		cursor  string
+		want    commitCursor
+		wantErr bool
+	}{
+		{"round trip", commitCursor{XID: 7340032, ID: id}.String(), commitCursor{XID: 7340032, ID: id}, false},
+		{"horizon without row", commitCursor{XID: 12}.String(), commitCursor{XID: 12}, false},
+		{"pre-upgrade changes", commitCursor{}.String(), commitCursor{}, false},
+		{"not base64", "***", commitCursor{}, true},
+		{"no separator", encode("12"), commitCursor{}, true},
+		{"negative transaction", encode("-1|" + id.String()), commitCursor{}, true},
+		{"transaction not a number", encode("x|" + id.String()), commitCursor{}, true},
+		{"bad row ID", encode("12|row"), commitCursor{}, true},
+		{"keyset cursor", keysetCursor{At: time.Now(), ID: id}.String(), commitCursor{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCommitCursor(tt.cursor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCommitCursor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseCommitCursor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeedStart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	id := uuid.New()
+	at := time.Date(2026, 3, 1, 12, 30, 0, 123456789, time.UTC)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    keysetCursor
+		wantErr bool
+	}{
+		{"cursor round trip", "cursor=" + keysetCursor{At: at, ID: id}.String(), keysetCursor{At: at, ID: id}, false},
+		{"cursor in another zone", "cursor=" + keysetCursor{At: at.In(time.FixedZone("X", 3600)), ID: id}.String(), keysetCursor{At: at, ID: id}, false},
+		{"since", "since=2026-03-01T12:30:00Z", keysetCursor{At: time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)}, false},
+		{"cursor wins over since", "since=2020-01-01T00:00:00Z&cursor=" + keysetCursor{At: at, ID: id}.String(), keysetCursor{At: at, ID: id}, false},
+		{"neither", "", keysetCursor{}, true},
+		{"bad since", "since=yesterday", keysetCursor{}, true},
+		{"bad cursor", "cursor=***", keysetCursor{}, true},
+		{"commit cursor", "cursor=" + commitCursor{XID: 12, ID: id}.String(), keysetCursor{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/?"+tt.query, nil)
+			got, err := parseFeedStart(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFeedStart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.At.Equal(tt.want.At) || got.ID != tt.want.ID {
+				t.Errorf("parseFeedStart() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"clipsync/backend/internal/atrest"
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// maxBlindIndexTokens bounds the search tokens stored per clip or message.
+	maxBlindIndexTokens = 256
+	// maxE2EMigrationBatch bounds one encrypt-in-place upload.
+	maxE2EMigrationBatch = 500
+)
+
+var (
+	errE2ERequired = errors.New("e2e content required")
+	errE2ENoVault  = errors.New("e2e requires a vault")
+
+	// Blind index tokens are base64url HMACs computed by the client with a key derived
+	// from the vault key, so the server can match them without learning the words.
+	blindIndexToken = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+)
+
+// E2EContent marks a clip or message body as client-side ciphertext under the vault key.
+// When Encrypted is set the body field holds base64 AES-GCM ciphertext.
+type E2EContent struct {
+	Encrypted      bool     `json:"encrypted"`
+	Nonce          string   `json:"nonce" binding:"max=32"`
+	BlindIndex     []string `json:"blindIndex"`     // Search tokens; optional
+	KeyFingerprint string   `json:"keyFingerprint"` // Proves the vault key; required when encrypted
+}
+
+func (e E2EContent) validate() error {
+	if !e.Encrypted {
+		if e.Nonce != "" || len(e.BlindIndex) > 0 {
+			return errors.New("nonce and blindIndex are only valid for encrypted content")
+		}
+		return nil
+	}
+	if e.Nonce == "" {
+		return errors.New("encrypted content requires a nonce")
+	}
+	return validateBlindIndex(e.BlindIndex)
+}
+
+func validateBlindIndex(tokens []string) error {
+	if len(tokens) > maxBlindIndexTokens {
+		return fmt.Errorf("blindIndex has more than %d tokens", maxBlindIndexTokens)
+	}
+	for _, t := range tokens {
+		if !blindIndexToken.MatchString(t) {
+			return errors.New("blindIndex tokens must be 16-64 base64url characters")
+		}
+	}
+	return nil
+}
+
+// blindIndexString stores tokens space-separated and de-duplicated.
+func blindIndexString(tokens []string) string {
+	seen := make(map[string]bool, len(tokens))
+	out := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// e2ePolicy is a user's end-to-end mode, loaded once per write request.
+type e2ePolicy struct {
+	vault *models.UserVault // nil without a vault
+}
+
+func loadE2EPolicy(db *gorm.DB, userID string) (*e2ePolicy, error) {
+	var vault models.UserVault
+	if err := db.Where("user_id = ?", userID).First(&vault).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return &e2ePolicy{}, nil
+		}
+		return nil, err
+	}
+	return &e2ePolicy{vault: &vault}, nil
+}
+
+func (p *e2ePolicy) enabled() bool {
+	return p.vault != nil && p.vault.E2EEnabled
+}
+
+// check rejects plaintext while E2E mode is on, and ciphertext under a key other than the vault key.
+func (p *e2ePolicy) check(e E2EContent) error {
+	if !e.Encrypted {
+		if p.enabled() {
+			return errE2ERequired
+		}
+		return nil
+	}
+	if p.vault == nil {
+		return errE2ENoVault
+	}
+	if !p.vault.MatchesFingerprint(e.KeyFingerprint) {
+		return errKeyMismatch
+	}
+	return nil
+}
+
+// respondE2EError writes the response for an E2E policy error and reports whether it did.
+func respondE2EError(c *gin.Context, err error) bool {
+	switch err {
+	case errE2ERequired:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "End-to-end encryption is enabled; content must be encrypted"})
+	case errE2ENoVault:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Vault not set up"})
+	case errKeyMismatch:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Key fingerprint does not match the vault key"})
+	default:
+		return false
+	}
+	return true
+}
+
+// applyContentSearch filters by a plaintext query and/or blind index tokens. Plaintext
+// rows are matched with ILIKE on columns while stored unsealed, and by whole words through
+// the server search index once sealed at rest; encrypted rows match when they carry every
+// client token. metaColumns are never encrypted (such as a message sender) and match the
+// query on any row, as do the metaConds the caller resolved from the query itself. A row
+// matching either side is returned, so mixed histories search as one list.
+func applyContentSearch(query *gorm.DB, userID string, columns, metaColumns []string, search string, blind []string, metaConds ...clause.Expr) *gorm.DB {
+	var conds []string
+	var args []interface{}
+
+	if search != "" {
+		for _, col := range metaColumns {
+			conds = append(conds, col+" ILIKE ?")
+			args = append(args, "%"+escapeLike(search)+"%")
+		}
+		for _, cond := range metaConds {
+			conds = append(conds, cond.SQL)
+			args = append(args, cond.Vars...)
+		}
+	}
+
+	if search != "" {
+		var plain []string
+		for _, col := range columns {
+			plain = append(plain, "("+col+" NOT LIKE 'atrest:%' AND "+col+" ILIKE ?)")
+			args = append(args, "%"+escapeLike(search)+"%")
+		}
+		if tokens := atrest.SearchTokens(userID, search); len(tokens) > 0 {
+			plain = append(plain, tokenMatch("search_index", tokens, &args))
+		}
+		conds = append(conds, "encrypted = false AND ("+strings.Join(plain, " OR ")+")")
+	}
+
+	if len(blind) > 0 {
+		conds = append(conds, "encrypted = true AND "+tokenMatch("blind_index", blind, &args))
+	}
+
+	if len(conds) == 0 {
+		return query
+	}
+	return query.Where("(("+strings.Join(conds, ") OR (")+"))", args...)
+}
+
+// escapeLike makes LIKE wildcards in s match literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// tokenMatch requires every token in a space-separated token column.
+func tokenMatch(column string, tokens []string, args *[]interface{}) string {
+	conds := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		conds = append(conds, "(' ' || "+column+" || ' ') LIKE ?")
+		*args = append(*args, "% "+strings.ReplaceAll(t, "_", `\_`)+" %")
+	}
+	return "(" + strings.Join(conds, " AND ") + ")"
+}
+
+// parseBlindQuery reads the comma-separated "blind" query parameter.
+func parseBlindQuery(c *gin.Context) ([]string, error) {
+	raw := c.Query("blind")
+	if raw == "" {
+		return nil, nil
+	}
+	tokens := strings.Split(raw, ",")
+	if err := validateBlindIndex(tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// E2EMigrationItem is the ciphertext of one existing plaintext row.
+type E2EMigrationItem struct {
+	ID         string   `json:"id" binding:"required"`
+	Ciphertext string   `json:"ciphertext" binding:"required"`
+	Nonce      string   `json:"nonce" binding:"required,max=32"`
+	BlindIndex []string `json:"blindIndex"`
+}
+
+type E2EMigrationRequest struct {
+	KeyFingerprint string             `json:"keyFingerprint" binding:"required"`
+	Items          []E2EMigrationItem `json:"items" binding:"required,min=1,dive"`
+}
+
+// encryptInPlace replaces plaintext rows of model with the uploaded ciphertext and empties
+// the clear columns derived from the plaintext. Rows that are already encrypted or unknown
+// are skipped, so migrations can resume after a failure.
+func encryptInPlace(c *gin.Context, db *gorm.DB, model interface{}, column string, clearColumns ...string) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req E2EMigrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Items) > maxE2EMigrationBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d items per request", maxE2EMigrationBatch)})
+		return
+	}
+	for _, item := range req.Items {
+		if err := validateBlindIndex(item.BlindIndex); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var converted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		vault, err := lockVault(tx, userIDStr, "SHARE")
+		if err == gorm.ErrRecordNotFound {
+			return errE2ENoVault
+		} else if err != nil {
+			return err
+		}
+		if !vault.MatchesFingerprint(req.KeyFingerprint) {
+			return errKeyMismatch
+		}
+
+		for _, item := range req.Items {
+			// Map updates bypass the at-rest serializer
+			sealed, err := atrest.Seal(userIDStr, column, item.Ciphertext)
+			if err != nil {
+				return err
+			}
+			updates := map[string]interface{}{
+				column:         sealed,
+				"encrypted":    true,
+				"nonce":        item.Nonce,
+				"blind_index":  blindIndexString(item.BlindIndex),
+				"search_index": "",
+			}
+			for _, col := range clearColumns {
+				updates[col] = ""
+			}
+			result := tx.Model(model).
+				Where("id = ? AND user_id = ? AND encrypted = false", item.ID, userIDStr).
+				Updates(updates)
+			if result.Error != nil {
+				return result.Error
+			}
+			converted += result.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		if !respondE2EError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"converted": converted,
+		"skipped":   int64(len(req.Items)) - converted,
+	})
+}
+
+// countE2ERows counts a user's clips and messages encrypted under the vault key.
+func countE2ERows(tx *gorm.DB, userID string) (int64, error) {
+	var clips, messages int64
+	if err := tx.Model(&models.Clip{}).Where("user_id = ? AND encrypted = true", userID).Count(&clips).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Model(&models.SyncedMessage{}).Where("user_id = ? AND encrypted = true", userID).Count(&messages).Error; err != nil {
+		return 0, err
+	}
+	return clips + messages, nil
+}
+
+type SetE2EModeRequest struct {
+	Enabled        *bool  `json:"enabled" binding:"required"`
+	KeyFingerprint string `json:"keyFingerprint" binding:"required"`
+}
+
+// SetE2EMode turns end-to-end mode for ordinary clips and messages on or off. Enabling
+// requires a vault verifier, since every encrypted write is checked against it. Turning it
+// off only allows plaintext writes again; stored ciphertext stays readable by clients.
+func (h *SecureHandler) SetE2EMode(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req SetE2EModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		vault, err := lockVault(tx, userIDStr, "UPDATE")
+		if err != nil {
+			return err
+		}
+		if *req.Enabled && !vault.HasVerifier() {
+			return errVerifierRequired
+		}
+		if !vault.MatchesFingerprint(req.KeyFingerprint) {
+			return errKeyMismatch
+		}
+		vault.E2EEnabled = *req.Enabled
+		return tx.Save(vault).Error
+	})
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vault not set up"})
+		case errVerifierRequired:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Set a vault verifier before enabling end-to-end encryption"})
+		case errKeyMismatch:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Key fingerprint does not match the vault key"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update end-to-end mode"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"e2eEnabled": *req.Enabled})
+}
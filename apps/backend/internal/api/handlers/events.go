@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipsync/backend/internal/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventsHeartbeat keeps idle streams open through proxies.
+const eventsHeartbeat = 25 * time.Second
+
+type EventsHandler struct {
+	hub *events.Hub
+}
+
+func NewEventsHandler(hub *events.Hub) *EventsHandler {
+	return &EventsHandler{hub: hub}
+}
+
+// Stream sends the caller's events as server-sent events until the client disconnects.
+// Scoped API tokens only receive events their scopes could read.
+func (h *EventsHandler) Stream(c *gin.Context) {
+	h.stream(c, "")
+}
+
+// StreamPrefix streams only the events whose type starts with prefix, e.g. "notification.".
+func (h *EventsHandler) StreamPrefix(prefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h.stream(c, prefix)
+	}
+}
+
+func (h *EventsHandler) stream(c *gin.Context, prefix string) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var scopes []string
+	if s, scoped := c.Get("scopes"); scoped {
+		scopes = s.([]string)
+	}
+	allowed := func(scope string) bool {
+		if scopes == nil || scope == "" {
+			return true
+		}
+		for _, s := range scopes {
+			if s == scope {
+				return true
+			}
+		}
+		return false
+	}
+
+	ch, unsubscribe := h.hub.Subscribe(userIDStr)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			return true
+		case e, ok := <-ch:
+			if !ok {
+				return false
+			}
+			if !allowed(e.Scope) || !strings.HasPrefix(e.Type, prefix) {
+				return true
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return true
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			return true
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"clipsync/backend/internal/atrest"
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/phone"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// messageSorts maps the sort query parameter to columns. Ties are broken by created_at
+// and id so pages stay stable.
+var messageSorts = map[string]string{
+	"receivedAt": "received_at",
+	"createdAt":  "created_at",
+	"sender":     "sender",
+}
+
+// applyMessageSearch applies the search and narrowing parameters of message listings:
+//
+//	search    text in the body (plaintext messages), or the sender, address or contact name (any message)
+//	blind     comma-separated blind index tokens for encrypted bodies
+//	address   any spelling of a phone number or sender ID; matched on the normalized thread key
+//	deviceId  the phone that synced the message
+//	from, to  received-at range, RFC3339; from is inclusive and to exclusive
+func applyMessageSearch(c *gin.Context, query *gorm.DB, userID string) (*gorm.DB, error) {
+	blind, err := parseBlindQuery(c)
+	if err != nil {
+		return nil, err
+	}
+	search := c.Query("search")
+	query = applyContentSearch(query, userID, []string{"body"}, []string{"sender", "address"}, search, blind,
+		contactNameMatch(userID, search))
+
+	if address := c.Query("address"); address != "" {
+		query = query.Where("thread_key = ?", phone.Normalize(address, config.Get().DefaultPhoneRegion))
+	}
+	if deviceID := c.Query("deviceId"); deviceID != "" {
+		query = query.Where("device_id = ?", deviceID)
+	}
+	for _, bound := range []struct{ param, cond string }{{"from", "received_at >= ?"}, {"to", "received_at < ?"}} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s format (RFC3339)", bound.param)
+		}
+		query = query.Where(bound.cond, t)
+	}
+	return query, nil
+}
+
+// contactNameMatch matches messages of threads whose contact name contains search. Like
+// message bodies, names are matched with ILIKE while stored unsealed and by whole words
+// through the contact search index once sealed at rest.
+func contactNameMatch(userID, search string) clause.Expr {
+	args := []interface{}{userID, "%" + escapeLike(search) + "%"}
+	name := "(c.display_name NOT LIKE 'atrest:%' AND c.display_name ILIKE ?)"
+	if tokens := atrest.SearchTokens(userID, search); len(tokens) > 0 {
+		name += " OR " + tokenMatch("c.search_index", tokens, &args)
+	}
+	return gorm.Expr(`thread_key IN (SELECT a.thread_key FROM contact_addresses a
+		JOIN contacts c ON c.id = a.contact_id AND c.deleted_at IS NULL
+		WHERE a.user_id = ? AND (`+name+`))`, args...)
+}
+
+// messageOrder reads sort (receivedAt, createdAt or sender) and order (asc or desc),
+// defaulting to the newest received first.
+func messageOrder(c *gin.Context) (string, error) {
+	column, ok := messageSorts[c.DefaultQuery("sort", "receivedAt")]
+	if !ok {
+		return "", errors.New("sort must be receivedAt, createdAt or sender")
+	}
+	direction := c.DefaultQuery("order", "desc")
+	if direction != "asc" && direction != "desc" {
+		return "", errors.New("order must be asc or desc")
+	}
+	order := column + " " + direction
+	if column != "created_at" {
+		order += ", created_at " + direction
+	}
+	return order + ", id " + direction, nil
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"net/http"
+
+	"clipsync/backend/internal/models"
+	"clipsync/backend/internal/retention"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MessageSettingsResponse shows the effective retention policy next to the user's
+// overrides and the server default it falls back to.
+type MessageSettingsResponse struct {
+	Retention      retention.Policy        `json:"retention"`
+	Overrides      *models.MessageSettings `json:"overrides"` // nil without overrides
+	Default        retention.Policy        `json:"default"`
+	StarredThreads []string                `json:"starredThreads"`
+}
+
+func (h *MessagesHandler) messageSettings(userID string) (*MessageSettingsResponse, error) {
+	var overrides *models.MessageSettings
+	var settings models.MessageSettings
+	err := h.db.Where("user_id = ?", userID).First(&settings).Error
+	if err == nil {
+		overrides = &settings
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	starred := []string{}
+	if err := h.db.Model(&models.StarredThread{}).Where("user_id = ?", userID).
+		Order("created_at ASC").Pluck("thread_key", &starred).Error; err != nil {
+		return nil, err
+	}
+
+	return &MessageSettingsResponse{
+		Retention:      retention.Resolve(overrides),
+		Overrides:      overrides,
+		Default:        retention.DefaultPolicy(),
+		StarredThreads: starred,
+	}, nil
+}
+
+// GetSettings returns the user's message retention policy.
+func (h *MessagesHandler) GetSettings(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	resp, err := h.messageSettings(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// UpdateSettingsRequest replaces the user's retention overrides; an omitted or null
+// limit follows the server default and 0 turns that limit off.
+type UpdateSettingsRequest struct {
+	RetentionCount *int `json:"retentionCount" binding:"omitempty,min=0,max=1000000"`
+	RetentionDays  *int `json:"retentionDays" binding:"omitempty,min=0,max=1000000"`
+}
+
+// UpdateSettings stores retention overrides and applies the new policy right away.
+func (h *MessagesHandler) UpdateSettings(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings := models.MessageSettings{
+		UserID:         userIDStr,
+		RetentionCount: req.RetentionCount,
+		RetentionDays:  req.RetentionDays,
+	}
+	if err := h.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"retention_count", "retention_days", "updated_at"}),
+	}).Create(&settings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	deleted, err := retention.Enforce(h.db, userIDStr, retention.Resolve(&settings))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply retention"})
+		return
+	}
+	// Remove the attachments of pruned messages now rather than on the next sweep
+	for deleted > 0 {
+		if n, err := retention.PruneAttachments(c.Request.Context(), h.db, h.blobs, userIDStr); err != nil || n == 0 {
+			break
+		}
+	}
+
+	resp, err := h.messageSettings(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"settings": resp, "deleted": deleted})
+}
+
+// StarThread exempts a conversation from retention.
+func (h *MessagesHandler) StarThread(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+	threadKey := c.Param("threadKey")
+
+	star := models.StarredThread{UserID: userIDStr, ThreadKey: threadKey}
+	if err := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&star).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to star conversation"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"threadKey": threadKey, "starred": true})
+}
+
+// UnstarThread makes a conversation subject to retention again; the next run applies it.
+func (h *MessagesHandler) UnstarThread(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+	threadKey := c.Param("threadKey")
+
+	if err := h.db.Where("user_id = ? AND thread_key = ?", userIDStr, threadKey).
+		Delete(&models.StarredThread{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unstar conversation"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"threadKey": threadKey, "starred": false})
+}
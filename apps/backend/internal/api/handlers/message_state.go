@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"clipsync/backend/internal/events"
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EventMessagesState is published when messages are marked read/unread or (un)archived,
+// so the user's other devices can update without re-notifying.
+const EventMessagesState = "messages.state"
+
+// maxStateChanges caps one page of GET /messages/state.
+const maxStateChanges = 500
+
+var errEmptySelection = errors.New("select messages by ids, threadKey, before/after or all")
+
+// applyMessageFilters applies the list filters shared by the message endpoints:
+// unread=true|false and archived=false (default)|true|all.
+func applyMessageFilters(c *gin.Context, query *gorm.DB) *gorm.DB {
+	switch c.Query("unread") {
+	case "true":
+		query = query.Where("read_at IS NULL")
+	case "false":
+		query = query.Where("read_at IS NOT NULL")
+	}
+	switch c.DefaultQuery("archived", "false") {
+	case "false":
+		query = query.Where("archived_at IS NULL")
+	case "true":
+		query = query.Where("archived_at IS NOT NULL")
+	}
+	return query
+}
+
+// MessageSelection picks messages for a bulk state change. Criteria combine with AND.
+type MessageSelection struct {
+	IDs       []string   `json:"ids" binding:"max=1000"`
+	ThreadKey string     `json:"threadKey"`
+	After     *time.Time `json:"after"`  // received_at >= After
+	Before    *time.Time `json:"before"` // received_at < Before
+	All       bool       `json:"all"`
+}
+
+func (s MessageSelection) apply(query *gorm.DB) (*gorm.DB, error) {
+	if len(s.IDs) == 0 && s.ThreadKey == "" && s.After == nil && s.Before == nil && !s.All {
+		return nil, errEmptySelection
+	}
+	for _, id := range s.IDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("invalid message id %q", id)
+		}
+	}
+	if len(s.IDs) > 0 {
+		query = query.Where("id IN ?", s.IDs)
+	}
+	if s.ThreadKey != "" {
+		query = query.Where("thread_key = ?", s.ThreadKey)
+	}
+	if s.After != nil {
+		query = query.Where("received_at >= ?", *s.After)
+	}
+	if s.Before != nil {
+		query = query.Where("received_at < ?", *s.Before)
+	}
+	return query, nil
+}
+
+type MarkReadRequest struct {
+	MessageSelection
+	Read *bool `json:"read"` // Defaults to true; false marks unread
+}
+
+// MarkRead marks the selected messages read (keeping the first read time) or unread.
+func (h *MessagesHandler) MarkRead(c *gin.Context) {
+	var req MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	read := req.Read == nil || *req.Read
+
+	now := time.Now()
+	updates := map[string]interface{}{"read_at": nil, "state_updated_at": now, "state_xid": currentXID}
+	condition := "read_at IS NOT NULL"
+	if read {
+		updates["read_at"] = now
+		condition = "read_at IS NULL"
+	}
+	h.updateMessageState(c, req.MessageSelection, condition, updates, gin.H{"read": read})
+}
+
+type ArchiveRequest struct {
+	MessageSelection
+	Archived *bool `json:"archived"` // Defaults to true; false restores
+}
+
+// Archive hides the selected messages from default listings without deleting them.
+func (h *MessagesHandler) Archive(c *gin.Context) {
+	var req ArchiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	archived := req.Archived == nil || *req.Archived
+
+	now := time.Now()
+	updates := map[string]interface{}{"archived_at": nil, "state_updated_at": now, "state_xid": currentXID}
+	condition := "archived_at IS NOT NULL"
+	if archived {
+		updates["archived_at"] = now
+		condition = "archived_at IS NULL"
+	}
+	h.updateMessageState(c, req.MessageSelection, condition, updates, gin.H{"archived": archived})
+}
+
+// updateMessageState applies updates to the selected messages still matching condition,
+// then tells the user's other devices what changed.
+func (h *MessagesHandler) updateMessageState(c *gin.Context, sel MessageSelection, condition string, updates map[string]interface{}, change gin.H) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	query, err := sel.apply(h.db.Model(&models.SyncedMessage{}).Where("user_id = ?", userIDStr))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result := query.Where(condition).Updates(updates)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update messages"})
+		return
+	}
+
+	if result.RowsAffected > 0 {
+		change["ids"] = sel.IDs
+		change["threadKey"] = sel.ThreadKey
+		change["after"] = sel.After
+		change["before"] = sel.Before
+		change["all"] = sel.All
+		change["updatedAt"] = updates["state_updated_at"]
+		h.hub.Publish(userIDStr, events.Event{
+			Type:  EventMessagesState,
+			Scope: models.ScopeMessagesRead,
+			Data:  change,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": result.RowsAffected})
+}
+
+type MessageState struct {
+	ID             string     `json:"id"`
+	ThreadKey      string     `json:"threadKey"`
+	ReadAt         *time.Time `json:"readAt"`
+	ArchivedAt     *time.Time `json:"archivedAt"`
+	StateUpdatedAt time.Time  `json:"stateUpdatedAt"`
+	StateXID       int64      `gorm:"column:state_xid" json:"-"`
+}
+
+// StateChanges returns read/archive changes after ?since=, oldest first, for devices that
+// were offline when the state events were published. Later pages and polls pass the
+// returned nextCursor as ?cursor=. Changes are ordered by the transaction that made them
+// and only returned once no earlier transaction can still commit, so none is skipped.
+func (h *MessagesHandler) StateChanges(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var horizon int64
+	if err := h.db.Raw("SELECT " + committedBefore).Scan(&horizon).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch state changes"})
+		return
+	}
+	base := h.db.Model(&models.SyncedMessage{}).
+		Where("user_id = ? AND state_xid IS NOT NULL AND state_xid < ?", userIDStr, horizon)
+
+	var start commitCursor
+	if cursor := c.Query("cursor"); cursor != "" {
+		var err error
+		if start, err = parseCommitCursor(cursor); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		since, err := time.Parse(time.RFC3339Nano, c.Query("since"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cursor or since (RFC3339) query param required"})
+			return
+		}
+		// Resume from the first transaction that changed state after since
+		if err := base.Session(&gorm.Session{}).Where("state_updated_at > ?", since).
+			Select("COALESCE(MIN(state_xid), ?)", horizon).Scan(&start.XID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch state changes"})
+			return
+		}
+	}
+
+	var states []MessageState
+	if err := base.Session(&gorm.Session{}).
+		Select("id, thread_key, read_at, archived_at, state_updated_at, state_xid").
+		Where("(state_xid, id) > (?, ?)", start.XID, start.ID).Order("state_xid ASC, id ASC").
+		Limit(maxStateChanges).Scan(&states).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch state changes"})
+		return
+	}
+
+	// A short page saw every change below the horizon, so later polls start there
+	next := commitCursor{XID: horizon}
+	hasMore := len(states) == maxStateChanges
+	if hasMore {
+		last := states[len(states)-1]
+		next = commitCursor{XID: last.StateXID, ID: uuid.MustParse(last.ID)}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":       states,
+		"hasMore":    hasMore,
+		"nextCursor": next.String(),
+	})
+}
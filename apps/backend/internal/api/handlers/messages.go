@@ -4,25 +4,54 @@ import (
 	"net/http"
 	"time"
 
+	"clipsync/backend/internal/atrest"
+	"clipsync/backend/internal/blob"
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/events"
 	"clipsync/backend/internal/models"
+	"clipsync/backend/internal/phone"
+	"clipsync/backend/internal/retention"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MessagesHandler struct {
-	db *gorm.DB
+	db    *gorm.DB
+	hub   *events.Hub
+	blobs blob.Store
 }
 
-func NewMessagesHandler(db *gorm.DB) *MessagesHandler {
-	return &MessagesHandler{db: db}
+func NewMessagesHandler(db *gorm.DB, hub *events.Hub, blobs blob.Store) *MessagesHandler {
+	return &MessagesHandler{db: db, hub: hub, blobs: blobs}
 }
 
 // SyncMessageItem is a single message from the mobile app.
+// Sender and address stay plaintext in E2E mode; only the body is encrypted.
 type SyncMessageItem struct {
-	Body       string    `json:"body" binding:"required"`
-	Sender     string    `json:"sender"`
-	Address    string    `json:"address"`
-	ReceivedAt time.Time `json:"receivedAt"`
+	Body            string           `json:"body" binding:"required"` // Ciphertext when encrypted
+	Sender          string           `json:"sender"`
+	Address         string           `json:"address"`
+	ReceivedAt      time.Time        `json:"receivedAt"`
+	DeviceMessageID string           `json:"deviceMessageId" binding:"max=255"` // Native SMS provider ID; preferred for deduplication
+	Fingerprint     string           `json:"fingerprint" binding:"max=128"`     // Client-computed stable ID; required for encrypted bodies without deviceMessageId
+	Attachments     []AttachmentSpec `json:"attachments" binding:"dive"`        // MMS parts, uploaded separately (see UploadAttachment)
+	E2EContent
+}
+
+// Per-item push outcomes.
+const (
+	pushCreated = "created"
+	pushSkipped = "skipped" // Already synced
+	pushDeleted = "deleted" // Deleted on the server before, by the user or by retention
+	pushFailed  = "failed"
+)
+
+type PushMessageResult struct {
+	Index       int                `json:"index"`
+	Status      string             `json:"status"`
+	ID          string             `json:"id,omitempty"`          // The new or already stored message
+	Attachments []AttachmentUpload `json:"attachments,omitempty"` // Where to upload announced attachments
 }
 
 // PushMessagesRequest is the request body for syncing messages from mobile.
@@ -33,7 +62,9 @@ type PushMessagesRequest struct {
 
 const maxMessagesPageSize = 100
 
-// List returns paginated synced messages for the user. Capped at last 100 messages.
+// List returns paginated synced messages for the user, at most 100 per page. How many are
+// stored is governed by the retention policy (see GetSettings). See applyMessageSearch,
+// applyMessageFilters and messageOrder for the query parameters.
 func (h *MessagesHandler) List(c *gin.Context) {
 	userID, _ := c.Get("userId")
 	userIDStr := userID.(string)
@@ -41,6 +72,11 @@ func (h *MessagesHandler) List(c *gin.Context) {
 	page := c.DefaultQuery("page", "1")
 	pageSize := c.DefaultQuery("pageSize", "50")
 	since := c.Query("since") // ISO timestamp for "new since" (desktop polling)
+	order, err := messageOrder(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	limit := parseInt(pageSize)
 	if limit <= 0 {
@@ -58,7 +94,14 @@ func (h *MessagesHandler) List(c *gin.Context) {
 		}
 	}
 
-	query = query.Order("received_at DESC, created_at DESC")
+	query, err = applyMessageSearch(c, query, userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query = applyMessageFilters(c, query)
+
+	query = query.Order(order)
 
 	var messages []models.SyncedMessage
 	var total int64
@@ -69,6 +112,8 @@ func (h *MessagesHandler) List(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
 		return
 	}
+	h.loadAttachments(messages)
+	resolveSenders(h.db, userIDStr, messages)
 
 	totalPages := (int(total) + limit - 1) / limit
 
@@ -96,14 +141,16 @@ func (h *MessagesHandler) Delete(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
 		return
 	}
-	if err := h.db.Delete(&msg).Error; err != nil {
+	if _, err := retention.DeleteMessages(h.db, h.db.Where("id = ?", msg.ID)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
 		return
 	}
+	retention.PruneAttachments(c.Request.Context(), h.db, h.blobs, userIDStr)
 	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
 }
 
 // NewSince returns messages created after the given timestamp (for desktop push notification polling).
+// Pass unread=true to skip messages already read on another device.
 func (h *MessagesHandler) NewSince(c *gin.Context) {
 	userID, _ := c.Get("userId")
 	userIDStr := userID.(string)
@@ -119,11 +166,13 @@ func (h *MessagesHandler) NewSince(c *gin.Context) {
 	}
 
 	var messages []models.SyncedMessage
-	if err := h.db.Where("user_id = ? AND created_at > ?", userIDStr, t).
+	if err := applyMessageFilters(c, h.db.Where("user_id = ? AND created_at > ?", userIDStr, t)).
 		Order("created_at ASC").Find(&messages).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
 		return
 	}
+	h.loadAttachments(messages)
+	resolveSenders(h.db, userIDStr, messages)
 	c.JSON(http.StatusOK, gin.H{"messages": messages})
 }
 
@@ -138,36 +187,145 @@ func (h *MessagesHandler) Push(c *gin.Context) {
 		return
 	}
 
-	var created []models.SyncedMessage
+	policy, err := loadE2EPolicy(h.db, userIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync messages"})
+		return
+	}
 	for _, m := range req.Messages {
+		if err := m.E2EContent.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := policy.check(m.E2EContent); err != nil {
+			respondE2EError(c, err)
+			return
+		}
+		if m.Encrypted && m.DeviceMessageID == "" && m.Fingerprint == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Encrypted messages require deviceMessageId or fingerprint"})
+			return
+		}
+		if err := validateAttachments(m.Attachments, m.Encrypted); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Re-syncs after a reinstall or retry send messages again; the unique fingerprint turns
+	// those inserts into no-ops instead of duplicates, and tombstones keep deleted ones out
+	fingerprintKey := []byte(config.Get().MessageFingerprintKey)
+	fingerprints := make([]string, len(req.Messages))
+	for i, m := range req.Messages {
+		fingerprints[i] = models.MessageFingerprint(fingerprintKey, m.DeviceMessageID, m.Fingerprint, m.Address, m.ReceivedAt, m.Body)
+	}
+	tombstones, err := retention.LoadTombstones(h.db, userIDStr, fingerprints)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync messages"})
+		return
+	}
+
+	results := make([]PushMessageResult, 0, len(req.Messages))
+	var created, skipped, failed int
+	for i, m := range req.Messages {
+		fingerprint := fingerprints[i]
+		threadKey := phone.Normalize(m.Address, config.Get().DefaultPhoneRegion)
+		if tombstones.Deleted(fingerprint, threadKey, m.ReceivedAt) {
+			skipped++
+			results = append(results, PushMessageResult{Index: i, Status: pushDeleted})
+			continue
+		}
 		msg := models.SyncedMessage{
-			UserID:     userIDStr,
-			Body:       m.Body,
-			Sender:     m.Sender,
-			Address:    m.Address,
-			ReceivedAt: m.ReceivedAt,
-			DeviceID:   req.DeviceID,
-			CreatedAt:  time.Now(),
+			UserID:             userIDStr,
+			Body:               m.Body,
+			Sender:             m.Sender,
+			Address:            m.Address,
+			ThreadKey:          threadKey,
+			ReceivedAt:         m.ReceivedAt,
+			DeviceID:           req.DeviceID,
+			Fingerprint:        &fingerprint,
+			FingerprintVersion: models.MessageFingerprintVersion,
+			CreatedAt:          time.Now(),
+		}
+		if m.DeviceMessageID != "" {
+			deviceMessageID := m.DeviceMessageID
+			msg.DeviceMessageID = &deviceMessageID
+		}
+		if m.Encrypted {
+			nonce := m.Nonce
+			msg.Encrypted = true
+			msg.Nonce = &nonce
+			msg.BlindIndex = blindIndexString(m.BlindIndex)
+		} else {
+			msg.SearchIndex = atrest.SearchIndex(userIDStr, m.Body)
 		}
-		if err := h.db.Create(&msg).Error; err == nil {
-			created = append(created, msg)
+
+		result := h.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "fingerprint"}},
+			DoNothing: true,
+		}).Create(&msg)
+		switch {
+		case result.Error != nil:
+			failed++
+			results = append(results, PushMessageResult{Index: i, Status: pushFailed})
+		case result.RowsAffected == 0:
+			res := PushMessageResult{Index: i, Status: pushSkipped}
+			var existing models.SyncedMessage
+			if h.db.Select("id, user_id").Where("user_id = ? AND fingerprint = ?", userIDStr, fingerprint).First(&existing).Error == nil {
+				res.ID = existing.ID.String()
+				// A retried push resumes the uploads of the stored message
+				uploads, err := h.announceAttachments(&existing, m.Attachments)
+				if err != nil {
+					res.Status = pushFailed
+				}
+				res.Attachments = uploads
+			}
+			if res.Status == pushFailed {
+				failed++
+			} else {
+				skipped++
+			}
+			results = append(results, res)
+		default:
+			res := PushMessageResult{Index: i, Status: pushCreated, ID: msg.ID.String()}
+			uploads, err := h.announceAttachments(&msg, m.Attachments)
+			if err != nil {
+				// Pushing again creates them for the stored message
+				res.Status = pushFailed
+				failed++
+			} else {
+				res.Attachments = uploads
+				created++
+			}
+			results = append(results, res)
+			h.recordOTP(&msg)
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"synced":  len(created),
+		"synced":  created,
+		"created": created,
+		"skipped": skipped,
+		"failed":  failed,
+		"results": results,
 		"message": "Messages synced successfully",
 	})
 }
 
+// EncryptMessages replaces existing plaintext message bodies with client-encrypted ones,
+// for migrating a history to end-to-end mode.
+func (h *MessagesHandler) EncryptMessages(c *gin.Context) {
+	encryptInPlace(c, h.db, &models.SyncedMessage{}, "body")
+}
+
 func (h *MessagesHandler) ClearAll(c *gin.Context) {
 	userID, _ := c.Get("userId")
 	userIDStr := userID.(string)
 
-	result := h.db.Where("user_id = ?", userIDStr).Delete(&models.SyncedMessage{})
-	if result.Error != nil {
+	deleted, err := retention.DeleteMessages(h.db, h.db.Where("user_id = ?", userIDStr))
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear messages"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "All messages cleared", "deleted": result.RowsAffected})
+	retention.PruneAttachments(c.Request.Context(), h.db, h.blobs, userIDStr)
+	c.JSON(http.StatusOK, gin.H{"message": "All messages cleared", "deleted": deleted})
 }
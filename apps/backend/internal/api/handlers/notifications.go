@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"clipsync/backend/internal/events"
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Notification events, scoped to notifications:read.
+const (
+	EventNotificationPosted    = "notification.posted"
+	EventNotificationRemoved   = "notification.removed"
+	EventNotificationDismissed = "notification.dismissed" // The phone should clear it
+)
+
+// Per-item push outcomes besides those of message pushes.
+const (
+	pushUpdated  = "updated"  // Repost of a stored key
+	pushFiltered = "filtered" // Denied by a rule
+)
+
+type NotificationsHandler struct {
+	db  *gorm.DB
+	hub *events.Hub
+}
+
+func NewNotificationsHandler(db *gorm.DB, hub *events.Hub) *NotificationsHandler {
+	return &NotificationsHandler{db: db, hub: hub}
+}
+
+// NotificationItem is one posted or updated notification from the phone. In E2E mode the
+// client encrypts title and text together into Text and leaves Title empty.
+type NotificationItem struct {
+	Key        string                      `json:"key" binding:"required,max=255"`
+	AppPackage string                      `json:"appPackage" binding:"required,max=255"`
+	AppName    string                      `json:"appName" binding:"max=255"`
+	Title      string                      `json:"title"`
+	Text       string                      `json:"text"`
+	Category   string                      `json:"category" binding:"max=64"`
+	Actions    []models.NotificationAction `json:"actions" binding:"max=8"`
+	PostedAt   time.Time                   `json:"postedAt" binding:"required"`
+	E2EContent
+}
+
+// NotificationRemoval reports a notification cleared on the phone.
+type NotificationRemoval struct {
+	Key       string    `json:"key" binding:"required,max=255"`
+	RemovedAt time.Time `json:"removedAt"`
+}
+
+type PushNotificationsRequest struct {
+	DeviceID      string                `json:"deviceId" binding:"required"`
+	Notifications []NotificationItem    `json:"notifications" binding:"max=500,dive"`
+	Removed       []NotificationRemoval `json:"removed" binding:"max=500,dive"`
+}
+
+// notificationRules decides which apps are mirrored for a user.
+type notificationRules struct {
+	byApp map[string]string
+}
+
+func loadNotificationRules(db *gorm.DB, userID string) (*notificationRules, error) {
+	var rules []models.NotificationRule
+	if err := db.Where("user_id = ?", userID).Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	r := &notificationRules{byApp: make(map[string]string, len(rules))}
+	for _, rule := range rules {
+		r.byApp[rule.AppPackage] = rule.Action
+	}
+	return r, nil
+}
+
+func (r *notificationRules) allowed(appPackage string) bool {
+	action, ok := r.byApp[appPackage]
+	if !ok {
+		action = r.byApp[models.NotificationRuleDefault]
+	}
+	return action != models.NotificationDeny
+}
+
+// Push stores posted notifications and removals from the phone. Reposting a key updates
+// the stored notification, which makes retries and progress updates safe.
+func (h *NotificationsHandler) Push(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req PushNotificationsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := loadE2EPolicy(h.db, userIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync notifications"})
+		return
+	}
+	for _, n := range req.Notifications {
+		if err := n.E2EContent.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := policy.check(n.E2EContent); err != nil {
+			respondE2EError(c, err)
+			return
+		}
+		if n.Encrypted && n.Title != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Encrypted notifications carry the title inside text"})
+			return
+		}
+	}
+	rules, err := loadNotificationRules(h.db, userIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync notifications"})
+		return
+	}
+
+	results := make([]PushMessageResult, 0, len(req.Notifications))
+	for i, n := range req.Notifications {
+		if !rules.allowed(n.AppPackage) {
+			results = append(results, PushMessageResult{Index: i, Status: pushFiltered})
+			continue
+		}
+
+		// Inserts keep this ID; updates return the stored one
+		newID := uuid.New()
+		notification := models.Notification{
+			ID:         newID,
+			UserID:     userIDStr,
+			DeviceID:   req.DeviceID,
+			Key:        n.Key,
+			AppPackage: n.AppPackage,
+			AppName:    n.AppName,
+			Title:      n.Title,
+			Text:       n.Text,
+			Category:   n.Category,
+			Actions:    n.Actions,
+			Encrypted:  n.Encrypted,
+			PostedAt:   n.PostedAt,
+		}
+		if notification.Actions == nil {
+			notification.Actions = []models.NotificationAction{}
+		}
+		if n.Encrypted {
+			nonce := n.Nonce
+			notification.Nonce = &nonce
+		}
+
+		// A repost revives a key the phone had cleared. Updates of a still-posted notification
+		// (progress, retries) keep a desktop dismissal the phone has not applied yet
+		if err := h.db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "device_id"}, {Name: "notification_key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"app_package":  gorm.Expr("excluded.app_package"),
+				"app_name":     gorm.Expr("excluded.app_name"),
+				"title":        gorm.Expr("excluded.title"),
+				"text":         gorm.Expr("excluded.text"),
+				"category":     gorm.Expr("excluded.category"),
+				"actions":      gorm.Expr("excluded.actions"),
+				"encrypted":    gorm.Expr("excluded.encrypted"),
+				"nonce":        gorm.Expr("excluded.nonce"),
+				"posted_at":    gorm.Expr("excluded.posted_at"),
+				"removed_at":   nil,
+				"dismissed_at": gorm.Expr("CASE WHEN notifications.removed_at IS NOT NULL THEN NULL ELSE notifications.dismissed_at END"),
+				"updated_at":   time.Now(),
+			}),
+		}).Create(&notification).Error; err != nil {
+			results = append(results, PushMessageResult{Index: i, Status: pushFailed})
+			continue
+		}
+
+		var stored models.Notification
+		if err := h.db.Where("user_id = ? AND device_id = ? AND notification_key = ?", userIDStr, req.DeviceID, n.Key).
+			First(&stored).Error; err != nil {
+			results = append(results, PushMessageResult{Index: i, Status: pushFailed})
+			continue
+		}
+		status := pushCreated
+		if stored.ID != newID {
+			status = pushUpdated
+		}
+		results = append(results, PushMessageResult{Index: i, Status: status, ID: stored.ID.String()})
+		if stored.DismissedAt == nil {
+			h.publish(userIDStr, EventNotificationPosted, stored)
+		}
+	}
+
+	var removed int64
+	for _, r := range req.Removed {
+		removedAt := r.RemovedAt
+		if removedAt.IsZero() {
+			removedAt = time.Now()
+		}
+		var n models.Notification
+		if err := h.db.Where("user_id = ? AND device_id = ? AND notification_key = ? AND removed_at IS NULL", userIDStr, req.DeviceID, r.Key).
+			First(&n).Error; err != nil {
+			continue
+		}
+		if err := h.db.Model(&n).Update("removed_at", removedAt).Error; err != nil {
+			continue
+		}
+		removed++
+		h.publish(userIDStr, EventNotificationRemoved, n)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results, "removed": removed})
+}
+
+func (h *NotificationsHandler) publish(userID, eventType string, n models.Notification) {
+	h.hub.Publish(userID, events.Event{Type: eventType, Scope: models.ScopeNotificationsRead, Data: n})
+}
+
+// List returns mirrored notifications, newest first. By default only those still shown
+// on the phone and not dismissed; pass active=false for history. Filter with appPackage.
+func (h *NotificationsHandler) List(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	page := parseInt(c.DefaultQuery("page", "1"))
+	limit := parseInt(c.DefaultQuery("pageSize", "50"))
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > maxMessagesPageSize {
+		limit = maxMessagesPageSize
+	}
+
+	query := h.db.Model(&models.Notification{}).Where("user_id = ?", userIDStr)
+	if c.DefaultQuery("active", "true") == "true" {
+		query = query.Where("removed_at IS NULL AND dismissed_at IS NULL")
+	}
+	if app := c.Query("appPackage"); app != "" {
+		query = query.Where("app_package = ?", app)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var notifications []models.Notification
+	if err := query.Order("posted_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&notifications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       notifications,
+		"total":      total,
+		"page":       page,
+		"pageSize":   limit,
+		"totalPages": (int(total) + limit - 1) / limit,
+	})
+}
+
+// Dismiss clears a notification from every desktop and asks the phone to clear it. The
+// phone learns of it from the dismissed event or GET /notifications/dismissals, then
+// reports the removal in its next push.
+func (h *NotificationsHandler) Dismiss(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var n models.Notification
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userIDStr).First(&n).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+	if !n.Active() {
+		c.JSON(http.StatusOK, n)
+		return
+	}
+
+	now := time.Now()
+	result := h.db.Model(&n).Where("dismissed_at IS NULL AND removed_at IS NULL").Update("dismissed_at", now)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to dismiss notification"})
+		return
+	}
+	if result.RowsAffected > 0 {
+		n.DismissedAt = &now
+		h.publish(userIDStr, EventNotificationDismissed, n)
+	}
+	c.JSON(http.StatusOK, n)
+}
+
+// Dismissals lists notifications dismissed elsewhere that the phone has not cleared yet,
+// for phones catching up after being offline.
+func (h *NotificationsHandler) Dismissals(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	deviceID := c.Query("deviceId")
+	if deviceID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deviceId query param required"})
+		return
+	}
+
+	var notifications []models.Notification
+	if err := h.db.Select("id, user_id, device_id, notification_key, app_package, dismissed_at").
+		Where("user_id = ? AND device_id = ? AND dismissed_at IS NOT NULL AND removed_at IS NULL", userIDStr, deviceID).
+		Order("dismissed_at ASC").Limit(maxStateChanges).Find(&notifications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dismissals"})
+		return
+	}
+
+	keys := make([]string, 0, len(notifications))
+	for _, n := range notifications {
+		keys = append(keys, n.Key)
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// ListRules returns the user's per-app mirroring rules. The phone can use them to skip
+// sending denied apps at all.
+func (h *NotificationsHandler) ListRules(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var rules []models.NotificationRule
+	if err := h.db.Where("user_id = ?", userIDStr).Order("app_package ASC").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+type SetNotificationRuleRequest struct {
+	AppPackage string `json:"appPackage" binding:"required,max=255"` // "*" sets the default
+	Action     string `json:"action" binding:"required,oneof=allow deny"`
+}
+
+// SetRule creates or replaces the rule for an app.
+func (h *NotificationsHandler) SetRule(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req SetNotificationRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := models.NotificationRule{UserID: userIDStr, AppPackage: req.AppPackage, Action: req.Action}
+	if err := h.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "app_package"}},
+		DoUpdates: clause.AssignmentColumns([]string{"action", "updated_at"}),
+	}).Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save rule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"appPackage": rule.AppPackage, "action": rule.Action})
+}
+
+// DeleteRule removes the rule for an app, which then follows the default.
+func (h *NotificationsHandler) DeleteRule(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	result := h.db.Where("user_id = ? AND app_package = ?", userIDStr, c.Param("appPackage")).
+		Delete(&models.NotificationRule{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"clipsync/backend/internal/atrest"
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/events"
+	"clipsync/backend/internal/models"
+	"clipsync/backend/internal/otp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EventOTP is published when a one-time code arrives by SMS.
+const EventOTP = "otp"
+
+// recordOTP extracts a one-time code from a newly synced message, stores it and pushes it
+// to the user's connected clients. End-to-end encrypted bodies are opaque to the server;
+// their clients extract codes themselves.
+func (h *MessagesHandler) recordOTP(msg *models.SyncedMessage) {
+	if msg.Encrypted {
+		return
+	}
+	match, ok := otp.Extract(msg.Body)
+	if !ok {
+		return
+	}
+
+	cfg := config.Get()
+	receivedAt := msg.ReceivedAt
+	if receivedAt.IsZero() || receivedAt.After(time.Now()) {
+		receivedAt = time.Now()
+	}
+	ttl := match.Validity
+	if ttl == 0 {
+		ttl = cfg.OTPTTL
+	}
+	// An initial inbox sync carries many stale codes; only live ones are useful
+	expiresAt := receivedAt.Add(ttl)
+	if !expiresAt.After(time.Now()) {
+		return
+	}
+
+	code := models.OTPCode{
+		UserID:     msg.UserID,
+		MessageID:  msg.ID,
+		Code:       match.Code,
+		Sender:     msg.Sender,
+		ThreadKey:  msg.ThreadKey,
+		ReceivedAt: receivedAt,
+		ExpiresAt:  expiresAt,
+	}
+	if err := h.db.Create(&code).Error; err != nil {
+		log.Printf("Failed to store one-time code of message %s: %v", msg.ID, err)
+		return
+	}
+	h.db.Where("user_id = ? AND expires_at < ?", msg.UserID, time.Now()).Delete(&models.OTPCode{})
+
+	if cfg.OTPAutoClip {
+		deviceName := "SMS"
+		if msg.Sender != "" {
+			deviceName = "SMS from " + msg.Sender
+		}
+		clip := models.Clip{
+			ID:             uuid.New(),
+			UserID:         msg.UserID,
+			Content:        match.Code,
+			ContentPreview: match.Code,
+			SearchIndex:    atrest.SearchIndex(msg.UserID, match.Code),
+			CopiedAt:       time.Now(),
+			Tags:           []string{"otp"},
+			DeviceName:     &deviceName,
+			Synced:         true,
+			ExpiresAt:      &expiresAt,
+		}
+		if err := h.db.Create(&clip).Error; err != nil {
+			log.Printf("Failed to create one-time code clip of message %s: %v", msg.ID, err)
+		}
+	}
+
+	h.hub.Publish(msg.UserID, events.Event{
+		Type:  EventOTP,
+		Scope: models.ScopeMessagesRead,
+		Data:  code,
+	})
+}
+
+// LatestOTP returns the newest one-time code that has not expired yet.
+func (h *MessagesHandler) LatestOTP(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var code models.OTPCode
+	if err := h.db.Where("user_id = ? AND expires_at > ?", userIDStr, time.Now()).
+		Order("received_at DESC, created_at DESC").First(&code).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No active one-time code"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch one-time code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, code)
+}
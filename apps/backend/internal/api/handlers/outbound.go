@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/events"
+	"clipsync/backend/internal/models"
+	"clipsync/backend/internal/phone"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// EventOutboundQueued tells the sending phone to claim; EventOutboundStatus reports
+	// state changes back to the composing client.
+	EventOutboundQueued = "outbound.queued"
+	EventOutboundStatus = "outbound.status"
+
+	maxOutboundClaim = 50
+)
+
+var (
+	errNotClaimed = errors.New("outbound message not claimed by this device")
+	errNoDevice   = errors.New("no sending device")
+)
+
+type EnqueueOutboundRequest struct {
+	Address         string `json:"address" binding:"required,max=255"`
+	Body            string `json:"body" binding:"required"` // Ciphertext when encrypted
+	DeviceID        string `json:"deviceId" binding:"max=255"`
+	ClientRequestID string `json:"clientRequestId" binding:"max=64"`
+	E2EContent
+}
+
+// EnqueueOutbound queues an SMS for the user's phone to send. Without a deviceId it goes
+// to the phone that last synced the thread (or any message). Repeating a request with the
+// same clientRequestId returns the original message.
+func (h *MessagesHandler) EnqueueOutbound(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req EnqueueOutboundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.E2EContent.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	policy, err := loadE2EPolicy(h.db, userIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue message"})
+		return
+	}
+	if err := policy.check(req.E2EContent); err != nil {
+		respondE2EError(c, err)
+		return
+	}
+
+	cfg := config.Get()
+	threadKey := phone.Normalize(req.Address, cfg.DefaultPhoneRegion)
+	deviceID := req.DeviceID
+	if deviceID == "" {
+		if deviceID, err = h.sendingDevice(userIDStr, threadKey); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No phone has synced messages yet; pass deviceId"})
+			return
+		}
+	}
+
+	msg := models.OutboundMessage{
+		UserID:      userIDStr,
+		DeviceID:    deviceID,
+		Address:     req.Address,
+		ThreadKey:   threadKey,
+		Body:        req.Body,
+		Status:      models.OutboundQueued,
+		MaxAttempts: cfg.OutboundMaxAttempts,
+		ExpiresAt:   time.Now().Add(cfg.OutboundTTL),
+	}
+	if req.Encrypted {
+		nonce := req.Nonce
+		msg.Encrypted = true
+		msg.Nonce = &nonce
+	}
+	if req.ClientRequestID != "" {
+		clientRequestID := req.ClientRequestID
+		msg.ClientRequestID = &clientRequestID
+	}
+
+	result := h.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_request_id"}},
+		DoNothing: true,
+	}).Create(&msg)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue message"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		var existing models.OutboundMessage
+		if err := h.db.Where("user_id = ? AND client_request_id = ?", userIDStr, req.ClientRequestID).First(&existing).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue message"})
+			return
+		}
+		c.JSON(http.StatusOK, existing)
+		return
+	}
+
+	h.hub.Publish(userIDStr, events.Event{
+		Type:  EventOutboundQueued,
+		Scope: models.ScopeMessagesRead,
+		Data:  gin.H{"id": msg.ID, "deviceId": msg.DeviceID},
+	})
+	c.JSON(http.StatusCreated, msg)
+}
+
+// sendingDevice picks the phone that last synced a message in the thread, or any message.
+func (h *MessagesHandler) sendingDevice(userID, threadKey string) (string, error) {
+	var latest models.SyncedMessage
+	err := h.db.Select("device_id").Where("user_id = ? AND thread_key = ? AND device_id <> ''", userID, threadKey).
+		Order("received_at DESC").First(&latest).Error
+	if err == gorm.ErrRecordNotFound {
+		err = h.db.Select("device_id").Where("user_id = ? AND device_id <> ''", userID).
+			Order("created_at DESC").First(&latest).Error
+	}
+	if err != nil {
+		return "", errNoDevice
+	}
+	return latest.DeviceID, nil
+}
+
+// ListOutbound returns the user's outbound messages, newest first, optionally by status or thread.
+func (h *MessagesHandler) ListOutbound(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	h.expireOutbound(userIDStr)
+
+	query := h.db.Where("user_id = ?", userIDStr)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if threadKey := c.Query("threadKey"); threadKey != "" {
+		query = query.Where("thread_key = ?", threadKey)
+	}
+
+	limit := parseInt(c.DefaultQuery("pageSize", "50"))
+	if limit > maxMessagesPageSize {
+		limit = maxMessagesPageSize
+	}
+
+	var messages []models.OutboundMessage
+	if err := query.Order("created_at DESC").Limit(limit).Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch outbound messages"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": messages})
+}
+
+// GetOutbound returns one outbound message, for polling its status.
+func (h *MessagesHandler) GetOutbound(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	h.expireOutbound(userIDStr)
+
+	var msg models.OutboundMessage
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userIDStr).First(&msg).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Outbound message not found"})
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
+
+// CancelOutbound withdraws a message the phone has not claimed yet.
+func (h *MessagesHandler) CancelOutbound(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	result := h.db.Model(&models.OutboundMessage{}).
+		Where("id = ? AND user_id = ? AND status = ?", c.Param("id"), userIDStr, models.OutboundQueued).
+		Update("status", models.OutboundCancelled)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Message is not queued (already claimed, finished or unknown)"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Cancelled"})
+}
+
+type ClaimOutboundRequest struct {
+	DeviceID string `json:"deviceId" binding:"required"`
+	Limit    int    `json:"limit"`
+}
+
+// ClaimOutbound hands the phone its next messages to send, oldest first. Within a thread
+// only the oldest pending message is handed out, and only while no earlier one is in
+// flight, so messages to one recipient are sent in the order they were queued. Each claim
+// is a lease; unreported claims are retried after OUTBOUND_CLAIM_TIMEOUT.
+func (h *MessagesHandler) ClaimOutbound(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req ClaimOutboundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Limit <= 0 || req.Limit > maxOutboundClaim {
+		req.Limit = maxOutboundClaim
+	}
+
+	h.expireOutbound(userIDStr)
+
+	cfg := config.Get()
+	var claimed []models.OutboundMessage
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var ids []string
+		if err := tx.Raw(`SELECT id FROM outbound_messages o
+			WHERE o.user_id = ? AND o.device_id = ? AND o.status = ? AND o.expires_at > ?
+			AND NOT EXISTS (
+				SELECT 1 FROM outbound_messages p
+				WHERE p.user_id = o.user_id AND p.device_id = o.device_id AND p.thread_key = o.thread_key
+				AND p.status IN ? AND (p.created_at, p.id) < (o.created_at, o.id)
+			)
+			ORDER BY o.created_at, o.id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED`,
+			userIDStr, req.DeviceID, models.OutboundQueued, time.Now(),
+			[]string{models.OutboundQueued, models.OutboundClaimed}, req.Limit).
+			Scan(&ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		lease := now.Add(cfg.OutboundClaimTimeout)
+		if err := tx.Model(&models.OutboundMessage{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":           models.OutboundClaimed,
+			"claimed_at":       now,
+			"lease_expires_at": lease,
+			"attempts":         gorm.Expr("attempts + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Order("created_at, id").Find(&claimed).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim messages"})
+		return
+	}
+
+	for i := range claimed {
+		h.publishOutboundStatus(&claimed[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"data": claimed})
+}
+
+type ReportOutboundRequest struct {
+	DeviceID  string `json:"deviceId" binding:"required"`
+	Status    string `json:"status" binding:"required,oneof=sent failed"`
+	Error     string `json:"error" binding:"max=500"`
+	Retryable *bool  `json:"retryable"` // Failed sends are retried unless false
+}
+
+// ReportOutbound records the result of a claimed send. Retryable failures go back to the
+// queue until the message runs out of attempts or expires.
+func (h *MessagesHandler) ReportOutbound(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req ReportOutboundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var msg models.OutboundMessage
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", c.Param("id"), userIDStr).First(&msg).Error; err != nil {
+			return err
+		}
+		// A repeated "sent" report is acknowledged again
+		if msg.Status == models.OutboundSent && req.Status == models.OutboundSent {
+			return nil
+		}
+		if msg.Status != models.OutboundClaimed || msg.DeviceID != req.DeviceID {
+			return errNotClaimed
+		}
+
+		updates := map[string]interface{}{"lease_expires_at": nil}
+		if req.Status == models.OutboundSent {
+			updates["status"] = models.OutboundSent
+			updates["sent_at"] = time.Now()
+			updates["error"] = ""
+		} else {
+			retry := req.Retryable == nil || *req.Retryable
+			updates["error"] = req.Error
+			if retry && msg.Attempts < msg.MaxAttempts && time.Now().Before(msg.ExpiresAt) {
+				updates["status"] = models.OutboundQueued
+			} else {
+				updates["status"] = models.OutboundFailed
+			}
+		}
+		if err := tx.Model(&msg).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", msg.ID).First(&msg).Error
+	})
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Outbound message not found"})
+		case errNotClaimed:
+			c.JSON(http.StatusConflict, gin.H{"error": "Message is not claimed by this device"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record result"})
+		}
+		return
+	}
+
+	h.publishOutboundStatus(&msg)
+	c.JSON(http.StatusOK, msg)
+}
+
+// expireOutbound returns timed-out claims to the queue (or fails them when out of
+// attempts) and expires messages past their deadline.
+func (h *MessagesHandler) expireOutbound(userID string) {
+	now := time.Now()
+
+	h.db.Model(&models.OutboundMessage{}).
+		Where("user_id = ? AND status = ? AND lease_expires_at < ? AND attempts < max_attempts", userID, models.OutboundClaimed, now).
+		Updates(map[string]interface{}{"status": models.OutboundQueued, "lease_expires_at": nil, "error": "claim timed out"})
+	h.db.Model(&models.OutboundMessage{}).
+		Where("user_id = ? AND status = ? AND lease_expires_at < ?", userID, models.OutboundClaimed, now).
+		Updates(map[string]interface{}{"status": models.OutboundFailed, "lease_expires_at": nil, "error": "claim timed out"})
+
+	// Claimed messages are left to their lease: the phone may be sending right now
+	var expired []models.OutboundMessage
+	h.db.Where("user_id = ? AND status = ? AND expires_at < ?", userID, models.OutboundQueued, now).Find(&expired)
+	for i := range expired {
+		result := h.db.Model(&models.OutboundMessage{}).
+			Where("id = ? AND status = ?", expired[i].ID, models.OutboundQueued).
+			Update("status", models.OutboundExpired)
+		if result.Error == nil && result.RowsAffected == 1 {
+			expired[i].Status = models.OutboundExpired
+			h.publishOutboundStatus(&expired[i])
+		}
+	}
+}
+
+func (h *MessagesHandler) publishOutboundStatus(msg *models.OutboundMessage) {
+	h.hub.Publish(msg.UserID, events.Event{
+		Type:  EventOutboundStatus,
+		Scope: models.ScopeMessagesRead,
+		Data: gin.H{
+			"id":        msg.ID,
+			"status":    msg.Status,
+			"attempts":  msg.Attempts,
+			"error":     msg.Error,
+			"sentAt":    msg.SentAt,
+			"threadKey": msg.ThreadKey,
+		},
+	})
+}
@@ -2,16 +2,17 @@ package handlers
 
 import (
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"clipsync/backend/internal/auth"
 	"clipsync/backend/internal/config"
 	"clipsync/backend/internal/models"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"gorm.io/gorm"
 )
 
@@ -32,6 +33,24 @@ func (h *PairingHandler) GeneratePairingCode(c *gin.Context) {
 
 	userIDStr := userID.(string)
 
+	// Optional ephemeral X25519 public key of this device, embedded in the signed QR token
+	var publicKey *string
+	if pk := c.Query("publicKey"); pk != "" {
+		raw, err := base64.StdEncoding.DecodeString(pk)
+		if err != nil || len(raw) != 32 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "publicKey must be a base64-encoded 32-byte X25519 key"})
+			return
+		}
+		publicKey = &pk
+	}
+
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate code"})
+		return
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+
 	bytes := make([]byte, 3)
 	if _, err := rand.Read(bytes); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate code"})
@@ -51,6 +70,23 @@ func (h *PairingHandler) GeneratePairingCode(c *gin.Context) {
 		UserID:    userIDStr,
 		ExpiresAt: time.Now().Add(5 * time.Minute),
 		Used:      false,
+		Nonce:     &nonce,
+		PublicKey: publicKey,
+	}
+
+	payload := pairingTokenPayload{
+		Version:   pairingTokenVersion,
+		ServerURL: config.Get().PublicURL,
+		Nonce:     nonce,
+		ExpiresAt: pairingCode.ExpiresAt.Unix(),
+	}
+	if publicKey != nil {
+		payload.PublicKey = *publicKey
+	}
+	qrToken, err := signPairingToken(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign pairing token"})
+		return
 	}
 
 	if err := h.db.Create(&pairingCode).Error; err != nil {
@@ -58,31 +94,49 @@ func (h *PairingHandler) GeneratePairingCode(c *gin.Context) {
 		return
 	}
 
+	// The short code stays as a typed fallback; the QR carries the signed token
 	c.JSON(http.StatusOK, gin.H{
 		"code":      code,
 		"expiresAt": pairingCode.ExpiresAt,
-		"qrData":    fmt.Sprintf("clipsync://pair/%s", code),
+		"qrData":    fmt.Sprintf("clipsync://pair/%s", qrToken),
+		"serverUrl": payload.ServerURL,
 	})
 }
 
+// VerifyPairingCode accepts either the short typed code or the signed QR token.
 func (h *PairingHandler) VerifyPairingCode(c *gin.Context) {
 	var req struct {
-		Code string `json:"code" binding:"required"`
+		Code     string `json:"code"`
+		Token    string `json:"token"`
+		DeviceID string `json:"deviceId"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.Token == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code or token is required"})
 		return
 	}
 
-	code := strings.ToUpper(strings.TrimSpace(req.Code))
-	if len(code) != 6 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code format"})
-		return
+	query := h.db
+	var tokenPayload *pairingTokenPayload
+	if token := strings.TrimPrefix(strings.TrimSpace(req.Token), "clipsync://pair/"); token != "" {
+		payload, err := parsePairingToken(token)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tokenPayload = payload
+		query = query.Where("nonce = ?", payload.Nonce)
+	} else {
+		code := strings.ToUpper(strings.TrimSpace(req.Code))
+		if len(code) != 6 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code format"})
+			return
+		}
+		query = query.Where("UPPER(code) = ?", code)
 	}
 
 	var pairingCode models.PairingCode
-	if err := h.db.Where("UPPER(code) = ?", code).First(&pairingCode).Error; err != nil {
+	if err := query.First(&pairingCode).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid pairing code"})
 			return
@@ -103,32 +157,38 @@ func (h *PairingHandler) VerifyPairingCode(c *gin.Context) {
 		}
 	}
 
-	// Mark code as used
-	pairingCode.Used = true
-	if err := h.db.Save(&pairingCode).Error; err != nil {
+	// Mark code as used; the used = false guard keeps concurrent redemptions single-use
+	result := h.db.Model(&models.PairingCode{}).
+		Where("id = ? AND used = ?", pairingCode.ID, false).
+		Update("used", true)
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to use pairing code"})
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pairing code already used"})
+		return
+	}
 
-	// Generate JWT token for the user
-	token := h.generateToken(pairingCode.UserID)
+	// Issue a short-lived access token plus the first refresh token of a new family
+	tokens, err := auth.IssueTokens(h.db, pairingCode.UserID, req.DeviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue tokens"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": token,
+	resp := gin.H{
+		"token": tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresAt": tokens.ExpiresAt,
 		"userId": pairingCode.UserID,
 		"message": "Pairing successful",
-	})
-}
-
-func (h *PairingHandler) generateToken(userID string) string {
-	claims := jwt.MapClaims{
-		"userId": userID,
-		"exp":    time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
-		"iat":    time.Now().Unix(),
 	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, _ := token.SignedString([]byte(config.Get().JWTSecret))
-
-	return tokenString
+	if tokenPayload != nil {
+		resp["serverUrl"] = tokenPayload.ServerURL
+		if pairingCode.PublicKey != nil {
+			resp["peerPublicKey"] = *pairingCode.PublicKey
+		}
+	}
+	c.JSON(http.StatusOK, resp)
 }
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"clipsync/backend/internal/config"
+)
+
+// pairingTokenVersion is bumped whenever the signed QR payload layout changes.
+const pairingTokenVersion = 1
+
+var (
+	errPairingTokenMalformed = errors.New("malformed pairing token")
+	errPairingTokenSignature = errors.New("invalid pairing token signature")
+	errPairingTokenExpired   = errors.New("pairing token expired")
+	errPairingTokenServer    = errors.New("pairing token issued for a different server")
+)
+
+// pairingTokenPayload is the signed content of a QR pairing token. It lets a mobile
+// app discover a self-hosted server from the QR alone and set up an encrypted
+// channel with the issuing device using its ephemeral public key.
+type pairingTokenPayload struct {
+	Version   int    `json:"v"`
+	ServerURL string `json:"url"`
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"exp"`
+	PublicKey string `json:"pk,omitempty"` // Base64 X25519 public key of the issuing device
+}
+
+// signPairingToken encodes the payload as base64url(json) + "." + base64url(HMAC-SHA256).
+func signPairingToken(p pairingTokenPayload) (string, error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(body)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(pairingMAC(encoded)), nil
+}
+
+// parsePairingToken verifies the signature, expiry and server binding of a QR token.
+func parsePairingToken(token string) (*pairingTokenPayload, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, errPairingTokenMalformed
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errPairingTokenMalformed
+	}
+	if !hmac.Equal(sig, pairingMAC(parts[0])) {
+		return nil, errPairingTokenSignature
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errPairingTokenMalformed
+	}
+	var p pairingTokenPayload
+	if err := json.Unmarshal(body, &p); err != nil || p.Version != pairingTokenVersion || p.Nonce == "" {
+		return nil, errPairingTokenMalformed
+	}
+
+	if time.Now().Unix() > p.ExpiresAt {
+		return nil, errPairingTokenExpired
+	}
+	if p.ServerURL != config.Get().PublicURL {
+		return nil, errPairingTokenServer
+	}
+
+	return &p, nil
+}
+
+func pairingMAC(encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, []byte(config.Get().PairingSecret))
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
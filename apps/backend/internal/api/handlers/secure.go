@@ -3,12 +3,15 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"io"
 	"net/http"
 
 	"clipsync/backend/internal/models"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SecureHandler struct {
@@ -19,7 +22,7 @@ func NewSecureHandler(db *gorm.DB) *SecureHandler {
 	return &SecureHandler{db: db}
 }
 
-// GetVaultStatus returns whether the user has a vault and the salt for key derivation
+// GetVaultStatus returns whether the user has a vault and the salt and KDF descriptor for key derivation
 func (h *SecureHandler) GetVaultStatus(c *gin.Context) {
 	userID, _ := c.Get("userId")
 	userIDStr := userID.(string)
@@ -37,15 +40,73 @@ func (h *SecureHandler) GetVaultStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"exists": true,
 		"salt":   vault.Salt,
+		"kdf":    vault.KDF,
+		"keyScheme":     vault.KeyScheme,
+		"verifier":      vault.Verifier,
+		"verifierNonce": vault.VerifierNonce,
+		"e2eEnabled":    vault.E2EEnabled,
 		"createdAt": vault.CreatedAt,
 	})
 }
 
+// VaultVerifierFields carry the key verifier a client computes after deriving the vault key.
+// All three are set together or not at all.
+type VaultVerifierFields struct {
+	Verifier       string `json:"verifier"`       // Base64 AES-GCM ciphertext of a fixed known plaintext
+	VerifierNonce  string `json:"verifierNonce"`  // Base64 96-bit nonce
+	KeyFingerprint string `json:"keyFingerprint"` // Base64 key-check value derived from the vault key
+}
+
+func (f VaultVerifierFields) isSet() bool {
+	return f.Verifier != "" || f.VerifierNonce != "" || f.KeyFingerprint != ""
+}
+
+func (f VaultVerifierFields) validate() error {
+	if f.Verifier == "" || f.VerifierNonce == "" || f.KeyFingerprint == "" {
+		return errors.New("verifier, verifierNonce and keyFingerprint must be provided together")
+	}
+	if len(f.KeyFingerprint) > 88 {
+		return errors.New("keyFingerprint too long")
+	}
+	return nil
+}
+
+func (f VaultVerifierFields) applyTo(vault *models.UserVault) {
+	vault.Verifier = &f.Verifier
+	vault.VerifierNonce = &f.VerifierNonce
+	vault.KeyFingerprint = &f.KeyFingerprint
+}
+
+type CreateVaultRequest struct {
+	KDF *models.KDFParams `json:"kdf"` // Optional; defaults to the legacy PBKDF2 parameters
+	VaultVerifierFields
+}
+
 // CreateVault creates a new vault with a random salt. Client uses salt + master password for key derivation.
 func (h *SecureHandler) CreateVault(c *gin.Context) {
 	userID, _ := c.Get("userId")
 	userIDStr := userID.(string)
 
+	var req CreateVaultRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	kdf := models.DefaultKDFParams()
+	if req.KDF != nil {
+		kdf = *req.KDF
+		if err := kdf.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.VaultVerifierFields.isSet() {
+		if err := req.VaultVerifierFields.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// Check if vault already exists
 	var existing models.UserVault
 	if err := h.db.Where("user_id = ?", userIDStr).First(&existing).Error; err == nil {
@@ -64,6 +125,10 @@ func (h *SecureHandler) CreateVault(c *gin.Context) {
 	vault := models.UserVault{
 		UserID: userIDStr,
 		Salt:   saltB64,
+		KDF:    kdf,
+	}
+	if req.VaultVerifierFields.isSet() {
+		req.VaultVerifierFields.applyTo(&vault)
 	}
 	if err := h.db.Create(&vault).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create vault"})
@@ -72,13 +137,18 @@ func (h *SecureHandler) CreateVault(c *gin.Context) {
 
 	c.JSON(http.StatusCreated, gin.H{
 		"salt": vault.Salt,
+		"kdf":  vault.KDF,
 		"createdAt": vault.CreatedAt,
 	})
 }
 
 type CreateSecureClipRequest struct {
-	EncryptedPayload string `json:"encryptedPayload" binding:"required"`
-	Nonce            string `json:"nonce" binding:"required"`
+	EncryptedPayload  string `json:"encryptedPayload" binding:"required"`
+	Nonce             string `json:"nonce" binding:"required"`
+	ItemType          string `json:"itemType" binding:"max=32"`      // Optional plaintext type tag, e.g. password, note, card, totp
+	EncryptedMetadata string `json:"encryptedMetadata"`              // Optional; requires metadataNonce
+	MetadataNonce     string `json:"metadataNonce" binding:"max=32"` // Base64 96-bit nonce for the metadata blob
+	KeyFingerprint    string `json:"keyFingerprint"`                 // Required once the vault has a verifier
 }
 
 // CreateSecureClip stores an encrypted clip (client encrypts before sending)
@@ -91,37 +161,64 @@ func (h *SecureHandler) CreateSecureClip(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Ensure user has a vault
-	var vault models.UserVault
-	if err := h.db.Where("user_id = ?", userIDStr).First(&vault).Error; err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Vault not set up"})
+	if (req.EncryptedMetadata == "") != (req.MetadataNonce == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "encryptedMetadata and metadataNonce must be provided together"})
 		return
 	}
 
 	clip := models.SecureClip{
 		UserID:           userIDStr,
+		ItemType:         req.ItemType,
 		EncryptedPayload: req.EncryptedPayload,
 		Nonce:            req.Nonce,
 	}
-	if err := h.db.Create(&clip).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secure clip"})
+	if req.EncryptedMetadata != "" {
+		clip.EncryptedMetadata = &req.EncryptedMetadata
+		clip.MetadataNonce = &req.MetadataNonce
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Ensure user has a vault; the share lock keeps this insert from racing a re-key
+		vault, err := lockVault(tx, userIDStr, "SHARE")
+		if err != nil {
+			return err
+		}
+		if !vault.MatchesFingerprint(req.KeyFingerprint) {
+			return errKeyMismatch
+		}
+		return tx.Create(&clip).Error
+	})
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Vault not set up"})
+		case errKeyMismatch:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Key fingerprint does not match the vault key"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secure clip"})
+		}
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
 		"id":        clip.ID,
+		"revision":  clip.Revision,
 		"createdAt": clip.CreatedAt,
 	})
 }
 
-// GetSecureClips returns all encrypted clips for the user (client decrypts)
+// GetSecureClips returns all encrypted clips for the user (client decrypts).
+// Optional ?type= filters by item type.
 func (h *SecureHandler) GetSecureClips(c *gin.Context) {
 	userID, _ := c.Get("userId")
 	userIDStr := userID.(string)
 
+	query := h.db.Where("user_id = ?", userIDStr)
+	if itemType := c.Query("type"); itemType != "" {
+		query = query.Where("item_type = ?", itemType)
+	}
+
 	var clips []models.SecureClip
-	if err := h.db.Where("user_id = ?", userIDStr).Order("created_at DESC").Find(&clips).Error; err != nil {
+	if err := query.Order("created_at DESC").Find(&clips).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch secure clips"})
 		return
 	}
@@ -129,47 +226,164 @@ func (h *SecureHandler) GetSecureClips(c *gin.Context) {
 	c.JSON(http.StatusOK, clips)
 }
 
+// ListSecureClipSummaries returns the list view of secure clips (type tag and encrypted
+// metadata, no payload) so clients can render a vault without decrypting every secret.
+func (h *SecureHandler) ListSecureClipSummaries(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	query := h.db.Model(&models.SecureClip{}).Where("user_id = ?", userIDStr)
+	if itemType := c.Query("type"); itemType != "" {
+		query = query.Where("item_type = ?", itemType)
+	}
+
+	var summaries []models.SecureClipSummary
+	if err := query.Select("id, item_type, encrypted_metadata, metadata_nonce, revision, created_at, updated_at").
+		Order("created_at DESC").Scan(&summaries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch secure clips"})
+		return
+	}
+
+	c.JSON(http.StatusOK, summaries)
+}
+
 type UpdateSecureClipRequest struct {
 	EncryptedPayload string `json:"encryptedPayload" binding:"required"`
 	Nonce            string `json:"nonce" binding:"required"`
+	Revision         int    `json:"revision"` // Optional; if set, must match the stored revision
+	KeyFingerprint   string `json:"keyFingerprint"` // Required once the vault has a verifier
 }
 
-// UpdateSecureClip updates an encrypted clip
+// UpdateSecureClip replaces the encrypted payload of a clip
 func (h *SecureHandler) UpdateSecureClip(c *gin.Context) {
-	userID, _ := c.Get("userId")
-	userIDStr := userID.(string)
-	clipID := c.Param("id")
-
 	var req UpdateSecureClipRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var clip models.SecureClip
-	if err := h.db.Where("id = ? AND user_id = ?", clipID, userIDStr).First(&clip).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Secure clip not found"})
+	h.modifySecureClip(c, req.Revision, req.KeyFingerprint, func(clip *models.SecureClip) {
+		clip.EncryptedPayload = req.EncryptedPayload
+		clip.Nonce = req.Nonce
+	})
+}
+
+type PatchSecureClipRequest struct {
+	EncryptedPayload  *string `json:"encryptedPayload"` // Requires nonce
+	Nonce             *string `json:"nonce" binding:"omitempty,max=32"`
+	EncryptedMetadata *string `json:"encryptedMetadata"` // Requires metadataNonce; empty string clears metadata
+	MetadataNonce     *string `json:"metadataNonce" binding:"omitempty,max=32"`
+	ItemType          *string `json:"itemType" binding:"omitempty,max=32"`
+	Revision          int     `json:"revision"`       // Optional; if set, must match the stored revision
+	KeyFingerprint    string  `json:"keyFingerprint"` // Required once the vault has a verifier
+}
+
+// PatchSecureClip updates individual fields, e.g. only the metadata blob or type tag,
+// without re-uploading the payload.
+func (h *SecureHandler) PatchSecureClip(c *gin.Context) {
+	var req PatchSecureClipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.EncryptedPayload == nil) != (req.Nonce == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "encryptedPayload and nonce must be provided together"})
 		return
 	}
+	if (req.EncryptedMetadata == nil) != (req.MetadataNonce == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "encryptedMetadata and metadataNonce must be provided together"})
+		return
+	}
+	if req.EncryptedPayload == nil && req.EncryptedMetadata == nil && req.ItemType == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	h.modifySecureClip(c, req.Revision, req.KeyFingerprint, func(clip *models.SecureClip) {
+		if req.EncryptedPayload != nil {
+			clip.EncryptedPayload = *req.EncryptedPayload
+			clip.Nonce = *req.Nonce
+		}
+		if req.EncryptedMetadata != nil {
+			if *req.EncryptedMetadata == "" {
+				clip.EncryptedMetadata, clip.MetadataNonce = nil, nil
+			} else {
+				clip.EncryptedMetadata, clip.MetadataNonce = req.EncryptedMetadata, req.MetadataNonce
+			}
+		}
+		if req.ItemType != nil && *req.ItemType != "" {
+			clip.ItemType = *req.ItemType
+		}
+	})
+}
+
+// modifySecureClip applies a change to one clip under the vault share lock, checking the
+// key fingerprint and optional expected revision, and bumps the revision. Overwritten
+// ciphertext is appended to the clip's version history.
+func (h *SecureHandler) modifySecureClip(c *gin.Context, revision int, keyFingerprint string, apply func(*models.SecureClip)) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+	clipID := c.Param("id")
+
+	var clip models.SecureClip
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		vault, err := lockVault(tx, userIDStr, "SHARE")
+		if err != nil {
+			return err
+		}
+		if !vault.MatchesFingerprint(keyFingerprint) {
+			return errKeyMismatch
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", clipID, userIDStr).First(&clip).Error; err != nil {
+			return err
+		}
+		if revision != 0 && revision != clip.Revision {
+			return errRevisionConflict
+		}
 
-	clip.EncryptedPayload = req.EncryptedPayload
-	clip.Nonce = req.Nonce
-	if err := h.db.Save(&clip).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update"})
+		snapshot := models.NewSecureClipVersion(&clip)
+		apply(&clip)
+		if ciphertextChanged(snapshot, &clip) {
+			if err := recordSecureClipVersion(tx, snapshot); err != nil {
+				return err
+			}
+		}
+		clip.Revision++
+		return tx.Save(&clip).Error
+	})
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Secure clip not found"})
+		case errRevisionConflict:
+			c.JSON(http.StatusConflict, gin.H{"error": "Secure clip was modified concurrently"})
+		case errKeyMismatch:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Key fingerprint does not match the vault key"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update"})
+		}
 		return
 	}
 
 	c.JSON(http.StatusOK, clip)
 }
 
-// DeleteSecureClip deletes a secure clip
+// DeleteSecureClip deletes a secure clip and its version history
 func (h *SecureHandler) DeleteSecureClip(c *gin.Context) {
 	userID, _ := c.Get("userId")
 	userIDStr := userID.(string)
 	clipID := c.Param("id")
 
-	result := h.db.Where("id = ? AND user_id = ?", clipID, userIDStr).Delete(&models.SecureClip{})
-	if result.Error != nil {
+	var result *gorm.DB
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		result = tx.Where("id = ? AND user_id = ?", clipID, userIDStr).Delete(&models.SecureClip{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Where("secure_clip_id = ? AND user_id = ?", clipID, userIDStr).Delete(&models.SecureClipVersion{}).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete"})
 		return
 	}
@@ -180,3 +394,50 @@ func (h *SecureHandler) DeleteSecureClip(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
 }
+
+type SetVaultVerifierRequest struct {
+	CurrentKeyFingerprint string `json:"currentKeyFingerprint"` // Required when replacing an existing verifier
+	VaultVerifierFields
+}
+
+// SetVaultVerifier attaches a key verifier to a vault created before verifiers existed,
+// or replaces it when the caller proves the current key.
+func (h *SecureHandler) SetVaultVerifier(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req SetVaultVerifierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.VaultVerifierFields.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		vault, err := lockVault(tx, userIDStr, "UPDATE")
+		if err != nil {
+			return err
+		}
+		if vault.HasVerifier() && !vault.MatchesFingerprint(req.CurrentKeyFingerprint) {
+			return errKeyMismatch
+		}
+		req.VaultVerifierFields.applyTo(vault)
+		return tx.Save(vault).Error
+	})
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vault not set up"})
+		case errKeyMismatch:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Key fingerprint does not match the vault key"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set verifier"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verifier updated"})
+}
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	vaultBundleFormat  = "clipsync-vault"
+	vaultBundleVersion = 1
+
+	// maxVaultBundleBytes bounds import uploads.
+	maxVaultBundleBytes = 64 << 20
+	exportBatchSize     = 200
+)
+
+var errVaultNotEmpty = errors.New("vault not empty")
+
+// VaultBundle is the versioned export format of a vault. Everything in it is either
+// public KDF input or ciphertext; the server never sees plaintext.
+type VaultBundle struct {
+	VaultBundleHeader
+	Clips []BundleSecureClip `json:"clips"`
+}
+
+// VaultBundleHeader is everything in a bundle except the clips, which are streamed.
+type VaultBundleHeader struct {
+	Format     string         `json:"format"`
+	Version    int            `json:"version"`
+	ExportedAt time.Time      `json:"exportedAt"`
+	Vault      BundleVault    `json:"vault"`
+	Wrappers   []WrapperInput `json:"wrappers,omitempty"`
+	KeyPair    *BundleKeyPair `json:"keyPair,omitempty"`
+}
+
+type BundleVault struct {
+	Salt      string           `json:"salt"`
+	KDF       models.KDFParams `json:"kdf"`
+	KeyScheme string           `json:"keyScheme"`
+	VaultVerifierFields
+}
+
+type BundleKeyPair struct {
+	PublicKey           string `json:"publicKey"`
+	EncryptedPrivateKey string `json:"encryptedPrivateKey"`
+	PrivateKeyNonce     string `json:"privateKeyNonce"`
+}
+
+type BundleSecureClip struct {
+	ItemType          string          `json:"itemType"`
+	EncryptedPayload  string          `json:"encryptedPayload"`
+	Nonce             string          `json:"nonce"`
+	EncryptedMetadata *string         `json:"encryptedMetadata,omitempty"`
+	MetadataNonce     *string         `json:"metadataNonce,omitempty"`
+	CreatedAt         time.Time       `json:"createdAt"`
+	UpdatedAt         time.Time       `json:"updatedAt"`
+	Versions          []BundleVersion `json:"versions,omitempty"`
+}
+
+type BundleVersion struct {
+	Revision          int       `json:"revision"`
+	ItemType          string    `json:"itemType"`
+	EncryptedPayload  string    `json:"encryptedPayload"`
+	Nonce             string    `json:"nonce"`
+	EncryptedMetadata *string   `json:"encryptedMetadata,omitempty"`
+	MetadataNonce     *string   `json:"metadataNonce,omitempty"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// ExportVault streams the vault as a VaultBundle. Clips are written in batches so large
+// vaults are never held in memory at once.
+func (h *SecureHandler) ExportVault(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var vault models.UserVault
+	if err := h.db.Where("user_id = ?", userIDStr).First(&vault).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vault not set up"})
+		return
+	}
+
+	header := VaultBundleHeader{
+		Format:     vaultBundleFormat,
+		Version:    vaultBundleVersion,
+		ExportedAt: time.Now().UTC(),
+		Vault: BundleVault{
+			Salt:      vault.Salt,
+			KDF:       vault.KDF,
+			KeyScheme: vault.KeyScheme,
+		},
+	}
+	if vault.HasVerifier() {
+		header.Vault.VaultVerifierFields = VaultVerifierFields{
+			Verifier:       *vault.Verifier,
+			VerifierNonce:  *vault.VerifierNonce,
+			KeyFingerprint: *vault.KeyFingerprint,
+		}
+	}
+
+	var wrappers []models.VaultKeyWrapper
+	h.db.Where("user_id = ? AND consumed_at IS NULL", userIDStr).Order("created_at ASC").Find(&wrappers)
+	for _, w := range wrappers {
+		in := WrapperInput{Kind: w.Kind, Label: w.Label, WrappedKey: w.WrappedKey, Nonce: w.Nonce, KDF: w.KDF}
+		if w.LookupID != nil {
+			in.LookupID = *w.LookupID
+		}
+		if w.Salt != nil {
+			in.Salt = *w.Salt
+		}
+		header.Wrappers = append(header.Wrappers, in)
+	}
+
+	var keyPair models.UserKeyPair
+	if h.db.Where("user_id = ?", userIDStr).First(&keyPair).Error == nil {
+		header.KeyPair = &BundleKeyPair{
+			PublicKey:           keyPair.PublicKey,
+			EncryptedPrivateKey: keyPair.EncryptedPrivateKey,
+			PrivateKeyNonce:     keyPair.PrivateKeyNonce,
+		}
+	}
+
+	// Write the header object with an open clips array, then each clip as it is read
+	head, err := json.Marshal(header)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export vault"})
+		return
+	}
+	head = head[:len(head)-1] // Drop the closing brace
+
+	c.Header("Content-Type", "application/json")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="clipsync-vault-%s.json"`, header.ExportedAt.Format("20060102")))
+	c.Status(http.StatusOK)
+	c.Writer.Write(head)
+	c.Writer.Write([]byte(`,"clips":[`))
+
+	// Page on the (created_at, id) keyset: IDs are random, so an id-only cursor over a
+	// created_at ordering would skip and repeat clips between batches
+	first := true
+	var cursor *models.SecureClip
+	for {
+		query := h.db.Where("user_id = ?", userIDStr)
+		if cursor != nil {
+			query = query.Where("(created_at, id) > (?, ?)", cursor.CreatedAt, cursor.ID)
+		}
+		var clips []models.SecureClip
+		if err := query.Order("created_at ASC, id ASC").Limit(exportBatchSize).Find(&clips).Error; err != nil {
+			abortStream(c, err)
+			return
+		}
+		if len(clips) == 0 {
+			break
+		}
+
+		ids := make([]uuid.UUID, 0, len(clips))
+		for _, clip := range clips {
+			ids = append(ids, clip.ID)
+		}
+		var versions []models.SecureClipVersion
+		if err := h.db.Where("secure_clip_id IN ?", ids).Order("revision ASC").Find(&versions).Error; err != nil {
+			abortStream(c, err)
+			return
+		}
+		byClip := make(map[uuid.UUID][]BundleVersion)
+		for _, v := range versions {
+			byClip[v.SecureClipID] = append(byClip[v.SecureClipID], BundleVersion{
+				Revision:          v.Revision,
+				ItemType:          v.ItemType,
+				EncryptedPayload:  v.EncryptedPayload,
+				Nonce:             v.Nonce,
+				EncryptedMetadata: v.EncryptedMetadata,
+				MetadataNonce:     v.MetadataNonce,
+				CreatedAt:         v.CreatedAt,
+			})
+		}
+
+		for _, clip := range clips {
+			item, err := json.Marshal(BundleSecureClip{
+				ItemType:          clip.ItemType,
+				EncryptedPayload:  clip.EncryptedPayload,
+				Nonce:             clip.Nonce,
+				EncryptedMetadata: clip.EncryptedMetadata,
+				MetadataNonce:     clip.MetadataNonce,
+				CreatedAt:         clip.CreatedAt,
+				UpdatedAt:         clip.UpdatedAt,
+				Versions:          byClip[clip.ID],
+			})
+			if err != nil {
+				abortStream(c, err)
+				return
+			}
+			if !first {
+				c.Writer.Write([]byte(","))
+			}
+			first = false
+			c.Writer.Write(item)
+		}
+		c.Writer.Flush()
+
+		if len(clips) < exportBatchSize {
+			break
+		}
+		cursor = &clips[len(clips)-1]
+	}
+
+	c.Writer.Write([]byte("]}"))
+}
+
+// abortStream cuts the connection of a streamed response that failed part-way. The
+// status line is already sent, so closing the body normally would hand the client a
+// truncated bundle that still parses; a reset connection cannot be mistaken for one.
+func abortStream(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+	if conn, _, hijackErr := c.Writer.Hijack(); hijackErr == nil {
+		conn.Close()
+		return
+	}
+	panic(http.ErrAbortHandler)
+}
+
+func (b *VaultBundle) validate() error {
+	if b.Format != vaultBundleFormat {
+		return fmt.Errorf("not a %s bundle", vaultBundleFormat)
+	}
+	if b.Version != vaultBundleVersion {
+		return fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	if salt, err := base64.StdEncoding.DecodeString(b.Vault.Salt); err != nil || len(salt) < 16 {
+		return errors.New("vault.salt must be base64 of at least 16 bytes")
+	}
+	if err := b.Vault.KDF.Validate(); err != nil {
+		return err
+	}
+	if b.Vault.VaultVerifierFields.isSet() {
+		if err := b.Vault.VaultVerifierFields.validate(); err != nil {
+			return err
+		}
+	}
+	if b.Vault.KeyScheme == "" {
+		b.Vault.KeyScheme = models.KeySchemePassword
+	}
+	switch b.Vault.KeyScheme {
+	case models.KeySchemePassword:
+		if len(b.Wrappers) > 0 {
+			return errors.New("wrappers are only valid with the wrapped key scheme")
+		}
+	case models.KeySchemeWrapped:
+		if !b.Vault.VaultVerifierFields.isSet() {
+			return errors.New("the wrapped scheme requires a verifier")
+		}
+	default:
+		return fmt.Errorf("unknown key scheme %q", b.Vault.KeyScheme)
+	}
+	if b.KeyPair != nil && (!validX25519Key(b.KeyPair.PublicKey) || b.KeyPair.EncryptedPrivateKey == "" || b.KeyPair.PrivateKeyNonce == "") {
+		return errors.New("invalid keyPair")
+	}
+	for i, clip := range b.Clips {
+		if clip.EncryptedPayload == "" || clip.Nonce == "" || len(clip.Nonce) > 32 || len(clip.ItemType) > 32 {
+			return fmt.Errorf("clips[%d] is invalid", i)
+		}
+		if (clip.EncryptedMetadata == nil) != (clip.MetadataNonce == nil) {
+			return fmt.Errorf("clips[%d] has incomplete metadata", i)
+		}
+		for j, v := range clip.Versions {
+			if v.EncryptedPayload == "" || v.Nonce == "" || len(v.Nonce) > 32 {
+				return fmt.Errorf("clips[%d].versions[%d] is invalid", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// ImportVault restores a VaultBundle into a new or empty vault in one transaction.
+func (h *SecureHandler) ImportVault(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxVaultBundleBytes)
+	var bundle VaultBundle
+	if err := json.NewDecoder(c.Request.Body).Decode(&bundle); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bundle: " + err.Error()})
+		return
+	}
+	if err := bundle.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var wrappers []*models.VaultKeyWrapper
+	if bundle.Vault.KeyScheme == models.KeySchemeWrapped {
+		var err error
+		if wrappers, err = buildWrappers(userIDStr, bundle.Wrappers, true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		vault, err := lockVault(tx, userIDStr, "UPDATE")
+		if err == gorm.ErrRecordNotFound {
+			vault = &models.UserVault{UserID: userIDStr}
+		} else if err != nil {
+			return err
+		} else {
+			var count int64
+			if err := tx.Model(&models.SecureClip{}).Where("user_id = ?", userIDStr).Count(&count).Error; err != nil {
+				return err
+			}
+			e2eRows, err := countE2ERows(tx, userIDStr)
+			if err != nil {
+				return err
+			}
+			if count > 0 || e2eRows > 0 {
+				return errVaultNotEmpty
+			}
+		}
+
+		vault.Salt = bundle.Vault.Salt
+		vault.KDF = bundle.Vault.KDF
+		vault.KeyScheme = bundle.Vault.KeyScheme
+		vault.Verifier, vault.VerifierNonce, vault.KeyFingerprint = nil, nil, nil
+		if bundle.Vault.VaultVerifierFields.isSet() {
+			bundle.Vault.VaultVerifierFields.applyTo(vault)
+		}
+		if err := tx.Save(vault).Error; err != nil {
+			return err
+		}
+
+		if err := replaceWrappers(tx, userIDStr, wrappers); err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userIDStr).Delete(&models.UserKeyPair{}).Error; err != nil {
+			return err
+		}
+		if bundle.KeyPair != nil {
+			if err := tx.Create(&models.UserKeyPair{
+				UserID:              userIDStr,
+				PublicKey:           bundle.KeyPair.PublicKey,
+				EncryptedPrivateKey: bundle.KeyPair.EncryptedPrivateKey,
+				PrivateKeyNonce:     bundle.KeyPair.PrivateKeyNonce,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, bc := range bundle.Clips {
+			clip := models.SecureClip{
+				UserID:            userIDStr,
+				ItemType:          bc.ItemType,
+				EncryptedPayload:  bc.EncryptedPayload,
+				Nonce:             bc.Nonce,
+				EncryptedMetadata: bc.EncryptedMetadata,
+				MetadataNonce:     bc.MetadataNonce,
+				Revision:          len(bc.Versions) + 1,
+				CreatedAt:         bc.CreatedAt,
+				UpdatedAt:         bc.UpdatedAt,
+			}
+			if err := tx.Create(&clip).Error; err != nil {
+				return err
+			}
+			for i, bv := range bc.Versions {
+				if err := tx.Create(&models.SecureClipVersion{
+					SecureClipID:      clip.ID,
+					UserID:            userIDStr,
+					Revision:          i + 1,
+					ItemType:          bv.ItemType,
+					EncryptedPayload:  bv.EncryptedPayload,
+					Nonce:             bv.Nonce,
+					EncryptedMetadata: bv.EncryptedMetadata,
+					MetadataNonce:     bv.MetadataNonce,
+					CreatedAt:         bv.CreatedAt,
+				}).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if err == errVaultNotEmpty {
+			c.JSON(http.StatusConflict, gin.H{"error": "Vault already contains encrypted data; import requires a new or empty vault"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import vault"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"imported": len(bundle.Clips),
+		"message":  "Vault imported",
+	})
+}
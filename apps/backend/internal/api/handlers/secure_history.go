@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// recordSecureClipVersion appends a snapshot to a clip's history and trims the history
+// to the configured depth.
+func recordSecureClipVersion(tx *gorm.DB, snapshot *models.SecureClipVersion) error {
+	depth := config.Get().SecureHistoryDepth
+	if depth == 0 {
+		return nil
+	}
+	if err := tx.Create(snapshot).Error; err != nil {
+		return err
+	}
+
+	keep := tx.Model(&models.SecureClipVersion{}).Select("id").
+		Where("secure_clip_id = ?", snapshot.SecureClipID).
+		Order("revision DESC").Limit(depth)
+	return tx.Where("secure_clip_id = ? AND id NOT IN (?)", snapshot.SecureClipID, keep).
+		Delete(&models.SecureClipVersion{}).Error
+}
+
+func ciphertextChanged(before *models.SecureClipVersion, after *models.SecureClip) bool {
+	return before.EncryptedPayload != after.EncryptedPayload ||
+		before.Nonce != after.Nonce ||
+		!equalStringPtr(before.EncryptedMetadata, after.EncryptedMetadata) ||
+		!equalStringPtr(before.MetadataNonce, after.MetadataNonce)
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// ListSecureClipVersions returns the prior versions of a secure clip, newest first.
+func (h *SecureHandler) ListSecureClipVersions(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+	clipID := c.Param("id")
+
+	var clip models.SecureClip
+	if err := h.db.Select("id").Where("id = ? AND user_id = ?", clipID, userIDStr).First(&clip).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Secure clip not found"})
+		return
+	}
+
+	var versions []models.SecureClipVersion
+	if err := h.db.Where("secure_clip_id = ? AND user_id = ?", clip.ID, userIDStr).
+		Order("revision DESC").Find(&versions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+type RestoreSecureClipVersionRequest struct {
+	Revision       int    `json:"revision"`       // Optional; if set, must match the clip's current revision
+	KeyFingerprint string `json:"keyFingerprint"` // Required once the vault has a verifier
+}
+
+// RestoreSecureClipVersion copies a prior version's ciphertext back onto the clip. The
+// current ciphertext is kept in history, so a restore can itself be undone.
+func (h *SecureHandler) RestoreSecureClipVersion(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req RestoreSecureClipVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var version models.SecureClipVersion
+	if err := h.db.Where("id = ? AND secure_clip_id = ? AND user_id = ?", c.Param("versionId"), c.Param("id"), userIDStr).
+		First(&version).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+
+	h.modifySecureClip(c, req.Revision, req.KeyFingerprint, func(clip *models.SecureClip) {
+		clip.ItemType = version.ItemType
+		clip.EncryptedPayload = version.EncryptedPayload
+		clip.Nonce = version.Nonce
+		clip.EncryptedMetadata = version.EncryptedMetadata
+		clip.MetadataNonce = version.MetadataNonce
+	})
+}
@@ -0,0 +1,526 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"clipsync/backend/internal/atrest"
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errRevisionConflict   = errors.New("revision conflict")
+	errVaultChanged       = errors.New("vault changed")
+	errKDFDowngrade       = errors.New("kdf downgrade")
+	errKeyMismatch        = errors.New("key fingerprint mismatch")
+	errVerifierRequired   = errors.New("verifier required")
+	errPrivateKeyRequired = errors.New("private key required")
+	errKeyUnchanged       = errors.New("key unchanged")
+	errE2EAttachments     = errors.New("attachments under the vault key")
+)
+
+// maxRekeyBytes bounds re-key uploads, which carry every row under the vault key.
+const maxRekeyBytes = 64 << 20
+
+// lockVault loads the user's vault row with a row lock. Re-keys take it FOR UPDATE;
+// writers of individual clips take it FOR SHARE so they cannot interleave with a re-key.
+func lockVault(tx *gorm.DB, userID, strength string) (*models.UserVault, error) {
+	var vault models.UserVault
+	if err := tx.Clauses(clause.Locking{Strength: strength}).
+		Where("user_id = ?", userID).First(&vault).Error; err != nil {
+		return nil, err
+	}
+	return &vault, nil
+}
+
+type RekeyClip struct {
+	ID                string         `json:"id" binding:"required"`
+	Revision          int            `json:"revision" binding:"required"` // Revision the client decrypted
+	EncryptedPayload  string         `json:"encryptedPayload" binding:"required"`
+	Nonce             string         `json:"nonce" binding:"required"`
+	EncryptedMetadata string         `json:"encryptedMetadata"` // Required when the clip has metadata
+	MetadataNonce     string         `json:"metadataNonce"`
+	Versions          []RekeyVersion `json:"versions" binding:"dive"` // Every stored history snapshot of the clip
+}
+
+// RekeyVersion is a history snapshot of a secure clip re-encrypted under the new key.
+type RekeyVersion struct {
+	ID                string `json:"id" binding:"required"`
+	EncryptedPayload  string `json:"encryptedPayload" binding:"required"`
+	Nonce             string `json:"nonce" binding:"required,max=32"`
+	EncryptedMetadata string `json:"encryptedMetadata"` // Required when the snapshot has metadata
+	MetadataNonce     string `json:"metadataNonce"`
+}
+
+// RekeyAttachmentKey is the content key of an encrypted attachment re-wrapped under the new key.
+type RekeyAttachmentKey struct {
+	ID         string `json:"id" binding:"required"`
+	WrappedKey string `json:"wrappedKey" binding:"required"`
+	KeyNonce   string `json:"keyNonce" binding:"required,max=32"`
+}
+
+type RekeyVaultRequest struct {
+	CurrentSalt           string               `json:"currentSalt" binding:"required"` // Salt the client derived the old key from
+	CurrentKeyFingerprint string               `json:"currentKeyFingerprint"`          // Proves the old key when the vault has a verifier
+	Salt                  string               `json:"salt" binding:"required"`
+	KDF                   *models.KDFParams    `json:"kdf"` // Optional; keeps the current descriptor when omitted
+	Clips                 []RekeyClip          `json:"clips" binding:"dive"`
+	E2EClips              []E2EMigrationItem   `json:"e2eClips" binding:"dive"`                              // Every end-to-end encrypted ordinary clip
+	E2EMessages           []E2EMigrationItem   `json:"e2eMessages" binding:"dive"`                           // Every end-to-end encrypted message
+	E2ENotifications      []E2EMigrationItem   `json:"e2eNotifications" binding:"dive"`                      // Every encrypted notification; no blind index
+	E2EOutbound           []E2EMigrationItem   `json:"e2eOutbound" binding:"dive"`                           // Every encrypted outbound message; no blind index
+	E2EAttachmentKeys     []RekeyAttachmentKey `json:"e2eAttachmentKeys" binding:"dive"`                     // Every encrypted attachment
+	KeyScheme             string               `json:"keyScheme" binding:"omitempty,oneof=password wrapped"` // Optional; switches the vault key scheme
+	Wrappers              []WrapperInput       `json:"wrappers" binding:"dive"`                              // New wrapper set; required for the wrapped scheme
+	EncryptedPrivateKey   string               `json:"encryptedPrivateKey"`                                  // Sharing private key under the new key; required if a key pair exists
+	PrivateKeyNonce       string               `json:"privateKeyNonce"`
+	VaultVerifierFields                        // Verifier under the new key; required if the vault had one
+}
+
+// e2eRekeyTarget is a table outside the secure vault holding ciphertext under the vault key.
+type e2eRekeyTarget struct {
+	model      interface{}
+	column     string // Ciphertext column, sealed at rest like any other write
+	blindIndex bool   // Whether the table keeps blind index tokens
+	items      []E2EMigrationItem
+}
+
+func (req *RekeyVaultRequest) e2eTargets() []e2eRekeyTarget {
+	return []e2eRekeyTarget{
+		{model: &models.Clip{}, column: "content", blindIndex: true, items: req.E2EClips},
+		{model: &models.SyncedMessage{}, column: "body", blindIndex: true, items: req.E2EMessages},
+		{model: &models.Notification{}, column: "text", items: req.E2ENotifications},
+		{model: &models.OutboundMessage{}, column: "body", items: req.E2EOutbound},
+	}
+}
+
+func (req *RekeyVaultRequest) e2eRowCount() int {
+	n := 0
+	for _, t := range req.e2eTargets() {
+		n += len(t.items)
+	}
+	return n
+}
+
+// rekeyMismatch collects the IDs that make an upload disagree with the stored vault.
+type rekeyMismatch struct {
+	missing, conflicts, unknown []string
+	legacyAttachments           []string // Attachments under the vault key itself, which block a new key
+}
+
+func (m *rekeyMismatch) any() bool {
+	return len(m.missing) > 0 || len(m.conflicts) > 0 || len(m.unknown) > 0
+}
+
+// RekeyVault replaces the vault salt (and optionally the KDF descriptor) together with every
+// secure clip re-encrypted under the new key, in one transaction. Used for master password
+// changes and KDF upgrades, and to migrate between the password and wrapped key schemes;
+// in the wrapped scheme the upload also carries the full set of wrappers of the new vault key.
+// Everything else under the vault key is replaced in the same upload: clip history, end-to-end
+// encrypted clips, messages, notifications and outbound messages, and the sharing private key.
+// A move to the wrapped scheme may keep the current vault key instead, shown by a verifier
+// with the current key fingerprint; nothing is re-encrypted then and no rows may be uploaded.
+// Encrypted attachments only have their content keys re-wrapped. Attachments encrypted directly
+// under the vault key predate content keys and would need their content re-uploaded, so a vault
+// holding any can only take the key-preserving path; later password changes then just replace
+// its wrappers.
+// The upload is rejected if any row is missing (including metadata blobs where present),
+// unknown, or a clip was modified since the client read it.
+func (h *SecureHandler) RekeyVault(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRekeyBytes)
+	var req RekeyVaultRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Re-key uploads are limited to %d bytes", maxRekeyBytes)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if salt, err := base64.StdEncoding.DecodeString(req.Salt); err != nil || len(salt) < 16 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "salt must be base64 of at least 16 bytes"})
+		return
+	}
+	if req.KDF != nil {
+		if err := req.KDF.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.VaultVerifierFields.isSet() {
+		if err := req.VaultVerifierFields.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	for _, t := range req.e2eTargets() {
+		for _, item := range t.items {
+			if !t.blindIndex && len(item.BlindIndex) > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "blindIndex is only valid for clips and messages"})
+				return
+			}
+			if err := validateBlindIndex(item.BlindIndex); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+
+	var wrappers []*models.VaultKeyWrapper
+	if req.KeyScheme == models.KeySchemeWrapped || len(req.Wrappers) > 0 {
+		var err error
+		if wrappers, err = buildWrappers(userIDStr, req.Wrappers, true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !req.VaultVerifierFields.isSet() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The wrapped scheme requires a verifier for the new vault key"})
+			return
+		}
+	}
+
+	var vault *models.UserVault
+	var mismatch rekeyMismatch
+	keepKey := false
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		vault, err = lockVault(tx, userIDStr, "UPDATE")
+		if err != nil {
+			return err
+		}
+		if vault.Salt != req.CurrentSalt {
+			return errVaultChanged
+		}
+		if !vault.MatchesFingerprint(req.CurrentKeyFingerprint) {
+			return errKeyMismatch
+		}
+		if vault.HasVerifier() && !req.VaultVerifierFields.isSet() {
+			return errVerifierRequired
+		}
+		if req.KDF != nil && !req.KDF.CanReplace(vault.KDF) {
+			return errKDFDowngrade
+		}
+
+		scheme := vault.KeyScheme
+		if req.KeyScheme != "" {
+			scheme = req.KeyScheme
+		}
+		if scheme == models.KeySchemeWrapped && wrappers == nil {
+			return errPasswordWrapper
+		}
+		if scheme == models.KeySchemePassword && wrappers != nil {
+			return errNotWrapped
+		}
+
+		// The password scheme derives the key from the salt, so only the wrapped scheme can keep it
+		keepKey = vault.HasVerifier() && req.VaultVerifierFields.KeyFingerprint == *vault.KeyFingerprint
+		if keepKey {
+			if scheme != models.KeySchemeWrapped || len(req.Clips) > 0 || req.e2eRowCount() > 0 || len(req.E2EAttachmentKeys) > 0 {
+				return errKeyUnchanged
+			}
+		} else if err := reencryptVault(tx, userIDStr, &req, &mismatch); err != nil {
+			return err
+		}
+
+		vault.Salt = req.Salt
+		if req.KDF != nil {
+			vault.KDF = *req.KDF
+		}
+		if req.VaultVerifierFields.isSet() {
+			req.VaultVerifierFields.applyTo(vault)
+		}
+		vault.KeyScheme = scheme
+
+		// A new vault key invalidates every existing wrapper; a kept key gets the uploaded set
+		if err := replaceWrappers(tx, userIDStr, wrappers); err != nil {
+			return err
+		}
+		return tx.Save(vault).Error
+	})
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vault not set up"})
+		case errVaultChanged:
+			c.JSON(http.StatusConflict, gin.H{"error": "Vault was re-keyed concurrently; reload and retry"})
+		case errKeyMismatch:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Key fingerprint does not match the current vault key"})
+		case errPrivateKeyRequired:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The sharing private key must be re-encrypted under the new key"})
+		case errVerifierRequired:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A verifier for the new key is required"})
+		case errPasswordWrapper:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The wrapped scheme requires exactly one password wrapper"})
+		case errNotWrapped:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Wrappers are only valid with the wrapped key scheme"})
+		case errKeyUnchanged:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The vault key can only be kept when moving to the wrapped scheme, and then no rows are re-encrypted"})
+		case errE2EAttachments:
+			// Moving to the wrapped scheme with the current key changes the password without re-encrypting
+			c.JSON(http.StatusConflict, gin.H{
+				"error":       "Attachments encrypted directly under the vault key cannot be re-encrypted; keep the current vault key and move to the wrapped scheme instead",
+				"keyScheme":   models.KeySchemeWrapped,
+				"keepKey":     true,
+				"attachments": mismatch.legacyAttachments,
+			})
+		case errKDFDowngrade:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "New KDF parameters are weaker than the current ones"})
+		case errRevisionConflict:
+			c.JSON(http.StatusConflict, gin.H{
+				"error":    "Uploaded rows do not match the vault",
+				"missing":  mismatch.missing,
+				"modified": mismatch.conflicts,
+				"unknown":  mismatch.unknown,
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to re-key vault"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"salt":           vault.Salt,
+		"kdf":            vault.KDF,
+		"keyScheme":      vault.KeyScheme,
+		"keptKey":        keepKey,
+		"reencrypted":    len(req.Clips),
+		"e2eRows":        req.e2eRowCount(),
+		"attachmentKeys": len(req.E2EAttachmentKeys),
+		"updatedAt":      vault.UpdatedAt,
+	})
+}
+
+// reencryptVault checks the upload against every row under the vault key and, if it
+// matches, writes the re-encrypted secure clips, their history, E2E rows and the sharing
+// private key. Mismatching IDs are collected and errRevisionConflict returned.
+func reencryptVault(tx *gorm.DB, userID string, req *RekeyVaultRequest, mismatch *rekeyMismatch) error {
+	var attachments []models.MessageAttachment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "wrapped_key").
+		Where("user_id = ? AND encrypted = true", userID).Find(&attachments).Error; err != nil {
+		return err
+	}
+	var legacy []string
+	for _, a := range attachments {
+		if a.WrappedKey == nil {
+			legacy = append(legacy, a.ID.String())
+		}
+	}
+	if len(legacy) > 0 {
+		mismatch.legacyAttachments = legacy
+		return errE2EAttachments
+	}
+
+	var clips []models.SecureClip
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userID).Find(&clips).Error; err != nil {
+		return err
+	}
+	var versions []models.SecureClipVersion
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userID).Find(&versions).Error; err != nil {
+		return err
+	}
+	versionsByClip := make(map[string][]models.SecureClipVersion)
+	for _, v := range versions {
+		id := v.SecureClipID.String()
+		versionsByClip[id] = append(versionsByClip[id], v)
+	}
+
+	uploaded := make(map[string]RekeyClip, len(req.Clips))
+	for _, rc := range req.Clips {
+		if _, dup := uploaded[rc.ID]; dup {
+			mismatch.unknown = append(mismatch.unknown, rc.ID)
+			continue
+		}
+		uploaded[rc.ID] = rc
+	}
+
+	stored := make(map[string]bool, len(clips))
+	for _, clip := range clips {
+		id := clip.ID.String()
+		stored[id] = true
+		rc, ok := uploaded[id]
+		if !ok {
+			mismatch.missing = append(mismatch.missing, id)
+			continue
+		} else if rc.Revision != clip.Revision {
+			mismatch.conflicts = append(mismatch.conflicts, id)
+			continue
+		} else if clip.HasMetadata() && (rc.EncryptedMetadata == "" || rc.MetadataNonce == "") {
+			mismatch.missing = append(mismatch.missing, id)
+		}
+		matchVersions(versionsByClip[id], rc.Versions, mismatch)
+	}
+	for id := range uploaded {
+		if !stored[id] {
+			mismatch.unknown = append(mismatch.unknown, id)
+		}
+	}
+
+	targets := req.e2eTargets()
+	for _, t := range targets {
+		if err := matchE2ERows(tx, t.model, userID, t.items, mismatch); err != nil {
+			return err
+		}
+	}
+	attachmentIDs := make([]string, len(attachments))
+	for i, a := range attachments {
+		attachmentIDs[i] = a.ID.String()
+	}
+	uploadedKeys := make([]string, len(req.E2EAttachmentKeys))
+	for i, k := range req.E2EAttachmentKeys {
+		uploadedKeys[i] = k.ID
+	}
+	matchIDs(attachmentIDs, uploadedKeys, mismatch)
+	if mismatch.any() {
+		return errRevisionConflict
+	}
+
+	for _, clip := range clips {
+		rc := uploaded[clip.ID.String()]
+		updates := map[string]interface{}{
+			"encrypted_payload": rc.EncryptedPayload,
+			"nonce":             rc.Nonce,
+			"revision":          clip.Revision + 1,
+		}
+		if clip.HasMetadata() {
+			updates["encrypted_metadata"] = rc.EncryptedMetadata
+			updates["metadata_nonce"] = rc.MetadataNonce
+		}
+		if err := tx.Model(&models.SecureClip{}).Where("id = ?", clip.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		for _, rv := range rc.Versions {
+			updates := map[string]interface{}{
+				"encrypted_payload": rv.EncryptedPayload,
+				"nonce":             rv.Nonce,
+			}
+			if rv.EncryptedMetadata != "" {
+				updates["encrypted_metadata"] = rv.EncryptedMetadata
+				updates["metadata_nonce"] = rv.MetadataNonce
+			}
+			if err := tx.Model(&models.SecureClipVersion{}).Where("id = ?", rv.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, t := range targets {
+		if err := writeE2ERows(tx, userID, t); err != nil {
+			return err
+		}
+	}
+	for _, k := range req.E2EAttachmentKeys {
+		if err := tx.Model(&models.MessageAttachment{}).Where("id = ? AND user_id = ?", k.ID, userID).
+			Updates(map[string]interface{}{"wrapped_key": k.WrappedKey, "key_nonce": k.KeyNonce}).Error; err != nil {
+			return err
+		}
+	}
+
+	// The sharing private key is encrypted under the vault key too
+	var keyPair models.UserKeyPair
+	if err := tx.Where("user_id = ?", userID).First(&keyPair).Error; err == nil {
+		if req.EncryptedPrivateKey == "" || req.PrivateKeyNonce == "" {
+			return errPrivateKeyRequired
+		}
+		keyPair.EncryptedPrivateKey = req.EncryptedPrivateKey
+		keyPair.PrivateKeyNonce = req.PrivateKeyNonce
+		return tx.Save(&keyPair).Error
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return nil
+}
+
+// matchVersions requires exactly the stored history of one clip, with metadata where the snapshot has it.
+func matchVersions(stored []models.SecureClipVersion, uploaded []RekeyVersion, mismatch *rekeyMismatch) {
+	byID := make(map[string]RekeyVersion, len(uploaded))
+	for _, rv := range uploaded {
+		if _, dup := byID[rv.ID]; dup {
+			mismatch.unknown = append(mismatch.unknown, rv.ID)
+			continue
+		}
+		byID[rv.ID] = rv
+	}
+	for _, v := range stored {
+		id := v.ID.String()
+		rv, ok := byID[id]
+		if !ok || (v.EncryptedMetadata != nil && (rv.EncryptedMetadata == "" || rv.MetadataNonce == "")) {
+			mismatch.missing = append(mismatch.missing, id)
+		}
+		delete(byID, id)
+	}
+	for id := range byID {
+		mismatch.unknown = append(mismatch.unknown, id)
+	}
+}
+
+// matchE2ERows requires exactly one uploaded item for every end-to-end encrypted row of model.
+func matchE2ERows(tx *gorm.DB, model interface{}, userID string, items []E2EMigrationItem, mismatch *rekeyMismatch) error {
+	var ids []string
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(model).
+		Where("user_id = ? AND encrypted = true", userID).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	uploaded := make([]string, len(items))
+	for i, item := range items {
+		uploaded[i] = item.ID
+	}
+	matchIDs(ids, uploaded, mismatch)
+	return nil
+}
+
+// matchIDs requires the uploaded IDs to be exactly the stored ones, each once.
+func matchIDs(stored, uploaded []string, mismatch *rekeyMismatch) {
+	seen := make(map[string]bool, len(uploaded))
+	for _, id := range uploaded {
+		if seen[id] {
+			mismatch.unknown = append(mismatch.unknown, id)
+			continue
+		}
+		seen[id] = true
+	}
+	for _, id := range stored {
+		if !seen[id] {
+			mismatch.missing = append(mismatch.missing, id)
+		}
+		delete(seen, id)
+	}
+	for id := range seen {
+		mismatch.unknown = append(mismatch.unknown, id)
+	}
+}
+
+// writeE2ERows replaces the ciphertext, nonce and blind index of end-to-end encrypted rows.
+func writeE2ERows(tx *gorm.DB, userID string, t e2eRekeyTarget) error {
+	for _, item := range t.items {
+		// Map updates bypass the at-rest serializer
+		sealed, err := atrest.Seal(userID, t.column, item.Ciphertext)
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{
+			t.column: sealed,
+			"nonce":  item.Nonce,
+		}
+		if t.blindIndex {
+			updates["blind_index"] = blindIndexString(item.BlindIndex)
+		}
+		if err := tx.Model(t.model).Where("id = ? AND user_id = ? AND encrypted = true", item.ID, userID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"clipsync/backend/internal/dbtest"
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestMatchIDs(t *testing.T) {
+	tests := []struct {
+		name        string
+		stored      []string
+		uploaded    []string
+		wantMissing []string
+		wantUnknown []string
+	}{
+		{"exact", []string{"a", "b"}, []string{"b", "a"}, nil, nil},
+		{"nothing stored", nil, nil, nil, nil},
+		{"missing", []string{"a", "b"}, []string{"a"}, []string{"b"}, nil},
+		{"unknown", []string{"a"}, []string{"a", "c"}, nil, []string{"c"}},
+		{"duplicate", []string{"a"}, []string{"a", "a"}, nil, []string{"a"}},
+		{"disjoint", []string{"a"}, []string{"b"}, []string{"a"}, []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m rekeyMismatch
+			matchIDs(tt.stored, tt.uploaded, &m)
+			if !reflect.DeepEqual(m.missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", m.missing, tt.wantMissing)
+			}
+			if !reflect.DeepEqual(m.unknown, tt.wantUnknown) {
+				t.Errorf("unknown = %v, want %v", m.unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestMatchVersions(t *testing.T) {
+	metadata := "meta"
+	v1, v2 := uuid.New(), uuid.New()
+	plain := []models.SecureClipVersion{{ID: v1}, {ID: v2}}
+	withMetadata := []models.SecureClipVersion{{ID: v1, EncryptedMetadata: &metadata}}
+	upload := func(id uuid.UUID, metadata string) RekeyVersion {
+		rv := RekeyVersion{ID: id.String(), EncryptedPayload: "p", Nonce: "n"}
+		if metadata != "" {
+			rv.EncryptedMetadata, rv.MetadataNonce = metadata, "mn"
+		}
+		return rv
+	}
+
+	tests := []struct {
+		name        string
+		stored      []models.SecureClipVersion
+		uploaded    []RekeyVersion
+		wantMissing []string
+		wantUnknown []string
+	}{
+		{"exact", plain, []RekeyVersion{upload(v2, ""), upload(v1, "")}, nil, nil},
+		{"missing snapshot", plain, []RekeyVersion{upload(v1, "")}, []string{v2.String()}, nil},
+		{"unknown snapshot", plain[:1], []RekeyVersion{upload(v1, ""), upload(v2, "")}, nil, []string{v2.String()}},
+		{"duplicate snapshot", plain[:1], []RekeyVersion{upload(v1, ""), upload(v1, "")}, nil, []string{v1.String()}},
+		{"metadata re-encrypted", withMetadata, []RekeyVersion{upload(v1, "new-meta")}, nil, nil},
+		{"metadata dropped", withMetadata, []RekeyVersion{upload(v1, "")}, []string{v1.String()}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m rekeyMismatch
+			matchVersions(tt.stored, tt.uploaded, &m)
+			if !reflect.DeepEqual(m.missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", m.missing, tt.wantMissing)
+			}
+			if !reflect.DeepEqual(m.unknown, tt.wantUnknown) {
+				t.Errorf("unknown = %v, want %v", m.unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestRekeyVault(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const newSalt = "AAAAAAAAAAAAAAAAAAAAAA==" // 16 bytes
+	attachmentID := uuid.New().String()
+
+	vault := func(iterations int, fingerprint interface{}) dbtest.Query {
+		return dbtest.Query{
+			Match:   `FROM "user_vaults"`,
+			Columns: []string{"id", "user_id", "salt", "kdf_version", "kdf_algorithm", "kdf_iterations", "key_scheme", "key_fingerprint"},
+			Rows:    [][]driver.Value{{uuid.New().String(), "user-1", "old-salt", int64(1), models.KDFPBKDF2SHA256, int64(iterations), models.KeySchemePassword, fingerprint}},
+		}
+	}
+	attachments := func(wrappedKey interface{}) dbtest.Query {
+		return dbtest.Query{
+			Match:   `FROM "message_attachments"`,
+			Columns: []string{"id", "wrapped_key"},
+			Rows:    [][]driver.Value{{attachmentID, wrappedKey}},
+		}
+	}
+	noRows := func(table string) dbtest.Query {
+		return dbtest.Query{Match: `FROM "` + table + `"`, Columns: []string{"id"}}
+	}
+	body := func(fields string) string {
+		return `{"currentSalt":"old-salt","salt":"` + newSalt + `"` + fields + `}`
+	}
+	const newVerifier = `,"verifier":"v","verifierNonce":"n","keyFingerprint":"fp-new"`
+	const passwordWrapper = `,"keyScheme":"wrapped","wrappers":[{"kind":"password","wrappedKey":"k","nonce":"n","salt":"s","kdf":{"version":1,"algorithm":"pbkdf2-sha256","iterations":100000}}]`
+
+	tests := []struct {
+		name       string
+		body       string
+		script     []dbtest.Query
+		wantStatus int
+		wantFields map[string]interface{} // Expected top-level response fields
+	}{
+		{
+			name:       "oversized upload",
+			body:       body(`,"clips":[{"id":"` + strings.Repeat("x", maxRekeyBytes) + `"}]`),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "short salt",
+			body:       `{"currentSalt":"old-salt","salt":"c2hvcnQ="}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wrapped scheme without verifier",
+			body:       body(passwordWrapper),
+			wantStatus: http.StatusBadRequest,
+			wantFields: map[string]interface{}{"error": "The wrapped scheme requires a verifier for the new vault key"},
+		},
+		{
+			name:       "vault changed",
+			body:       strings.Replace(body(""), "old-salt", "older-salt", 1),
+			script:     []dbtest.Query{vault(100000, nil)},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "wrong current key",
+			body:       body(`,"currentKeyFingerprint":"fp-other"` + newVerifier),
+			script:     []dbtest.Query{vault(100000, "fp-old")},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "verifier required",
+			body:       body(`,"currentKeyFingerprint":"fp-old"`),
+			script:     []dbtest.Query{vault(100000, "fp-old")},
+			wantStatus: http.StatusBadRequest,
+			wantFields: map[string]interface{}{"error": "A verifier for the new key is required"},
+		},
+		{
+			name:       "KDF downgrade",
+			body:       body(`,"kdf":{"version":1,"algorithm":"pbkdf2-sha256","iterations":100000}`),
+			script:     []dbtest.Query{vault(600000, nil)},
+			wantStatus: http.StatusBadRequest,
+			wantFields: map[string]interface{}{"error": "New KDF parameters are weaker than the current ones"},
+		},
+		{
+			name:       "kept key with rows",
+			body:       body(`,"currentKeyFingerprint":"fp-old","verifier":"v","verifierNonce":"n","keyFingerprint":"fp-old","clips":[{"id":"c","revision":1,"encryptedPayload":"p","nonce":"n"}]` + passwordWrapper),
+			script:     []dbtest.Query{vault(100000, "fp-old")},
+			wantStatus: http.StatusBadRequest,
+			wantFields: map[string]interface{}{"error": "The vault key can only be kept when moving to the wrapped scheme, and then no rows are re-encrypted"},
+		},
+		{
+			name:       "attachments under the vault key",
+			body:       body(newVerifier),
+			script:     []dbtest.Query{vault(100000, nil), attachments(nil)},
+			wantStatus: http.StatusConflict,
+			wantFields: map[string]interface{}{"keepKey": true, "keyScheme": "wrapped", "attachments": []interface{}{attachmentID}},
+		},
+		{
+			name:       "attachment key not re-wrapped",
+			body:       body(newVerifier),
+			script:     []dbtest.Query{vault(100000, nil), attachments("wrapped"), noRows("secure_clips"), noRows("secure_clip_versions"), noRows("clips"), noRows("synced_messages"), noRows("notifications"), noRows("outbound_messages")},
+			wantStatus: http.StatusConflict,
+			wantFields: map[string]interface{}{"missing": []interface{}{attachmentID}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := dbtest.New(t, tt.script...)
+			h := NewSecureHandler(db)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/vault/rekey", strings.NewReader(tt.body))
+			c.Set("userId", "user-1")
+
+			h.RekeyVault(c)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %.200s", w.Code, tt.wantStatus, w.Body)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range tt.wantFields {
+				if !reflect.DeepEqual(got[k], want) {
+					t.Errorf("%s = %v, want %v", k, got[k], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"clipsync/backend/internal/auth"
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	errStaleRecipientKey  = errors.New("recipient key changed")
+	errNoSenderKeyPair    = errors.New("sender has no key pair")
+	errSourceClipNotFound = errors.New("source clip not found")
+)
+
+func validX25519Key(b64 string) bool {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	return err == nil && len(raw) == 32
+}
+
+// GetKeyPair returns the caller's sharing key pair (private key still encrypted).
+func (h *SecureHandler) GetKeyPair(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var keyPair models.UserKeyPair
+	if err := h.db.Where("user_id = ?", userIDStr).First(&keyPair).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusOK, gin.H{"exists": false})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get key pair"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keyPair)
+}
+
+type SetKeyPairRequest struct {
+	PublicKey           string `json:"publicKey" binding:"required"`
+	EncryptedPrivateKey string `json:"encryptedPrivateKey" binding:"required"`
+	PrivateKeyNonce     string `json:"privateKeyNonce" binding:"required,max=32"`
+	KeyFingerprint      string `json:"keyFingerprint"` // Required once the vault has a verifier
+}
+
+// SetKeyPair registers or replaces the caller's X25519 key pair. Shares addressed to a
+// previous public key can no longer be decrypted and are removed.
+func (h *SecureHandler) SetKeyPair(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req SetKeyPairRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validX25519Key(req.PublicKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "publicKey must be a base64-encoded 32-byte X25519 key"})
+		return
+	}
+
+	var keyPair models.UserKeyPair
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		vault, err := lockVault(tx, userIDStr, "SHARE")
+		if err != nil {
+			return err
+		}
+		if !vault.MatchesFingerprint(req.KeyFingerprint) {
+			return errKeyMismatch
+		}
+
+		if err := tx.Where("user_id = ?", userIDStr).First(&keyPair).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		keyPair.UserID = userIDStr
+		keyPair.PublicKey = req.PublicKey
+		keyPair.EncryptedPrivateKey = req.EncryptedPrivateKey
+		keyPair.PrivateKeyNonce = req.PrivateKeyNonce
+		if err := tx.Save(&keyPair).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("recipient_id = ? AND recipient_public_key <> ?", userIDStr, req.PublicKey).
+			Delete(&models.SecureShare{}).Error
+	})
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Vault not set up"})
+		case errKeyMismatch:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Key fingerprint does not match the vault key"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save key pair"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, keyPair)
+}
+
+// LookupPublicKey finds another user's sharing public key by ?email= or ?userId=.
+func (h *SecureHandler) LookupPublicKey(c *gin.Context) {
+	recipientID := c.Query("userId")
+	if email := c.Query("email"); recipientID == "" && email != "" {
+		id, err := auth.FindUserIDByEmail(h.db, email)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		recipientID = id
+	}
+	if recipientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email or userId query param required"})
+		return
+	}
+
+	var keyPair models.UserKeyPair
+	if err := h.db.Where("user_id = ?", recipientID).First(&keyPair).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User has not set up sharing"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"userId":    keyPair.UserID,
+		"publicKey": keyPair.PublicKey,
+	})
+}
+
+type CreateShareRequest struct {
+	RecipientID        string  `json:"recipientId" binding:"required"`
+	RecipientPublicKey string  `json:"recipientPublicKey" binding:"required"` // Key the copy was encrypted to
+	SourceClipID       *string `json:"sourceClipId"`
+	ItemType           string  `json:"itemType" binding:"max=32"`
+	EncryptedPayload   string  `json:"encryptedPayload" binding:"required"`
+	Nonce              string  `json:"nonce" binding:"required,max=32"`
+	EncryptedMetadata  string  `json:"encryptedMetadata"`
+	MetadataNonce      string  `json:"metadataNonce" binding:"max=32"`
+}
+
+// CreateShare stores a copy of a secure clip encrypted to the recipient's public key.
+func (h *SecureHandler) CreateShare(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req CreateShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.RecipientID == userIDStr {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot share with yourself"})
+		return
+	}
+	if (req.EncryptedMetadata == "") != (req.MetadataNonce == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "encryptedMetadata and metadataNonce must be provided together"})
+		return
+	}
+
+	share := models.SecureShare{
+		SenderID:           userIDStr,
+		RecipientID:        req.RecipientID,
+		RecipientPublicKey: req.RecipientPublicKey,
+		ItemType:           req.ItemType,
+		EncryptedPayload:   req.EncryptedPayload,
+		Nonce:              req.Nonce,
+	}
+	if req.EncryptedMetadata != "" {
+		share.EncryptedMetadata = &req.EncryptedMetadata
+		share.MetadataNonce = &req.MetadataNonce
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var sender models.UserKeyPair
+		if err := tx.Where("user_id = ?", userIDStr).First(&sender).Error; err != nil {
+			return errNoSenderKeyPair
+		}
+		share.SenderPublicKey = sender.PublicKey
+
+		var recipient models.UserKeyPair
+		if err := tx.Where("user_id = ?", req.RecipientID).First(&recipient).Error; err != nil {
+			return gorm.ErrRecordNotFound
+		}
+		if recipient.PublicKey != req.RecipientPublicKey {
+			return errStaleRecipientKey
+		}
+
+		if req.SourceClipID != nil {
+			var source models.SecureClip
+			if err := tx.Select("id").Where("id = ? AND user_id = ?", *req.SourceClipID, userIDStr).First(&source).Error; err != nil {
+				return errSourceClipNotFound
+			}
+			share.SourceClipID = &source.ID
+		}
+
+		return tx.Create(&share).Error
+	})
+	if err != nil {
+		switch err {
+		case errNoSenderKeyPair:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Set up your sharing key pair first"})
+		case errSourceClipNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Source clip not found"})
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Recipient has not set up sharing"})
+		case errStaleRecipientKey:
+			c.JSON(http.StatusConflict, gin.H{"error": "Recipient's public key changed; fetch it again and re-encrypt"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":        share.ID,
+		"createdAt": share.CreatedAt,
+	})
+}
+
+// ListInbox returns items other users shared with the caller.
+func (h *SecureHandler) ListInbox(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var shares []models.SecureShare
+	if err := h.db.Where("recipient_id = ?", userIDStr).Order("created_at DESC").Find(&shares).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shared items"})
+		return
+	}
+
+	c.JSON(http.StatusOK, shares)
+}
+
+// ListSent returns the caller's outgoing shares without their ciphertext.
+func (h *SecureHandler) ListSent(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var shares []models.SecureShare
+	if err := h.db.Select("id, sender_id, recipient_id, source_clip_id, sender_public_key, recipient_public_key, item_type, created_at").
+		Where("sender_id = ?", userIDStr).Order("created_at DESC").Find(&shares).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shared items"})
+		return
+	}
+
+	c.JSON(http.StatusOK, shares)
+}
+
+// DeleteShare lets the sender revoke a share or the recipient dismiss it from the inbox.
+func (h *SecureHandler) DeleteShare(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share id"})
+		return
+	}
+
+	result := h.db.Where("id = ? AND (sender_id = ? OR recipient_id = ?)", shareID, userIDStr, userIDStr).
+		Delete(&models.SecureShare{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share revoked"})
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxRecoveryCodes caps the number of unused recovery wrappers per vault.
+const maxRecoveryCodes = 16
+
+var (
+	errNotWrapped          = errors.New("vault does not use wrapped keys")
+	errPasswordWrapper     = errors.New("password wrapper required")
+	errTooManyRecoveryKeys = errors.New("too many recovery codes")
+)
+
+// WrapperInput is a vault key wrapper uploaded by a client.
+type WrapperInput struct {
+	Kind       string            `json:"kind" binding:"required,oneof=password recovery device"`
+	Label      string            `json:"label" binding:"max=100"`
+	LookupID   string            `json:"lookupId" binding:"max=88"` // Required for recovery codes
+	WrappedKey string            `json:"wrappedKey" binding:"required"`
+	Nonce      string            `json:"nonce" binding:"required,max=32"`
+	Salt       string            `json:"salt" binding:"max=88"`
+	KDF        *models.KDFParams `json:"kdf"`
+}
+
+func (in WrapperInput) toModel(userID string) (*models.VaultKeyWrapper, error) {
+	w := &models.VaultKeyWrapper{
+		UserID:     userID,
+		Kind:       in.Kind,
+		Label:      in.Label,
+		WrappedKey: in.WrappedKey,
+		Nonce:      in.Nonce,
+	}
+	if w.UsesKDF() {
+		if in.Salt == "" || in.KDF == nil {
+			return nil, errors.New(in.Kind + " wrappers require salt and kdf")
+		}
+		if err := in.KDF.Validate(); err != nil {
+			return nil, err
+		}
+		salt, kdf := in.Salt, *in.KDF
+		w.Salt, w.KDF = &salt, &kdf
+	}
+	if in.Kind == models.WrapperRecovery {
+		if in.LookupID == "" {
+			return nil, errors.New("recovery wrappers require lookupId")
+		}
+		lookupID := in.LookupID
+		w.LookupID = &lookupID
+	}
+	return w, nil
+}
+
+// buildWrappers converts inputs, requiring exactly one password wrapper when requirePassword is set.
+func buildWrappers(userID string, inputs []WrapperInput, requirePassword bool) ([]*models.VaultKeyWrapper, error) {
+	wrappers := make([]*models.VaultKeyWrapper, 0, len(inputs))
+	passwords := 0
+	for _, in := range inputs {
+		w, err := in.toModel(userID)
+		if err != nil {
+			return nil, err
+		}
+		if w.Kind == models.WrapperPassword {
+			passwords++
+		}
+		wrappers = append(wrappers, w)
+	}
+	if passwords > 1 || (requirePassword && passwords != 1) {
+		return nil, errPasswordWrapper
+	}
+	return wrappers, nil
+}
+
+// replaceWrappers drops every wrapper of the vault and stores the given set (used by re-key,
+// since a new vault key invalidates all existing wrappers).
+func replaceWrappers(tx *gorm.DB, userID string, wrappers []*models.VaultKeyWrapper) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.VaultKeyWrapper{}).Error; err != nil {
+		return err
+	}
+	for _, w := range wrappers {
+		if err := tx.Create(w).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListWrappers returns the vault's key wrappers. Recovery wrappers are listed without their
+// ciphertext, which is only released once through ConsumeRecoveryCode.
+func (h *SecureHandler) ListWrappers(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var wrappers []models.VaultKeyWrapper
+	if err := h.db.Where("user_id = ?", userIDStr).Order("created_at ASC").Find(&wrappers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wrappers"})
+		return
+	}
+
+	for i := range wrappers {
+		if wrappers[i].Kind == models.WrapperRecovery {
+			wrappers[i].WrappedKey = ""
+			wrappers[i].Nonce = ""
+			wrappers[i].Salt = nil
+			wrappers[i].KDF = nil
+		}
+	}
+
+	c.JSON(http.StatusOK, wrappers)
+}
+
+type AddWrappersRequest struct {
+	KeyFingerprint string         `json:"keyFingerprint" binding:"required"` // Proves possession of the vault key
+	Wrappers       []WrapperInput `json:"wrappers" binding:"required,min=1,dive"`
+}
+
+// AddWrappers stores additional wrappers of the current vault key. A password wrapper
+// replaces the existing one, which is how the master password changes in the wrapped
+// scheme without re-encrypting any clip.
+func (h *SecureHandler) AddWrappers(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req AddWrappersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	wrappers, err := buildWrappers(userIDStr, req.Wrappers, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		vault, err := lockVault(tx, userIDStr, "UPDATE")
+		if err != nil {
+			return err
+		}
+		if vault.KeyScheme != models.KeySchemeWrapped {
+			return errNotWrapped
+		}
+		if !vault.MatchesFingerprint(req.KeyFingerprint) {
+			return errKeyMismatch
+		}
+
+		recovery := 0
+		for _, w := range wrappers {
+			switch w.Kind {
+			case models.WrapperPassword:
+				if err := tx.Where("user_id = ? AND kind = ?", userIDStr, models.WrapperPassword).
+					Delete(&models.VaultKeyWrapper{}).Error; err != nil {
+					return err
+				}
+				vault.Salt = *w.Salt
+				vault.KDF = *w.KDF
+				if err := tx.Save(vault).Error; err != nil {
+					return err
+				}
+			case models.WrapperRecovery:
+				recovery++
+			}
+		}
+		if recovery > 0 {
+			var active int64
+			tx.Model(&models.VaultKeyWrapper{}).
+				Where("user_id = ? AND kind = ? AND consumed_at IS NULL", userIDStr, models.WrapperRecovery).
+				Count(&active)
+			if int(active)+recovery > maxRecoveryCodes {
+				return errTooManyRecoveryKeys
+			}
+		}
+
+		for _, w := range wrappers {
+			if err := tx.Create(w).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.respondWrapperError(c, err, "Failed to add wrappers")
+		return
+	}
+
+	ids := make([]string, 0, len(wrappers))
+	for _, w := range wrappers {
+		ids = append(ids, w.ID.String())
+	}
+	c.JSON(http.StatusCreated, gin.H{"ids": ids})
+}
+
+// DeleteWrapper removes a recovery or device wrapper. The password wrapper can only be replaced.
+func (h *SecureHandler) DeleteWrapper(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+	wrapperID := c.Param("id")
+
+	var req struct {
+		KeyFingerprint string `json:"keyFingerprint" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		vault, err := lockVault(tx, userIDStr, "UPDATE")
+		if err != nil {
+			return err
+		}
+		if !vault.MatchesFingerprint(req.KeyFingerprint) {
+			return errKeyMismatch
+		}
+
+		var wrapper models.VaultKeyWrapper
+		if err := tx.Where("id = ? AND user_id = ?", wrapperID, userIDStr).First(&wrapper).Error; err != nil {
+			return err
+		}
+		if wrapper.Kind == models.WrapperPassword {
+			return errPasswordWrapper
+		}
+		return tx.Delete(&wrapper).Error
+	})
+	if err != nil {
+		if err == errPasswordWrapper {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The password wrapper cannot be removed; replace it instead"})
+			return
+		}
+		h.respondWrapperError(c, err, "Failed to delete wrapper")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+}
+
+// ConsumeRecoveryCode releases the wrapped vault key for a recovery code exactly once. The
+// client unwraps the vault key with the code, then sets a new password wrapper via AddWrappers.
+func (h *SecureHandler) ConsumeRecoveryCode(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req struct {
+		LookupID string `json:"lookupId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var wrapper models.VaultKeyWrapper
+	if err := h.db.Where("user_id = ? AND kind = ? AND lookup_id = ?", userIDStr, models.WrapperRecovery, req.LookupID).
+		First(&wrapper).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recovery code not found"})
+		return
+	}
+
+	// The consumed_at IS NULL guard makes concurrent redemptions single-use
+	result := h.db.Model(&models.VaultKeyWrapper{}).
+		Where("id = ? AND consumed_at IS NULL", wrapper.ID).
+		Update("consumed_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to consume recovery code"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "Recovery code already used"})
+		return
+	}
+
+	c.JSON(http.StatusOK, wrapper)
+}
+
+func (h *SecureHandler) respondWrapperError(c *gin.Context, err error, fallback string) {
+	switch err {
+	case gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	case errNotWrapped:
+		c.JSON(http.StatusConflict, gin.H{"error": "Vault does not use wrapped keys; migrate it with a re-key first"})
+	case errKeyMismatch:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Key fingerprint does not match the vault key"})
+	case errTooManyRecoveryKeys:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many unused recovery codes"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
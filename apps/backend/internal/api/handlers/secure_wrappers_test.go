@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clipsync/backend/internal/dbtest"
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestConsumeRecoveryCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	wrapperID := uuid.New()
+	wrapperRow := dbtest.Query{
+		Match:   `FROM "vault_key_wrappers"`,
+		Columns: []string{"id", "user_id", "kind", "lookup_id", "wrapped_key", "nonce"},
+		Rows:    [][]driver.Value{{wrapperID.String(), "user-1", models.WrapperRecovery, "lookup-1", "wrapped", "nonce"}},
+	}
+	noWrapper := dbtest.Query{Match: `FROM "vault_key_wrappers"`, Columns: wrapperRow.Columns}
+	consume := func(affected int64) dbtest.Query {
+		return dbtest.Query{Match: `UPDATE "vault_key_wrappers" SET "consumed_at"`, Affected: affected}
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		script      []dbtest.Query
+		wantStatus  int
+		wantWrapped bool // Whether the wrapped vault key is released
+	}{
+		{"first use", `{"lookupId":"lookup-1"}`, []dbtest.Query{wrapperRow, consume(1)}, http.StatusOK, true},
+		{"already used", `{"lookupId":"lookup-1"}`, []dbtest.Query{wrapperRow, consume(0)}, http.StatusGone, false},
+		{"unknown code", `{"lookupId":"lookup-2"}`, []dbtest.Query{noWrapper}, http.StatusNotFound, false},
+		{"missing lookup ID", `{}`, nil, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := dbtest.New(t, tt.script...)
+			h := NewSecureHandler(db)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/vault/recover", strings.NewReader(tt.body))
+			c.Set("userId", "user-1")
+
+			h.ConsumeRecoveryCode(c)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var got models.VaultKeyWrapper
+			json.Unmarshal(w.Body.Bytes(), &got)
+			if released := got.WrappedKey != ""; released != tt.wantWrapped {
+				t.Errorf("wrapped key released = %v, want %v", released, tt.wantWrapped)
+			}
+		})
+	}
+}
+
+func TestBuildWrappers(t *testing.T) {
+	kdf := models.DefaultKDFParams()
+	weak := models.KDFParams{Version: models.KDFDescriptorVersion, Algorithm: models.KDFPBKDF2SHA256, Iterations: 1000}
+	password := WrapperInput{Kind: models.WrapperPassword, WrappedKey: "k", Nonce: "n", Salt: "s", KDF: &kdf}
+	recovery := WrapperInput{Kind: models.WrapperRecovery, LookupID: "lookup-1", WrappedKey: "k", Nonce: "n", Salt: "s", KDF: &kdf}
+	device := WrapperInput{Kind: models.WrapperDevice, Label: "Laptop", WrappedKey: "k", Nonce: "n"}
+
+	with := func(in WrapperInput, edit func(*WrapperInput)) WrapperInput {
+		edit(&in)
+		return in
+	}
+
+	tests := []struct {
+		name            string
+		inputs          []WrapperInput
+		requirePassword bool
+		wantErr         bool
+	}{
+		{"password with recovery and device", []WrapperInput{password, recovery, device}, true, false},
+		{"recovery without password", []WrapperInput{recovery}, false, false},
+		{"password required", []WrapperInput{recovery, device}, true, true},
+		{"two passwords", []WrapperInput{password, password}, false, true},
+		{"recovery without lookup ID", []WrapperInput{with(recovery, func(in *WrapperInput) { in.LookupID = "" })}, false, true},
+		{"recovery without salt", []WrapperInput{with(recovery, func(in *WrapperInput) { in.Salt = "" })}, false, true},
+		{"recovery without KDF", []WrapperInput{with(recovery, func(in *WrapperInput) { in.KDF = nil })}, false, true},
+		{"recovery with weak KDF", []WrapperInput{with(recovery, func(in *WrapperInput) { in.KDF = &weak })}, false, true},
+		{"device without KDF", []WrapperInput{device}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrappers, err := buildWrappers("user-1", tt.inputs, tt.requirePassword)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildWrappers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, w := range wrappers {
+				if w.Kind == models.WrapperRecovery && (w.LookupID == nil || *w.LookupID == "") {
+					t.Errorf("recovery wrapper stored without lookup ID")
+				}
+			}
+		})
+	}
+}
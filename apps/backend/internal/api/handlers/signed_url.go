@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"time"
+
+	"clipsync/backend/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signedURL returns an absolute URL for path that authenticates by its own signature until
+// expiresAt, for downloads where no Authorization header can be sent, such as <img src>.
+func signedURL(path string, expiresAt time.Time) string {
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	return config.Get().PublicURL + path + "?exp=" + exp + "&sig=" + urlSignature(path, exp)
+}
+
+func urlSignature(path, exp string) string {
+	mac := hmac.New(sha256.New, []byte(config.Get().AttachmentURLSecret))
+	mac.Write([]byte(path + "?exp=" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedURLExpiry returns the default expiry of new signed URLs.
+func signedURLExpiry() time.Time {
+	return time.Now().Add(config.Get().AttachmentURLTTL).Truncate(time.Second)
+}
+
+// verifySignedURL checks the signature and expiry of the request URL, responding 403 when
+// they are invalid. It returns the expiry for caching headers.
+func verifySignedURL(c *gin.Context) (time.Time, bool) {
+	exp := c.Query("exp")
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || !hmac.Equal([]byte(c.Query("sig")), []byte(urlSignature(c.Request.URL.Path, exp))) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid download signature"})
+		return time.Time{}, false
+	}
+	expiresAt := time.Unix(expUnix, 0)
+	if time.Now().After(expiresAt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Download link expired"})
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+// privateCacheUntil lets browsers cache a signed download until its URL expires.
+func privateCacheUntil(c *gin.Context, expiresAt time.Time) {
+	c.Header("Cache-Control", "private, max-age="+strconv.FormatInt(max(0, int64(time.Until(expiresAt).Seconds())), 10))
+}
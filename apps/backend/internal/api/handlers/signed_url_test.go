@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"clipsync/backend/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifySignedURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("PUBLIC_URL", "https://sync.example.com")
+	t.Setenv("ATTACHMENT_URL_SECRET", "url-secret")
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	const path = "/api/attachments/0b5e9c1e-6f3d-4a51-9d2c-2f0a8f7f1a10/original"
+	relative := func(url string) string { return strings.TrimPrefix(url, "https://sync.example.com") }
+	valid := relative(signedURL(path, time.Now().Add(time.Minute)))
+	expired := relative(signedURL(path, time.Now().Add(-time.Second)))
+	sig := valid[strings.Index(valid, "&sig=")+len("&sig="):]
+
+	tests := []struct {
+		name   string
+		url    string
+		wantOK bool
+	}{
+		{"valid", valid, true},
+		{"expired", expired, false},
+		{"other attachment", strings.Replace(valid, "0b5e9c1e", "1b5e9c1e", 1), false},
+		{"other variant", strings.Replace(valid, "/original", "/thumbnail", 1), false},
+		{"extended expiry", strings.Replace(valid, "?exp=", "?exp=9", 1), false},
+		{"tampered signature", strings.Replace(valid, "&sig="+sig, "&sig=x"+sig[1:], 1), false},
+		{"missing signature", valid[:strings.Index(valid, "&sig=")], false},
+		{"missing expiry", path + "?sig=" + sig, false},
+		{"expiry not a number", strings.Replace(valid, "?exp=", "?exp=x", 1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, tt.url, nil)
+
+			expiresAt, ok := verifySignedURL(c)
+			if ok != tt.wantOK {
+				t.Fatalf("verifySignedURL() = %v, want %v (%d %s)", ok, tt.wantOK, w.Code, w.Body)
+			}
+			if !ok && w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			if ok && time.Until(expiresAt) <= 0 {
+				t.Errorf("expiry %v is not in the future", expiresAt)
+			}
+		})
+	}
+}
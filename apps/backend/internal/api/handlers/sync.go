@@ -6,7 +6,6 @@ import (
 
 	"clipsync/backend/internal/models"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -29,7 +28,7 @@ func (h *SyncHandler) GetStatus(c *gin.Context) {
 	}
 
 	var totalClips int64
-	h.db.Model(&models.Clip{}).Where("user_id = ?", userIDStr).Count(&totalClips)
+	h.db.Model(&models.Clip{}).Scopes(unexpiredClips).Where("user_id = ?", userIDStr).Count(&totalClips)
 
 	var unsyncedClips int64
 	h.db.Model(&models.Clip{}).Where("user_id = ? AND synced = ?", userIDStr, false).Count(&unsyncedClips)
@@ -57,7 +56,7 @@ func (h *SyncHandler) Pull(c *gin.Context) {
 	}
 
 	var clips []models.Clip
-	query := h.db.Where("user_id = ?", userIDStr)
+	query := h.db.Scopes(unexpiredClips).Where("user_id = ?", userIDStr)
 	
 	if !req.LastSync.IsZero() {
 		query = query.Where("created_at > ? OR updated_at > ?", req.LastSync, req.LastSync)
@@ -89,11 +88,9 @@ func (h *SyncHandler) Push(c *gin.Context) {
 	userIDStr := userID.(string)
 
 	var req struct {
-		Clips    []struct {
-			Content    string   `json:"content" binding:"required"`
-			DeviceName string   `json:"deviceName"`
-			Tags       []string `json:"tags"`
-			CopiedAt   time.Time `json:"copiedAt"`
+		Clips []struct {
+			CreateClipRequest
+			CopiedAt time.Time `json:"copiedAt"`
 		} `json:"clips" binding:"required"`
 		DeviceID string `json:"deviceId" binding:"required"`
 	}
@@ -103,26 +100,25 @@ func (h *SyncHandler) Push(c *gin.Context) {
 		return
 	}
 
-	var synced int
+	policy, err := loadE2EPolicy(h.db, userIDStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync clips"})
+		return
+	}
 	for _, clipReq := range req.Clips {
-		preview := clipReq.Content
-		if len(preview) > 200 {
-			preview = preview[:200]
+		if err := clipReq.E2EContent.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-
-		clip := models.Clip{
-			ID:            uuid.New(),
-			UserID:        userIDStr,
-			Content:       clipReq.Content,
-			ContentPreview: preview,
-			CopiedAt:      clipReq.CopiedAt,
-			IsFavorite:    false,
-			Tags:          clipReq.Tags,
-			DeviceName:    &clipReq.DeviceName,
-			Synced:        true,
-			CreatedAt:     time.Now(),
-			UpdatedAt:     time.Now(),
+		if err := policy.check(clipReq.E2EContent); err != nil {
+			respondE2EError(c, err)
+			return
 		}
+	}
+
+	var synced int
+	for _, clipReq := range req.Clips {
+		clip := newClip(userIDStr, clipReq.CreateClipRequest, clipReq.CopiedAt)
 
 		if err := h.db.Create(&clip).Error; err == nil {
 			synced++
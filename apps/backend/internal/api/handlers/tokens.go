@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"clipsync/backend/internal/auth"
+	"clipsync/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TokensHandler struct {
+	db *gorm.DB
+}
+
+func NewTokensHandler(db *gorm.DB) *TokensHandler {
+	return &TokensHandler{db: db}
+}
+
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expiresAt"` // Optional; nil never expires
+}
+
+type apiTokenResponse struct {
+	models.APIToken
+	Scopes []string `json:"scopes"`
+}
+
+func newAPITokenResponse(t models.APIToken) apiTokenResponse {
+	return apiTokenResponse{APIToken: t, Scopes: t.ScopeList()}
+}
+
+// List returns the user's API tokens (never the token values).
+func (h *TokensHandler) List(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var tokens []models.APIToken
+	if err := h.db.Where("user_id = ?", userIDStr).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+		return
+	}
+
+	resp := make([]apiTokenResponse, 0, len(tokens))
+	for _, t := range tokens {
+		resp = append(resp, newAPITokenResponse(t))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Create issues a new API token. The plaintext token is returned only once.
+func (h *TokensHandler) Create(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !models.IsValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope, "validScopes": models.ValidScopes})
+			return
+		}
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresAt must be in the future"})
+		return
+	}
+
+	token, record, err := auth.NewAPIToken(userIDStr, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	if err := h.db.Create(record).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":    token,
+		"apiToken": newAPITokenResponse(*record),
+	})
+}
+
+// Revoke deletes an API token.
+func (h *TokensHandler) Revoke(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	userIDStr := userID.(string)
+	tokenID := c.Param("id")
+
+	result := h.db.Where("id = ? AND user_id = ?", tokenID, userIDStr).Delete(&models.APIToken{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
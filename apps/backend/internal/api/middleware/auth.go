@@ -4,12 +4,13 @@ import (
 	"net/http"
 	"strings"
 
-	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/auth"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware validates the bearer token with the configured authenticator and
+// stores the caller's user ID (and, when known, device family and profile) in the context.
+func AuthMiddleware(authenticator auth.Authenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -25,33 +26,23 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := parts[1]
-
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(config.Get().JWTSecret), nil
-		})
-
-		if err != nil || !token.Valid {
+		identity, err := authenticator.Authenticate(parts[1])
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
-			return
+		c.Set("userId", identity.UserID)
+		if identity.FamilyID != "" {
+			c.Set("familyId", identity.FamilyID)
 		}
-
-		userID, ok := claims["userId"].(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
-			c.Abort()
-			return
+		if identity.User != nil {
+			c.Set("user", identity.User)
+		}
+		if identity.Scopes != nil {
+			c.Set("scopes", identity.Scopes)
 		}
-
-		c.Set("userId", userID)
 		c.Next()
 	}
 }
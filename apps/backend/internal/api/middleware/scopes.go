@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScope restricts scoped API tokens to routes their scopes grant. Every route
+// names its scope explicitly, since some reads (e.g. POST /sync/pull) are not GETs.
+// Full-access credentials (device tokens, Better-Auth sessions) carry no scopes and
+// always pass.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if scopes, scoped := c.Get("scopes"); scoped && !hasScope(scopes.([]string), scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API token lacks required scope: " + scope})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// DenyScope rejects API tokens carrying a restricting scope, e.g. secure:none on the vault routes.
+func DenyScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if scopes, scoped := c.Get("scopes"); scoped && hasScope(scopes.([]string), scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API token is restricted by scope: " + scope})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireFullAccess rejects scoped API tokens, e.g. for the secure vault and token management.
+func RequireFullAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, scoped := c.Get("scopes"); scoped {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API tokens cannot access this endpoint"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
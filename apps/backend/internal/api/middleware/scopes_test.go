@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"clipsync/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestScopeMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		scopes     []string // nil for a full-access credential
+		middleware gin.HandlerFunc
+		wantStatus int
+	}{
+		{"full access passes required scope", nil, RequireScope(models.ScopeMessagesRead), http.StatusOK},
+		{"granted scope", []string{models.ScopeMessagesRead}, RequireScope(models.ScopeMessagesRead), http.StatusOK},
+		{"one of several scopes", []string{models.ScopeClipsRead, models.ScopeMessagesWrite}, RequireScope(models.ScopeMessagesWrite), http.StatusOK},
+		{"read does not grant write", []string{models.ScopeMessagesRead}, RequireScope(models.ScopeMessagesWrite), http.StatusForbidden},
+		{"write does not grant read", []string{models.ScopeMessagesWrite}, RequireScope(models.ScopeMessagesRead), http.StatusForbidden},
+		{"other resource", []string{models.ScopeClipsRead}, RequireScope(models.ScopeMessagesRead), http.StatusForbidden},
+		{"no scopes", []string{}, RequireScope(models.ScopeClipsRead), http.StatusForbidden},
+		{"full access not denied", nil, DenyScope(models.ScopeSecureNone), http.StatusOK},
+		{"without restricting scope", []string{models.ScopeClipsRead}, DenyScope(models.ScopeSecureNone), http.StatusOK},
+		{"with restricting scope", []string{models.ScopeClipsRead, models.ScopeSecureNone}, DenyScope(models.ScopeSecureNone), http.StatusForbidden},
+		{"full access required and given", nil, RequireFullAccess(), http.StatusOK},
+		{"full access required, scoped token", []string{models.ScopeClipsRead}, RequireFullAccess(), http.StatusForbidden},
+		{"full access required, empty scopes", []string{}, RequireFullAccess(), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(func(c *gin.Context) {
+				if tt.scopes != nil {
+					c.Set("scopes", tt.scopes)
+				}
+			})
+			r.GET("/", tt.middleware, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -3,6 +3,10 @@ package api
 import (
 	"clipsync/backend/internal/api/handlers"
 	"clipsync/backend/internal/api/middleware"
+	"clipsync/backend/internal/auth"
+	"clipsync/backend/internal/blob"
+	"clipsync/backend/internal/events"
+	"clipsync/backend/internal/models"
 
 	"gorm.io/gorm"
 
@@ -10,7 +14,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func InitializeRouter(db *gorm.DB) *gin.Engine {
+func InitializeRouter(db *gorm.DB, blobs blob.Store) *gin.Engine {
 	router := gin.Default()
 
 	// Allow all origins (for dev / flexible clients; tighten in production if needed)
@@ -25,12 +29,19 @@ func InitializeRouter(db *gorm.DB) *gin.Engine {
 		MaxAge:           12 * 3600, // 12 hours
 	}))
 
+	requireAuth := middleware.AuthMiddleware(auth.NewFromConfig(db))
+	hub := events.NewHub()
+
 	authHandler := handlers.NewAuthHandler(db)
 	clipHandler := handlers.NewClipHandler(db)
 	syncHandler := handlers.NewSyncHandler(db)
 	pairingHandler := handlers.NewPairingHandler(db)
 	secureHandler := handlers.NewSecureHandler(db)
-	messagesHandler := handlers.NewMessagesHandler(db)
+	messagesHandler := handlers.NewMessagesHandler(db, hub, blobs)
+	eventsHandler := handlers.NewEventsHandler(hub)
+	notificationsHandler := handlers.NewNotificationsHandler(db, hub)
+	contactsHandler := handlers.NewContactsHandler(db, hub, blobs)
+	tokensHandler := handlers.NewTokensHandler(db)
 
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
@@ -39,60 +50,144 @@ func InitializeRouter(db *gorm.DB) *gin.Engine {
 	// API routes
 	api := router.Group("/api")
 	{
-		auth := api.Group("/auth")
+		authGroup := api.Group("/auth")
 		{
-			auth.POST("/signup", authHandler.Signup)
-			auth.POST("/login", authHandler.Login)
-			auth.POST("/logout", middleware.AuthMiddleware(), authHandler.Logout)
-			auth.GET("/me", middleware.AuthMiddleware(), authHandler.Me)
+			authGroup.POST("/signup", authHandler.Signup)
+			authGroup.POST("/login", authHandler.Login)
+			authGroup.POST("/refresh", authHandler.Refresh)
+			authGroup.POST("/logout", requireAuth, authHandler.Logout)
+			authGroup.GET("/me", requireAuth, authHandler.Me)
 		}
 
 		pairing := api.Group("/pairing")
 		{
-			pairing.GET("/code", middleware.AuthMiddleware(), pairingHandler.GeneratePairingCode)
+			pairing.GET("/code", requireAuth, middleware.RequireFullAccess(), pairingHandler.GeneratePairingCode)
 			pairing.POST("/verify", pairingHandler.VerifyPairingCode)
 		}
 
 		clips := api.Group("/clips")
-		clips.Use(middleware.AuthMiddleware())
+		clips.Use(requireAuth)
 		{
-			clips.GET("", clipHandler.GetClips)
-			clips.POST("", clipHandler.CreateClip)
-			clips.DELETE("/all", clipHandler.DeleteAll)
-			clips.GET("/:id", clipHandler.GetClip)
-			clips.DELETE("/:id", clipHandler.DeleteClip)
-			clips.PUT("/:id/favorite", clipHandler.ToggleFavorite)
-			clips.PUT("/:id/pin", clipHandler.TogglePin)
-			clips.POST("/sync", clipHandler.SyncClips)
+			read, write := middleware.RequireScope(models.ScopeClipsRead), middleware.RequireScope(models.ScopeClipsWrite)
+			clips.GET("", read, clipHandler.GetClips)
+			clips.POST("", write, clipHandler.CreateClip)
+			clips.DELETE("/all", write, clipHandler.DeleteAll)
+			clips.GET("/:id", read, clipHandler.GetClip)
+			clips.DELETE("/:id", write, clipHandler.DeleteClip)
+			clips.PUT("/:id/favorite", write, clipHandler.ToggleFavorite)
+			clips.PUT("/:id/pin", write, clipHandler.TogglePin)
+			clips.POST("/sync", write, clipHandler.SyncClips)
+			clips.POST("/encrypt", write, clipHandler.EncryptClips)
 		}
 
 		sync := api.Group("/sync")
-		sync.Use(middleware.AuthMiddleware())
+		sync.Use(requireAuth)
 		{
-			sync.GET("/status", syncHandler.GetStatus)
-			sync.POST("/pull", syncHandler.Pull)
-			sync.POST("/push", syncHandler.Push)
+			read, write := middleware.RequireScope(models.ScopeClipsRead), middleware.RequireScope(models.ScopeClipsWrite)
+			sync.GET("/status", read, syncHandler.GetStatus)
+			sync.POST("/pull", read, syncHandler.Pull)
+			sync.POST("/push", write, syncHandler.Push)
 		}
 
 		secure := api.Group("/secure")
-		secure.Use(middleware.AuthMiddleware())
+		secure.Use(requireAuth, middleware.DenyScope(models.ScopeSecureNone), middleware.RequireFullAccess())
 		{
 			secure.GET("/vault", secureHandler.GetVaultStatus)
 			secure.POST("/vault", secureHandler.CreateVault)
+			secure.POST("/vault/rekey", secureHandler.RekeyVault)
+			secure.PUT("/vault/verifier", secureHandler.SetVaultVerifier)
+			secure.PUT("/vault/e2e", secureHandler.SetE2EMode)
+			secure.GET("/vault/export", secureHandler.ExportVault)
+			secure.POST("/vault/import", secureHandler.ImportVault)
+			secure.GET("/vault/wrappers", secureHandler.ListWrappers)
+			secure.POST("/vault/wrappers", secureHandler.AddWrappers)
+			secure.DELETE("/vault/wrappers/:id", secureHandler.DeleteWrapper)
+			secure.POST("/vault/recover", secureHandler.ConsumeRecoveryCode)
 			secure.GET("/clips", secureHandler.GetSecureClips)
+			secure.GET("/clips/summary", secureHandler.ListSecureClipSummaries)
 			secure.POST("/clips", secureHandler.CreateSecureClip)
 			secure.PUT("/clips/:id", secureHandler.UpdateSecureClip)
+			secure.PATCH("/clips/:id", secureHandler.PatchSecureClip)
 			secure.DELETE("/clips/:id", secureHandler.DeleteSecureClip)
+			secure.GET("/clips/:id/versions", secureHandler.ListSecureClipVersions)
+			secure.POST("/clips/:id/versions/:versionId/restore", secureHandler.RestoreSecureClipVersion)
+			secure.GET("/keys", secureHandler.GetKeyPair)
+			secure.PUT("/keys", secureHandler.SetKeyPair)
+			secure.GET("/keys/lookup", secureHandler.LookupPublicKey)
+			secure.POST("/shares", secureHandler.CreateShare)
+			secure.GET("/shares/inbox", secureHandler.ListInbox)
+			secure.GET("/shares/sent", secureHandler.ListSent)
+			secure.DELETE("/shares/:id", secureHandler.DeleteShare)
 		}
 
 		messages := api.Group("/messages")
-		messages.Use(middleware.AuthMiddleware())
+		messages.Use(requireAuth)
+		{
+			read, write := middleware.RequireScope(models.ScopeMessagesRead), middleware.RequireScope(models.ScopeMessagesWrite)
+			messages.GET("", read, messagesHandler.List)
+			messages.GET("/new-since", read, messagesHandler.NewSince)
+			messages.GET("/otp/latest", read, messagesHandler.LatestOTP)
+			messages.POST("/read", write, messagesHandler.MarkRead)
+			messages.POST("/archive", write, messagesHandler.Archive)
+			messages.GET("/state", read, messagesHandler.StateChanges)
+			messages.POST("/outbound", write, messagesHandler.EnqueueOutbound)
+			messages.GET("/outbound", read, messagesHandler.ListOutbound)
+			messages.POST("/outbound/claim", write, messagesHandler.ClaimOutbound)
+			messages.GET("/outbound/:id", read, messagesHandler.GetOutbound)
+			messages.DELETE("/outbound/:id", write, messagesHandler.CancelOutbound)
+			messages.POST("/outbound/:id/report", write, messagesHandler.ReportOutbound)
+			messages.GET("/attachments/:id", read, messagesHandler.GetAttachment)
+			messages.HEAD("/attachments/:id", read, messagesHandler.AttachmentUploadStatus)
+			messages.PATCH("/attachments/:id", write, messagesHandler.UploadAttachment)
+			messages.GET("/conversations", read, messagesHandler.ListConversations)
+			messages.GET("/conversations/:threadKey/messages", read, messagesHandler.ListThreadMessages)
+			messages.PUT("/conversations/:threadKey/star", write, messagesHandler.StarThread)
+			messages.DELETE("/conversations/:threadKey/star", write, messagesHandler.UnstarThread)
+			messages.GET("/settings", read, messagesHandler.GetSettings)
+			messages.PUT("/settings", write, messagesHandler.UpdateSettings)
+			messages.POST("/push", write, messagesHandler.Push)
+			messages.POST("/clear", write, messagesHandler.ClearAll)
+			messages.POST("/encrypt", write, messagesHandler.EncryptMessages)
+			messages.DELETE("/:id", write, messagesHandler.Delete)
+		}
+
+		notifications := api.Group("/notifications")
+		notifications.Use(requireAuth)
+		{
+			read, write := middleware.RequireScope(models.ScopeNotificationsRead), middleware.RequireScope(models.ScopeNotificationsWrite)
+			notifications.GET("", read, notificationsHandler.List)
+			notifications.POST("/push", write, notificationsHandler.Push)
+			notifications.GET("/stream", read, eventsHandler.StreamPrefix("notification."))
+			notifications.GET("/dismissals", read, notificationsHandler.Dismissals)
+			notifications.GET("/rules", read, notificationsHandler.ListRules)
+			notifications.PUT("/rules", write, notificationsHandler.SetRule)
+			notifications.DELETE("/rules/:appPackage", write, notificationsHandler.DeleteRule)
+			notifications.POST("/:id/dismiss", write, notificationsHandler.Dismiss)
+		}
+
+		contacts := api.Group("/contacts")
+		contacts.Use(requireAuth)
+		{
+			read, write := middleware.RequireScope(models.ScopeContactsRead), middleware.RequireScope(models.ScopeContactsWrite)
+			contacts.GET("", read, contactsHandler.List)
+			contacts.POST("/sync", write, contactsHandler.Sync)
+			contacts.POST("/clear", write, contactsHandler.ClearAll)
+			contacts.GET("/:id", read, contactsHandler.Get)
+		}
+
+		// Attachment and contact photo downloads authenticate with the signature in the URL
+		api.GET("/attachments/:id/:variant", messagesHandler.DownloadAttachment)
+		api.GET("/contact-photos/:id", contactsHandler.Photo)
+
+		// Server-sent events (one-time codes, ...) for connected clients
+		api.GET("/events", requireAuth, eventsHandler.Stream)
+
+		tokens := api.Group("/tokens")
+		tokens.Use(requireAuth, middleware.RequireFullAccess())
 		{
-			messages.GET("", messagesHandler.List)
-			messages.GET("/new-since", messagesHandler.NewSince)
-			messages.POST("/push", messagesHandler.Push)
-			messages.POST("/clear", messagesHandler.ClearAll)
-			messages.DELETE("/:id", messagesHandler.Delete)
+			tokens.GET("", tokensHandler.List)
+			tokens.POST("", tokensHandler.Create)
+			tokens.DELETE("/:id", tokensHandler.Revoke)
 		}
 	}
 
@@ -0,0 +1,218 @@
+package atrest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"clipsync/backend/internal/blob"
+
+	"github.com/google/uuid"
+)
+
+// BlobTarget is a table column holding blob store keys whose content Reencrypt seals.
+type BlobTarget struct {
+	Table  string
+	Column string
+	Where  string // Rows whose blobs are complete; others are sealed once they are
+}
+
+// BlobTargets lists every blob kind sealed at rest.
+var BlobTargets = []BlobTarget{
+	{Table: "message_attachments", Column: "storage_key", Where: "status = 'ready'"},
+	{Table: "message_attachments", Column: "thumbnail_key", Where: "status = 'ready'"},
+	{Table: "contacts", Column: "photo_key", Where: "true"},
+}
+
+// SealBlob encrypts blob content stored under key with the user's active data key, in the
+// format of sealed values with raw instead of base64 ciphertext. The blob key is bound as
+// additional data. Content is returned unchanged while encryption is disabled.
+func SealBlob(userID, key string, content []byte) ([]byte, error) {
+	if ring == nil {
+		return content, nil
+	}
+	if userID == "" {
+		return nil, errors.New("at-rest seal without a user")
+	}
+
+	keyID, dataKey, err := ring.activeDataKey(userID)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header := prefix + keyID.String() + ":"
+	out := make([]byte, len(header)+gcm.NonceSize(), len(header)+gcm.NonceSize()+len(content)+gcm.Overhead())
+	copy(out, header)
+	nonce := out[len(header):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(out, nonce, content, additionalData(keyID, "blob:"+key)), nil
+}
+
+// OpenBlobContent decrypts blob content read from key. Legacy plaintext is returned unchanged.
+func OpenBlobContent(key string, content []byte) ([]byte, error) {
+	keyID, body, sealed, err := parseBlob(content)
+	if err != nil || !sealed {
+		return content, err
+	}
+	if ring == nil {
+		return nil, ErrDisabled
+	}
+	dataKey, err := ring.dataKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < gcm.NonceSize() {
+		return nil, ErrMalformedValue
+	}
+	return gcm.Open(nil, body[:gcm.NonceSize()], body[gcm.NonceSize():], additionalData(keyID, "blob:"+key))
+}
+
+// PutBlob seals content for the user and stores it under key.
+func PutBlob(ctx context.Context, store blob.Store, userID, key string, content []byte) error {
+	sealed, err := SealBlob(userID, key, content)
+	if err != nil {
+		return err
+	}
+	_, err = store.Put(ctx, key, bytes.NewReader(sealed))
+	return err
+}
+
+// SealStoredBlob replaces the blob under key by its form sealed under the user's active
+// data key, e.g. once a chunked upload is complete. It reports whether the blob changed.
+func SealStoredBlob(ctx context.Context, store blob.Store, userID, key string) (bool, error) {
+	if ring == nil {
+		return false, nil
+	}
+	r, err := store.Open(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	content, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return false, err
+	}
+	keyID, _, sealed, err := parseBlob(content)
+	if err != nil {
+		return false, err
+	}
+	if sealed {
+		active, err := ActiveKeyID(userID)
+		if err != nil || keyID == active {
+			return false, err
+		}
+	}
+	if content, err = OpenBlobContent(key, content); err != nil {
+		return false, err
+	}
+	return true, PutBlob(ctx, store, userID, key, content)
+}
+
+// ReencryptBlobs seals every blob of t that is plaintext or not under its owner's active
+// data key. Blobs are immutable once complete, so it is safe to run against a live server.
+func ReencryptBlobs(ctx context.Context, store blob.Store, t BlobTarget, batchSize int) (int, error) {
+	if ring == nil {
+		return 0, ErrDisabled
+	}
+
+	type row struct {
+		ID     uuid.UUID
+		UserID string
+		Key    string
+	}
+	selectSQL := fmt.Sprintf(`SELECT id, user_id, %s AS key FROM %s WHERE %s IS NOT NULL AND (%s) AND id > ? ORDER BY id LIMIT ?`,
+		t.Column, t.Table, t.Column, t.Where)
+	updated := 0
+	last := uuid.Nil
+	for {
+		var rows []row
+		if err := ring.db.Raw(selectSQL, last, batchSize).Scan(&rows).Error; err != nil {
+			return updated, err
+		}
+		if len(rows) == 0 {
+			return updated, nil
+		}
+		last = rows[len(rows)-1].ID
+
+		for _, r := range rows {
+			changed, err := SealStoredBlob(ctx, store, r.UserID, r.Key)
+			if err == blob.ErrNotFound {
+				continue // Pruned or never stored; nothing to seal
+			}
+			if err != nil {
+				return updated, fmt.Errorf("seal %s.%s %s: %w", t.Table, t.Column, r.ID, err)
+			}
+			if changed {
+				updated++
+			}
+		}
+	}
+}
+
+// OpenBlob reads key, decrypting sealed content. Plaintext blobs are streamed as stored;
+// sealed ones are decrypted into memory. The caller closes the returned reader.
+func OpenBlob(ctx context.Context, store blob.Store, key string) (io.ReadSeekCloser, error) {
+	r, err := store.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, len(prefix))
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		r.Close()
+		return nil, err
+	}
+	if string(head[:n]) != prefix {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			r.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+
+	rest, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	content, err := OpenBlobContent(key, append(head, rest...))
+	if err != nil {
+		return nil, err
+	}
+	return memoryBlob{bytes.NewReader(content)}, nil
+}
+
+// parseBlob splits sealed blob content into its data key and nonce || ciphertext.
+func parseBlob(content []byte) (uuid.UUID, []byte, bool, error) {
+	if !bytes.HasPrefix(content, []byte(prefix)) {
+		return uuid.Nil, nil, false, nil
+	}
+	rest := content[len(prefix):]
+	sep := bytes.IndexByte(rest, ':')
+	if sep < 0 {
+		return uuid.Nil, nil, true, ErrMalformedValue
+	}
+	id, err := uuid.ParseBytes(rest[:sep])
+	if err != nil {
+		return uuid.Nil, nil, true, ErrMalformedValue
+	}
+	return id, rest[sep+1:], true, nil
+}
+
+type memoryBlob struct {
+	*bytes.Reader
+}
+
+func (memoryBlob) Close() error { return nil }
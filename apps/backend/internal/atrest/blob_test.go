@@ -0,0 +1,176 @@
+package atrest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"clipsync/backend/internal/blob"
+)
+
+func TestSealOpenBlob(t *testing.T) {
+	useTestRing(t, "alice")
+	content := []byte("\x89PNG binary content")
+	sealed, err := SealBlob("alice", "attachments/a", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		stored  []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"sealed", "attachments/a", sealed, content, false},
+		{"legacy plaintext", "attachments/a", content, content, false},
+		{"empty plaintext", "attachments/a", []byte{}, []byte{}, false},
+		{"moved to another key", "attachments/b", sealed, nil, true},
+		{"tampered", "attachments/a", append(append([]byte{}, sealed[:len(sealed)-1]...), sealed[len(sealed)-1]^1), nil, true},
+		{"truncated", "attachments/a", sealed[:len(prefix)+37], nil, true},
+		{"bad key ID", "attachments/a", []byte(prefix + "nope:xxxxxxxxxxxxxxxx"), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OpenBlobContent(tt.key, tt.stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OpenBlobContent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, tt.want) {
+				t.Errorf("OpenBlobContent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSealStoredBlob(t *testing.T) {
+	ids := useTestRing(t, "alice")
+	store, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	content := []byte("photo bytes")
+
+	put := func(key string, sealed bool) {
+		t.Helper()
+		if sealed {
+			err = PutBlob(ctx, store, "alice", key, content)
+		} else {
+			_, err = store.Put(ctx, key, bytes.NewReader(content))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("plain", false)
+	put("old", true)
+	newID := rotateTestKey(t, "alice")
+	put("after", true)
+
+	tests := []struct {
+		name        string
+		key         string
+		wantChanged bool
+		wantErr     error
+	}{
+		{"plaintext is sealed", "plain", true, nil},
+		{"sealed before rotation is resealed", "old", true, nil},
+		{"sealed under the active key is kept", "after", false, nil},
+		{"missing", "missing", false, blob.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, err := SealStoredBlob(ctx, store, "alice", tt.key)
+			if err != tt.wantErr {
+				t.Fatalf("SealStoredBlob() error = %v, want %v", err, tt.wantErr)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("SealStoredBlob() changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if err != nil {
+				return
+			}
+			stored := readBlob(t, store, tt.key)
+			if keyID, _, sealed, _ := parseBlob(stored); !sealed || keyID != newID {
+				t.Errorf("stored under %v (sealed %v), want the active key %v", keyID, sealed, newID)
+			}
+			if keyID, _, _, _ := parseBlob(stored); keyID == ids["alice"] {
+				t.Errorf("still sealed under the retired key")
+			}
+		})
+	}
+}
+
+func TestOpenBlob(t *testing.T) {
+	useTestRing(t, "alice")
+	store, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := PutBlob(ctx, store, "alice", "sealed", []byte("sealed content")); err != nil {
+		t.Fatal(err)
+	}
+	for key, content := range map[string]string{"plain": "plain content", "short": "at", "empty": ""} {
+		if _, err := store.Put(ctx, key, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr error
+	}{
+		{"sealed", "sealed", "sealed content", nil},
+		{"plaintext", "plain", "plain content", nil},
+		{"shorter than the prefix", "short", "at", nil},
+		{"empty", "empty", "", nil},
+		{"missing", "missing", "", blob.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := OpenBlob(ctx, store, tt.key)
+			if err != tt.wantErr {
+				t.Fatalf("OpenBlob() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil || string(got) != tt.want {
+				t.Fatalf("OpenBlob() content = %q, %v, want %q", got, err, tt.want)
+			}
+			// Downloads seek for range requests
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			if again, _ := io.ReadAll(r); string(again) != tt.want {
+				t.Errorf("content after seeking back = %q, want %q", again, tt.want)
+			}
+		})
+	}
+}
+
+func readBlob(t *testing.T, store blob.Store, key string) []byte {
+	t.Helper()
+	r, err := store.Open(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
@@ -0,0 +1,310 @@
+// Package atrest encrypts clip and message content before it reaches the database, and
+// attachments and contact photos before they reach the blob store.
+//
+// Every user has a random AES-256 data key, stored wrapped by a server master key. Values
+// are sealed with AES-GCM under the user's active data key and stored as
+//
+//	atrest:v1:<data key ID>:<base64 nonce || ciphertext>
+//
+// Blobs use the same format with raw instead of base64 ciphertext. Values without the
+// prefix are legacy plaintext and are returned unchanged, so tables and blobs can be
+// migrated in place with the reencrypt command.
+package atrest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	prefix = "atrest:v1:"
+
+	// ActiveKeyTTL bounds how long a user's active data key is cached, so rotations
+	// made by the reencrypt command reach running servers within it.
+	ActiveKeyTTL = 5 * time.Minute
+)
+
+var (
+	ErrDisabled       = errors.New("at-rest encryption is not configured")
+	ErrUnknownKey     = errors.New("unknown at-rest key")
+	ErrMalformedValue = errors.New("malformed at-rest value")
+)
+
+type activeKey struct {
+	id      uuid.UUID
+	expires time.Time
+}
+
+// Keyring holds the master keys and caches unwrapped data keys.
+type Keyring struct {
+	db       *gorm.DB
+	masters  map[string][]byte
+	activeID string
+	indexKey []byte
+
+	mu     sync.Mutex
+	keys   map[uuid.UUID][]byte
+	active map[string]activeKey
+}
+
+// ring is nil while at-rest encryption is disabled.
+var ring *Keyring
+
+// Init loads the master keys from config. Without keys, values are stored as plaintext.
+func Init(db *gorm.DB, cfg *config.Config) error {
+	if len(cfg.AtRestKeys) == 0 {
+		ring = nil
+		return nil
+	}
+
+	masters := make(map[string][]byte, len(cfg.AtRestKeys))
+	for id, encoded := range cfg.AtRestKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("at-rest master key %q must be base64 of 32 bytes", id)
+		}
+		masters[id] = key
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(cfg.AtRestIndexKey)
+	if err != nil || len(indexKey) < 32 {
+		return errors.New("AT_REST_INDEX_KEY must be base64 of at least 32 bytes")
+	}
+
+	ring = &Keyring{
+		db:       db,
+		masters:  masters,
+		activeID: cfg.AtRestActiveKey,
+		indexKey: indexKey,
+		keys:     make(map[uuid.UUID][]byte),
+		active:   make(map[string]activeKey),
+	}
+	return nil
+}
+
+// Enabled reports whether new values are sealed.
+func Enabled() bool {
+	return ring != nil
+}
+
+// IsSealed reports whether a stored value is at-rest ciphertext.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Seal encrypts plaintext for column under the user's active data key. It returns the
+// plaintext unchanged while encryption is disabled, and never seals empty strings.
+func Seal(userID, column, plaintext string) (string, error) {
+	if ring == nil || plaintext == "" {
+		return plaintext, nil
+	}
+	if userID == "" {
+		return "", errors.New("at-rest seal without a user")
+	}
+
+	keyID, key, err := ring.activeDataKey(userID)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(key, []byte(plaintext), additionalData(keyID, column))
+	if err != nil {
+		return "", err
+	}
+	return prefix + keyID.String() + ":" + sealed, nil
+}
+
+// Open decrypts a value stored in column. Legacy plaintext is returned unchanged.
+func Open(column, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	if ring == nil {
+		return "", ErrDisabled
+	}
+
+	keyID, body, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	key, err := ring.dataKey(keyID)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(key, body, additionalData(keyID, column))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// KeyID returns the data key that sealed a value.
+func KeyID(value string) (uuid.UUID, bool) {
+	if !IsSealed(value) {
+		return uuid.Nil, false
+	}
+	id, _, err := parse(value)
+	return id, err == nil
+}
+
+// ActiveKeyID returns the data key new values of the user are sealed with.
+func ActiveKeyID(userID string) (uuid.UUID, error) {
+	if ring == nil {
+		return uuid.Nil, ErrDisabled
+	}
+	id, _, err := ring.activeDataKey(userID)
+	return id, err
+}
+
+func parse(value string) (uuid.UUID, string, error) {
+	idStr, body, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return uuid.Nil, "", ErrMalformedValue
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, "", ErrMalformedValue
+	}
+	return id, body, nil
+}
+
+// additionalData binds a ciphertext to its data key and column, so values cannot be
+// swapped between columns.
+func additionalData(keyID uuid.UUID, column string) []byte {
+	return []byte(keyID.String() + ":" + column)
+}
+
+func (r *Keyring) activeDataKey(userID string) (uuid.UUID, []byte, error) {
+	r.mu.Lock()
+	cached, ok := r.active[userID]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		key, err := r.dataKey(cached.id)
+		return cached.id, key, err
+	}
+
+	var row models.UserDataKey
+	err := r.db.Where("user_id = ? AND retired_at IS NULL", userID).Order("created_at DESC").First(&row).Error
+	if err == gorm.ErrRecordNotFound {
+		row, err = r.createDataKey(userID)
+	}
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	key, err := r.unwrap(&row)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	r.mu.Lock()
+	r.keys[row.ID] = key
+	r.active[userID] = activeKey{id: row.ID, expires: time.Now().Add(ActiveKeyTTL)}
+	r.mu.Unlock()
+	return row.ID, key, nil
+}
+
+func (r *Keyring) dataKey(id uuid.UUID) ([]byte, error) {
+	r.mu.Lock()
+	key, ok := r.keys[id]
+	r.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var row models.UserDataKey
+	if err := r.db.Where("id = ?", id).First(&row).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrUnknownKey
+		}
+		return nil, err
+	}
+	key, err := r.unwrap(&row)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.keys[id] = key
+	r.mu.Unlock()
+	return key, nil
+}
+
+func (r *Keyring) createDataKey(userID string) (models.UserDataKey, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return models.UserDataKey{}, err
+	}
+	row := models.UserDataKey{ID: uuid.New(), UserID: userID}
+	if err := r.wrap(&row, key); err != nil {
+		return models.UserDataKey{}, err
+	}
+	if err := r.db.Create(&row).Error; err != nil {
+		return models.UserDataKey{}, err
+	}
+	return row, nil
+}
+
+// wrap seals key under the active master key. The data key ID and owner are bound as
+// additional data, so a wrapped key cannot be moved to another row.
+func (r *Keyring) wrap(row *models.UserDataKey, key []byte) error {
+	wrapped, err := seal(r.masters[r.activeID], key, []byte(row.ID.String()+":"+row.UserID))
+	if err != nil {
+		return err
+	}
+	row.MasterKeyID = r.activeID
+	row.WrappedKey = wrapped
+	return nil
+}
+
+func (r *Keyring) unwrap(row *models.UserDataKey) ([]byte, error) {
+	master, ok := r.masters[row.MasterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: master key %q", ErrUnknownKey, row.MasterKeyID)
+	}
+	return open(master, row.WrappedKey, []byte(row.ID.String()+":"+row.UserID))
+}
+
+func seal(key, plaintext, aad []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, aad)), nil
+}
+
+func open(key []byte, encoded string, aad []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrMalformedValue
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrMalformedValue
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
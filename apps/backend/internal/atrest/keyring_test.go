@@ -0,0 +1,272 @@
+package atrest
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"clipsync/backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// useTestRing enables encryption with a keyring whose users already have a cached active
+// data key, so nothing is loaded from the database. It returns the users' key IDs.
+func useTestRing(t *testing.T, users ...string) map[string]uuid.UUID {
+	t.Helper()
+	prev := ring
+	t.Cleanup(func() { ring = prev })
+
+	ring = &Keyring{
+		masters:  map[string][]byte{"m1": randomKey(t), "m2": randomKey(t)},
+		activeID: "m1",
+		indexKey: randomKey(t),
+		keys:     make(map[uuid.UUID][]byte),
+		active:   make(map[string]activeKey),
+	}
+	ids := make(map[string]uuid.UUID, len(users))
+	for _, user := range users {
+		ids[user] = rotateTestKey(t, user)
+	}
+	return ids
+}
+
+// rotateTestKey gives the user a new active data key, keeping the old one readable.
+func rotateTestKey(t *testing.T, user string) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	ring.keys[id] = randomKey(t)
+	ring.active[user] = activeKey{id: id, expires: time.Now().Add(time.Hour)}
+	return id
+}
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// errAny in a test table accepts any error.
+var errAny = errors.New("any error")
+
+func TestSealOpen(t *testing.T) {
+	ids := useTestRing(t, "alice", "bob")
+	sealed, err := Seal("alice", "body", "hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		column  string
+		value   string
+		want    string
+		wantErr error // nil, or a sentinel; errAny for any error
+	}{
+		{"sealed", "body", sealed, "hello world", nil},
+		{"legacy plaintext", "body", "hello world", "hello world", nil},
+		{"empty", "body", "", "", nil},
+		{"other column", "content", sealed, "", errAny},
+		{"tampered", "body", sealed[:len(sealed)-4] + "AAAA", "", errAny},
+		{"missing body", "body", prefix + ids["alice"].String(), "", ErrMalformedValue},
+		{"bad key ID", "body", prefix + "not-a-uuid:AAAA", "", ErrMalformedValue},
+		{"bad base64", "body", prefix + ids["alice"].String() + ":!!!", "", ErrMalformedValue},
+		{"truncated", "body", prefix + ids["alice"].String() + ":AAAA", "", ErrMalformedValue},
+		{"key of another user", "body", strings.Replace(sealed, ids["alice"].String(), ids["bob"].String(), 1), "", errAny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Open(tt.column, tt.value)
+			switch {
+			case tt.wantErr == errAny && err == nil:
+				t.Fatalf("Open() = %q, want an error", got)
+			case tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Open() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeal(t *testing.T) {
+	ids := useTestRing(t, "alice")
+
+	tests := []struct {
+		name      string
+		user      string
+		plaintext string
+		wantKey   uuid.UUID // uuid.Nil when the value stays plaintext
+		wantErr   bool
+	}{
+		{"sealed under active key", "alice", "secret", ids["alice"], false},
+		{"empty stays empty", "alice", "", uuid.Nil, false},
+		{"no user", "", "secret", uuid.Nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Seal(tt.user, "body", tt.plaintext)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Seal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			keyID, sealed := KeyID(got)
+			if keyID != tt.wantKey || sealed != (tt.wantKey != uuid.Nil) {
+				t.Errorf("Seal() key = %v (sealed %v), want %v", keyID, sealed, tt.wantKey)
+			}
+			if sealed && strings.Contains(got, tt.plaintext) {
+				t.Errorf("Seal() = %q contains the plaintext", got)
+			}
+		})
+	}
+}
+
+func TestSealDisabled(t *testing.T) {
+	ids := useTestRing(t, "alice")
+	sealed, err := Seal("alice", "body", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring = nil
+
+	if got, err := Seal("alice", "body", "secret"); err != nil || got != "secret" {
+		t.Errorf("Seal() while disabled = %q, %v, want the plaintext", got, err)
+	}
+	if _, err := Open("body", sealed); err != ErrDisabled {
+		t.Errorf("Open() of a value sealed under %v while disabled = %v, want ErrDisabled", ids["alice"], err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ids := useTestRing(t, "alice")
+	old, err := Seal("alice", "body", "before rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newID := rotateTestKey(t, "alice")
+	fresh, err := Seal("alice", "body", "after rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantKey uuid.UUID
+	}{
+		{"sealed before rotation", old, "before rotation", ids["alice"]},
+		{"sealed after rotation", fresh, "after rotation", newID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if keyID, _ := KeyID(tt.value); keyID != tt.wantKey {
+				t.Errorf("KeyID() = %v, want %v", keyID, tt.wantKey)
+			}
+			got, err := Open("body", tt.value)
+			if err != nil || got != tt.want {
+				t.Errorf("Open() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	useTestRing(t)
+	dataKey := randomKey(t)
+	row := models.UserDataKey{ID: uuid.New(), UserID: "alice"}
+	if err := ring.wrap(&row, dataKey); err != nil {
+		t.Fatal(err)
+	}
+	if row.MasterKeyID != "m1" {
+		t.Fatalf("wrapped under %q, want the active master key m1", row.MasterKeyID)
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(*models.UserDataKey)
+		wantErr bool
+	}{
+		{"unchanged", func(*models.UserDataKey) {}, false},
+		{"moved to another user", func(r *models.UserDataKey) { r.UserID = "bob" }, true},
+		{"moved to another row", func(r *models.UserDataKey) { r.ID = uuid.New() }, true},
+		{"wrong master key", func(r *models.UserDataKey) { r.MasterKeyID = "m2" }, true},
+		{"removed master key", func(r *models.UserDataKey) { r.MasterKeyID = "m0" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := row
+			tt.edit(&r)
+			got, err := ring.unwrap(&r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unwrap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != string(dataKey) {
+				t.Errorf("unwrap() returned a different key")
+			}
+		})
+	}
+
+	// Re-wrapping under a new master key keeps the data key
+	ring.activeID = "m2"
+	if err := ring.wrap(&row, dataKey); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ring.unwrap(&row); err != nil || string(got) != string(dataKey) || row.MasterKeyID != "m2" {
+		t.Errorf("unwrap() after re-wrap under m2 = %v, master %q", err, row.MasterKeyID)
+	}
+}
+
+func TestSearchTokens(t *testing.T) {
+	useTestRing(t)
+
+	tests := []struct {
+		name       string
+		userA      string
+		textA      string
+		userB      string
+		textB      string
+		wantShared int
+	}{
+		{"same words", "alice", "Hello world", "alice", "hello WORLD", 2},
+		{"punctuation", "alice", "hello, world!", "alice", "world hello", 2},
+		{"partial overlap", "alice", "hello world", "alice", "hello there", 1},
+		{"other user", "alice", "hello world", "bob", "hello world", 0},
+		{"unicode words", "alice", "Grüße aus Köln", "alice", "köln", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := SearchTokens(tt.userA, tt.textA)
+			inA := make(map[string]bool, len(a))
+			for _, tok := range a {
+				inA[tok] = true
+			}
+			shared := 0
+			for _, tok := range SearchTokens(tt.userB, tt.textB) {
+				if inA[tok] {
+					shared++
+				}
+			}
+			if shared != tt.wantShared {
+				t.Errorf("shared tokens = %d, want %d", shared, tt.wantShared)
+			}
+		})
+	}
+
+	if got := SearchIndex("alice", "a a a b"); len(strings.Fields(got)) != 2 {
+		t.Errorf("SearchIndex() = %q, want 2 distinct tokens", got)
+	}
+}
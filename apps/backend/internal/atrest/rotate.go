@@ -0,0 +1,151 @@
+package atrest
+
+import (
+	"fmt"
+	"time"
+
+	"clipsync/backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Target is a sealed column rewritten by Reencrypt.
+type Target struct {
+	Table       string
+	Column      string
+	IndexColumn string // Search index rebuilt from the plaintext; "" for none
+	NoE2E       bool   // The table has no "encrypted" column for client-side ciphertext
+}
+
+// Targets lists every column sealed through the serializer.
+var Targets = []Target{
+	{Table: "clips", Column: "content", IndexColumn: "search_index"},
+	{Table: "clips", Column: "content_preview"},
+	{Table: "synced_messages", Column: "body", IndexColumn: "search_index"},
+	{Table: "otp_codes", Column: "code", NoE2E: true},
+	{Table: "outbound_messages", Column: "body"},
+	{Table: "notifications", Column: "title"},
+	{Table: "notifications", Column: "text"},
+	{Table: "contacts", Column: "display_name", IndexColumn: "search_index", NoE2E: true},
+}
+
+// RewrapDataKeys re-wraps every data key that is not under the active master key, so
+// retired master keys can be removed from config afterwards. Content is not touched.
+func RewrapDataKeys() (int, error) {
+	if ring == nil {
+		return 0, ErrDisabled
+	}
+
+	var rows []models.UserDataKey
+	if err := ring.db.Where("master_key_id <> ?", ring.activeID).Find(&rows).Error; err != nil {
+		return 0, err
+	}
+	for i := range rows {
+		key, err := ring.unwrap(&rows[i])
+		if err != nil {
+			return i, fmt.Errorf("unwrap data key %s: %w", rows[i].ID, err)
+		}
+		oldMaster := rows[i].MasterKeyID
+		if err := ring.wrap(&rows[i], key); err != nil {
+			return i, err
+		}
+		if err := ring.db.Model(&models.UserDataKey{}).
+			Where("id = ? AND master_key_id = ?", rows[i].ID, oldMaster).
+			Updates(map[string]interface{}{
+				"master_key_id": rows[i].MasterKeyID,
+				"wrapped_key":   rows[i].WrappedKey,
+			}).Error; err != nil {
+			return i, err
+		}
+	}
+	return len(rows), nil
+}
+
+// RetireDataKeys marks every active data key retired. New values get fresh data keys;
+// Reencrypt then moves existing values onto them. Running servers keep sealing under a
+// retired key until their cached active key expires, so values may still arrive under it
+// for up to ActiveKeyTTL.
+func RetireDataKeys() (int64, error) {
+	if ring == nil {
+		return 0, ErrDisabled
+	}
+	result := ring.db.Model(&models.UserDataKey{}).Where("retired_at IS NULL").Update("retired_at", time.Now())
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	ring.mu.Lock()
+	ring.active = make(map[string]activeKey)
+	ring.mu.Unlock()
+	return result.RowsAffected, nil
+}
+
+// Reencrypt seals every value of t that is legacy plaintext or not under its owner's
+// active data key, rebuilding the search index on the way. Each row is updated only if
+// it still holds the value that was read, so it is safe to run against a live server.
+func Reencrypt(t Target, batchSize int) (int, error) {
+	if ring == nil {
+		return 0, ErrDisabled
+	}
+
+	type row struct {
+		ID        uuid.UUID
+		UserID    string
+		Value     *string
+		Encrypted bool
+	}
+
+	encrypted := "encrypted"
+	if t.NoE2E {
+		encrypted = "false"
+	}
+	selectSQL := fmt.Sprintf(`SELECT id, user_id, %s AS value, %s AS encrypted FROM %s WHERE id > ? ORDER BY id LIMIT ?`, t.Column, encrypted, t.Table)
+	updated := 0
+	last := uuid.Nil
+	for {
+		var rows []row
+		if err := ring.db.Raw(selectSQL, last, batchSize).Scan(&rows).Error; err != nil {
+			return updated, err
+		}
+		if len(rows) == 0 {
+			return updated, nil
+		}
+		last = rows[len(rows)-1].ID
+
+		for _, r := range rows {
+			if r.Value == nil || *r.Value == "" {
+				continue
+			}
+			active, err := ActiveKeyID(r.UserID)
+			if err != nil {
+				return updated, err
+			}
+			if id, ok := KeyID(*r.Value); ok && id == active {
+				continue
+			}
+
+			plaintext, err := Open(t.Column, *r.Value)
+			if err != nil {
+				return updated, fmt.Errorf("open %s.%s %s: %w", t.Table, t.Column, r.ID, err)
+			}
+			sealed, err := Seal(r.UserID, t.Column, plaintext)
+			if err != nil {
+				return updated, err
+			}
+
+			updates := map[string]interface{}{t.Column: sealed}
+			if t.IndexColumn != "" {
+				// E2E rows are ciphertext to the server too and are searched by client tokens
+				index := ""
+				if !r.Encrypted {
+					index = SearchIndex(r.UserID, plaintext)
+				}
+				updates[t.IndexColumn] = index
+			}
+			result := ring.db.Table(t.Table).Where("id = ? AND "+t.Column+" = ?", r.ID, *r.Value).Updates(updates)
+			if result.Error != nil {
+				return updated, result.Error
+			}
+			updated += int(result.RowsAffected)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package atrest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"unicode"
+)
+
+// maxSearchTokens bounds the index stored per value.
+const maxSearchTokens = 1000
+
+// SearchIndex returns the space-separated search tokens for text: a keyed hash of every
+// distinct lower-cased word, so sealed content stays searchable by whole words without
+// storing them. It returns "" while encryption is disabled.
+func SearchIndex(userID, text string) string {
+	if ring == nil {
+		return ""
+	}
+	return strings.Join(SearchTokens(userID, text), " ")
+}
+
+// SearchTokens hashes the distinct words of a query the same way SearchIndex does.
+func SearchTokens(userID, text string) []string {
+	if ring == nil {
+		return nil
+	}
+
+	mac := hmac.New(sha256.New, ring.indexKey)
+	mac.Write([]byte("clipsync-search:" + userID))
+	userKey := mac.Sum(nil)
+
+	seen := make(map[string]bool)
+	var tokens []string
+	for _, word := range words(text) {
+		if seen[word] || len(tokens) == maxSearchTokens {
+			continue
+		}
+		seen[word] = true
+		h := hmac.New(sha256.New, userKey)
+		h.Write([]byte(word))
+		tokens = append(tokens, base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:12]))
+	}
+	return tokens
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,54 @@
+package atrest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("atrest", Serializer{})
+}
+
+// Serializer seals string fields tagged `serializer:atrest` on write and opens them on
+// read. The owning model must have a UserID string field, which selects the data key.
+// Map-based updates bypass serializers; seal those values with Seal directly.
+type Serializer struct{}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("atrest: unsupported value %T for %s", dbValue, field.DBName)
+	}
+
+	plaintext, err := Open(field.DBName, stored)
+	if err != nil {
+		return fmt.Errorf("atrest: open %s: %w", field.DBName, err)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(plaintext)
+	return nil
+}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("atrest: %s is not a string field", field.DBName)
+	}
+	if ring == nil || plaintext == "" {
+		return plaintext, nil
+	}
+
+	owner := reflect.Indirect(dst).FieldByName("UserID")
+	if !owner.IsValid() || owner.Kind() != reflect.String {
+		return nil, fmt.Errorf("atrest: %s has no UserID field", field.Schema.Name)
+	}
+	return Seal(owner.String(), field.DBName, plaintext)
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"clipsync/backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// APITokenPrefix marks personal access tokens so they can be routed without a DB lookup.
+const APITokenPrefix = "cs_pat_"
+
+// lastUsedGranularity limits how often last_used_at is written for busy tokens.
+const lastUsedGranularity = time.Minute
+
+// APITokenAuthenticator validates personal access tokens created through /api/tokens.
+type APITokenAuthenticator struct {
+	db *gorm.DB
+}
+
+func NewAPITokenAuthenticator(db *gorm.DB) *APITokenAuthenticator {
+	return &APITokenAuthenticator{db: db}
+}
+
+func (a *APITokenAuthenticator) Authenticate(token string) (*Identity, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return nil, ErrNotApplicable
+	}
+
+	var record models.APIToken
+	if err := a.db.Where("token_hash = ?", hashToken(token)).First(&record).Error; err != nil {
+		return nil, ErrUnauthorized
+	}
+	if record.IsExpired() {
+		return nil, ErrUnauthorized
+	}
+
+	now := time.Now()
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) > lastUsedGranularity {
+		a.db.Model(&record).Update("last_used_at", now)
+	}
+
+	scopes := record.ScopeList()
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return &Identity{UserID: record.UserID, Scopes: scopes}, nil
+}
+
+// NewAPIToken generates a token string and the record to persist for it.
+func NewAPIToken(userID, name string, scopes []string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	return token, &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:len(APITokenPrefix)+4],
+		TokenHash: hashToken(token),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}, nil
+}
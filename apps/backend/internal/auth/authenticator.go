@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"clipsync/backend/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNotApplicable means an authenticator does not recognise the credential format,
+	// so the next authenticator in the chain should try it.
+	ErrNotApplicable = errors.New("credential not handled by this authenticator")
+	ErrUnauthorized  = errors.New("invalid credentials")
+)
+
+// Identity is the authenticated caller attached to the request context.
+type Identity struct {
+	UserID   string
+	FamilyID string       // Refresh token family of the device, if known
+	User     *UserProfile // Filled in by authenticators that already loaded the user
+	Scopes   []string     // nil for full-access device/session credentials; set for API tokens
+}
+
+// Authenticator validates a bearer credential.
+type Authenticator interface {
+	Authenticate(token string) (*Identity, error)
+}
+
+// Chain tries each authenticator in order until one accepts or rejects the token.
+type Chain []Authenticator
+
+func (ch Chain) Authenticate(token string) (*Identity, error) {
+	for _, a := range ch {
+		identity, err := a.Authenticate(token)
+		if err == ErrNotApplicable {
+			continue
+		}
+		return identity, err
+	}
+	return nil, ErrUnauthorized
+}
+
+// NewFromConfig builds the authenticator chain listed in AUTH_PROVIDERS
+// ("pat", "jwt", "session", "jwks"; comma-separated, tried in order).
+func NewFromConfig(db *gorm.DB) Authenticator {
+	cfg := config.Get()
+
+	var chain Chain
+	for _, name := range cfg.AuthProviders {
+		switch name {
+		case "pat":
+			chain = append(chain, NewAPITokenAuthenticator(db))
+		case "jwt":
+			chain = append(chain, NewJWTAuthenticator(db))
+		case "session":
+			chain = append(chain, NewSessionAuthenticator(db))
+		case "jwks":
+			if cfg.JWKSURL == "" && cfg.JWKSFile == "" {
+				log.Println("auth: jwks provider enabled but neither JWKS_URL nor JWKS_FILE is set; skipping")
+				continue
+			}
+			chain = append(chain, NewJWKSAuthenticator(cfg.JWKSURL, cfg.JWKSFile))
+		default:
+			log.Printf("auth: unknown provider %q ignored", name)
+		}
+	}
+	if len(chain) == 0 {
+		chain = append(chain, NewJWTAuthenticator(db))
+	}
+	return chain
+}
+
+// JWTAuthenticator accepts HS256 tokens issued by this server (pairing and refresh),
+// signed with JWTSecret or one of the JWT_KEYS rotation keys. Tokens bound to a refresh
+// token family are rejected once that family has been revoked.
+type JWTAuthenticator struct {
+	db *gorm.DB
+}
+
+func NewJWTAuthenticator(db *gorm.DB) *JWTAuthenticator {
+	return &JWTAuthenticator{db: db}
+}
+
+func (a *JWTAuthenticator) Authenticate(tokenString string) (*Identity, error) {
+	if !looksLikeJWT(tokenString) {
+		return nil, ErrNotApplicable
+	}
+
+	// Tokens signed with another algorithm belong to a different authenticator (e.g. JWKS)
+	header, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	if _, ok := header.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, ErrNotApplicable
+	}
+
+	cfg := config.Get()
+	token, err := jwt.Parse(tokenString, hmacKeyFunc, parserOptions([]string{"HS256"}, cfg.JWTIssuer, cfg.JWTAudience)...)
+	if err != nil || !token.Valid {
+		return nil, ErrUnauthorized
+	}
+
+	identity, err := identityFromClaims(token.Claims)
+	if err != nil {
+		return nil, err
+	}
+	if identity.FamilyID != "" {
+		if _, err := uuid.Parse(identity.FamilyID); err != nil {
+			return nil, ErrUnauthorized
+		}
+		revoked, err := FamilyRevoked(a.db, identity.FamilyID)
+		if err != nil || revoked {
+			return nil, ErrUnauthorized
+		}
+	}
+	return identity, nil
+}
+
+// identityFromClaims reads the user ID from "userId" (ClipSync tokens) or "sub" (Better-Auth JWT plugin).
+func identityFromClaims(c jwt.Claims) (*Identity, error) {
+	claims, ok := c.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+
+	userID, _ := claims["userId"].(string)
+	if userID == "" {
+		userID, _ = claims["sub"].(string)
+	}
+	if userID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	identity := &Identity{UserID: userID}
+	if familyID, ok := claims["fid"].(string); ok {
+		identity.FamilyID = familyID
+	}
+	return identity, nil
+}
+
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
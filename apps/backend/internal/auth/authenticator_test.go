@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"clipsync/backend/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func loadTestConfig(t *testing.T) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "legacy-secret")
+	t.Setenv("JWT_KEYS", "k1:first-secret,k2:second-secret")
+	t.Setenv("JWT_ACTIVE_KID", "k2")
+	t.Setenv("JWT_ISSUER", "clipsync")
+	t.Setenv("JWT_AUDIENCE", "clipsync-api")
+	t.Setenv("JWT_CLOCK_SKEW", "30s")
+	if err := config.Load(); err != nil {
+		t.Fatalf("config.Load() = %v", err)
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	loadTestConfig(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	// claims returns valid claims for this server; with drops keys and overrides others
+	claims := func(with map[string]interface{}) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"userId": "user-1",
+			"iss":    "clipsync",
+			"aud":    "clipsync-api",
+			"iat":    now.Unix(),
+			"exp":    now.Add(time.Minute).Unix(),
+		}
+		for k, v := range with {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	hs := func(method jwt.SigningMethod, kid, secret string, c jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, c)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	rs := func(c jwt.MapClaims) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodRS256, c).SignedString(rsaKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"active kid", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(nil)), nil},
+		{"rotated kid", hs(jwt.SigningMethodHS256, "k1", "first-secret", claims(nil)), nil},
+		{"no kid uses legacy secret", hs(jwt.SigningMethodHS256, "", "legacy-secret", claims(nil)), nil},
+		{"sub instead of userId", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"userId": nil, "sub": "user-1"})), nil},
+		{"expiry within skew", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()})), nil},
+		{"unknown kid", hs(jwt.SigningMethodHS256, "k3", "second-secret", claims(nil)), ErrUnauthorized},
+		{"kid with wrong key", hs(jwt.SigningMethodHS256, "k1", "second-secret", claims(nil)), ErrUnauthorized},
+		{"HS384 not pinned", hs(jwt.SigningMethodHS384, "k2", "second-secret", claims(nil)), ErrUnauthorized},
+		{"RS256 left to other authenticators", rs(claims(nil)), ErrNotApplicable},
+		{"wrong issuer", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"iss": "elsewhere"})), ErrUnauthorized},
+		{"missing issuer", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"iss": nil})), ErrUnauthorized},
+		{"wrong audience", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"aud": "other-api"})), ErrUnauthorized},
+		{"audience list", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"aud": []string{"other-api", "clipsync-api"}})), nil},
+		{"expired beyond skew", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})), ErrUnauthorized},
+		{"no expiry", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"exp": nil})), ErrUnauthorized},
+		{"issued in the future", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"iat": now.Add(time.Hour).Unix()})), ErrUnauthorized},
+		{"no user", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"userId": nil})), ErrUnauthorized},
+		{"invalid family", hs(jwt.SigningMethodHS256, "k2", "second-secret", claims(map[string]interface{}{"fid": "not-a-uuid"})), ErrUnauthorized},
+		{"not a JWT", "csk_0123456789", ErrNotApplicable},
+		{"garbled JWT", "a.b.c", ErrUnauthorized},
+	}
+
+	a := NewJWTAuthenticator(nil) // Family revocation is not consulted without a family ID
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := a.Authenticate(tt.token)
+			if err != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && identity.UserID != "user-1" {
+				t.Errorf("Authenticate() user = %q, want %q", identity.UserID, "user-1")
+			}
+		})
+	}
+}
+
+func TestNewAccessToken(t *testing.T) {
+	loadTestConfig(t)
+
+	tests := []struct {
+		name     string
+		familyID uuid.UUID
+	}{
+		{"with family", uuid.New()},
+		{"without family", uuid.Nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenString, _, err := NewAccessToken("user-1", tt.familyID)
+			if err != nil {
+				t.Fatalf("NewAccessToken() = %v", err)
+			}
+			token, err := jwt.Parse(tokenString, hmacKeyFunc, parserOptions([]string{"HS256"}, "clipsync", "clipsync-api")...)
+			if err != nil {
+				t.Fatalf("parsing issued token: %v", err)
+			}
+			if kid := token.Header["kid"]; kid != "k2" {
+				t.Errorf("kid = %v, want the active kid k2", kid)
+			}
+			identity, err := identityFromClaims(token.Claims)
+			if err != nil {
+				t.Fatalf("identityFromClaims() = %v", err)
+			}
+			wantFamily := ""
+			if tt.familyID != uuid.Nil {
+				wantFamily = tt.familyID.String()
+			}
+			if identity.UserID != "user-1" || identity.FamilyID != wantFamily {
+				t.Errorf("identity = %+v, want user-1 in family %q", identity, wantFamily)
+			}
+		})
+	}
+}
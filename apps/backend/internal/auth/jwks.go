@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"clipsync/backend/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	jwksRefreshInterval = time.Hour
+	jwksMinRefetch      = time.Minute // Rate limit for refetches triggered by an unknown kid
+)
+
+// JWKSAuthenticator verifies RS256/EdDSA tokens (e.g. from Better-Auth's JWT plugin)
+// against a JSON Web Key Set loaded from a URL or a local file.
+type JWKSAuthenticator struct {
+	url  string
+	file string
+
+	mu        sync.RWMutex
+	keys      map[string]interface{} // kid -> *rsa.PublicKey | ed25519.PublicKey
+	fetchedAt time.Time
+}
+
+func NewJWKSAuthenticator(url, file string) *JWKSAuthenticator {
+	a := &JWKSAuthenticator{url: url, file: file}
+	if err := a.refresh(); err != nil {
+		log.Printf("auth: initial JWKS load failed: %v", err)
+	}
+	return a
+}
+
+func (a *JWKSAuthenticator) Authenticate(tokenString string) (*Identity, error) {
+	if !looksLikeJWT(tokenString) {
+		return nil, ErrNotApplicable
+	}
+
+	cfg := config.Get()
+	token, err := jwt.Parse(tokenString, a.keyFunc, parserOptions([]string{"RS256", "EdDSA"}, cfg.JWKSIssuer, cfg.JWKSAudience)...)
+	if err != nil || !token.Valid {
+		return nil, ErrUnauthorized
+	}
+	return identityFromClaims(token.Claims)
+}
+
+func (a *JWKSAuthenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	// Periodic refresh; on failure keep serving the cached keys
+	if a.age() > jwksRefreshInterval {
+		if err := a.refresh(); err != nil {
+			log.Printf("auth: JWKS refresh failed: %v", err)
+		}
+	}
+	if key := a.lookup(kid); key != nil {
+		return key, nil
+	}
+
+	// Unknown kid: the issuer may have rotated keys since the last fetch
+	if a.age() > jwksMinRefetch {
+		if err := a.refresh(); err != nil {
+			log.Printf("auth: JWKS refresh failed: %v", err)
+		}
+		if key := a.lookup(kid); key != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (a *JWKSAuthenticator) age() time.Duration {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return time.Since(a.fetchedAt)
+}
+
+func (a *JWKSAuthenticator) lookup(kid string) interface{} {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if key, ok := a.keys[kid]; ok {
+		return key
+	}
+	// Single-key sets are commonly used without a kid in the token header
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+func (a *JWKSAuthenticator) refresh() error {
+	data, err := a.load()
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.keys = keys
+	a.fetchedAt = time.Now()
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *JWKSAuthenticator) load() ([]byte, error) {
+	if a.file != "" {
+		return os.ReadFile(a.file)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(a.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS fetch returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+}
+
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("auth: skipping JWK %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
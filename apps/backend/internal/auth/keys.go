@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"fmt"
+
+	"clipsync/backend/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signToken signs claims with the active HS256 key, tagging the header with its kid
+// and adding the configured issuer and audience.
+func signToken(claims jwt.MapClaims) (string, error) {
+	cfg := config.Get()
+
+	if cfg.JWTIssuer != "" {
+		claims["iss"] = cfg.JWTIssuer
+	}
+	if cfg.JWTAudience != "" {
+		claims["aud"] = cfg.JWTAudience
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	secret := cfg.JWTSecret
+	if cfg.JWTActiveKID != "" {
+		token.Header["kid"] = cfg.JWTActiveKID
+		secret = cfg.JWTKeys[cfg.JWTActiveKID]
+	}
+	return token.SignedString([]byte(secret))
+}
+
+// hmacKeyFunc resolves the verification key from the kid header. Tokens without a kid
+// were signed with JWTSecret; any other kid must be one of the configured rotation keys.
+func hmacKeyFunc(token *jwt.Token) (interface{}, error) {
+	cfg := config.Get()
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return []byte(cfg.JWTSecret), nil
+	}
+	if secret, ok := cfg.JWTKeys[kid]; ok {
+		return []byte(secret), nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// parserOptions builds the claim policy shared by all verifiers: pinned algorithms,
+// required expiry, clock-skew leeway and optional issuer/audience checks.
+func parserOptions(methods []string, issuer, audience string) []jwt.ParserOption {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(config.Get().JWTClockSkew),
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+	return opts
+}
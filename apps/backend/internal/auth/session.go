@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserProfile is the subset of Better-Auth's "user" row exposed by /api/auth/me.
+type UserProfile struct {
+	ID            string    `gorm:"column:id" json:"id"`
+	Name          string    `gorm:"column:name" json:"name"`
+	Email         string    `gorm:"column:email" json:"email"`
+	EmailVerified bool      `gorm:"column:emailVerified" json:"emailVerified"`
+	Image         *string   `gorm:"column:image" json:"image"`
+	CreatedAt     time.Time `gorm:"column:createdAt" json:"createdAt"`
+}
+
+// SessionAuthenticator validates Better-Auth session tokens against the "session" and
+// "user" tables that Better-Auth maintains in the same Postgres database.
+type SessionAuthenticator struct {
+	db *gorm.DB
+}
+
+func NewSessionAuthenticator(db *gorm.DB) *SessionAuthenticator {
+	return &SessionAuthenticator{db: db}
+}
+
+func (a *SessionAuthenticator) Authenticate(token string) (*Identity, error) {
+	if looksLikeJWT(token) {
+		return nil, ErrNotApplicable
+	}
+
+	// Session cookies are "token.signature"; bearer clients may send either form
+	if i := strings.Index(token, "."); i > 0 {
+		token = token[:i]
+	}
+
+	var session struct {
+		UserID    string    `gorm:"column:userId"`
+		ExpiresAt time.Time `gorm:"column:expiresAt"`
+	}
+	result := a.db.Raw(`SELECT "userId", "expiresAt" FROM "session" WHERE "token" = ? LIMIT 1`, token).Scan(&session)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, ErrUnauthorized
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrUnauthorized
+	}
+
+	profile, err := LoadUserProfile(a.db, session.UserID)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+
+	return &Identity{UserID: session.UserID, User: profile}, nil
+}
+
+// LoadUserProfile reads a user from Better-Auth's "user" table.
+func LoadUserProfile(db *gorm.DB, userID string) (*UserProfile, error) {
+	var profile UserProfile
+	result := db.Raw(`SELECT "id", "name", "email", "emailVerified", "image", "createdAt" FROM "user" WHERE "id" = ? LIMIT 1`, userID).Scan(&profile)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &profile, nil
+}
+
+// FindUserIDByEmail resolves a Better-Auth user by email (case-insensitive).
+func FindUserIDByEmail(db *gorm.DB, email string) (string, error) {
+	var userID string
+	result := db.Raw(`SELECT "id" FROM "user" WHERE LOWER("email") = LOWER(?) LIMIT 1`, strings.TrimSpace(email)).Scan(&userID)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+	return userID, nil
+}
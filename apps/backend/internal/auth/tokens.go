@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// TokenPair is returned to a device after pairing or a refresh.
+type TokenPair struct {
+	AccessToken  string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"` // Access token expiry
+}
+
+// IssueTokens starts a new refresh token family for a device and returns its first token pair.
+func IssueTokens(db *gorm.DB, userID, deviceID string) (*TokenPair, error) {
+	return issue(db, userID, deviceID, uuid.New())
+}
+
+// Refresh rotates a refresh token. A token that was already rotated revokes its whole family.
+func Refresh(db *gorm.DB, refreshToken string) (*TokenPair, error) {
+	var pair *TokenPair
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(refreshToken)).
+			First(&current).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		if err := checkRotatable(&current); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&current).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		var err error
+		pair, err = issue(tx, current.UserID, current.DeviceID, current.FamilyID)
+		return err
+	})
+
+	if err == ErrRefreshTokenReused {
+		// Revoke outside the rolled-back transaction so the revocation sticks. Reuse is only
+		// reported once the family is revoked; anything else fails closed as an internal error.
+		var reused models.RefreshToken
+		if lookupErr := db.Where("token_hash = ?", hashToken(refreshToken)).First(&reused).Error; lookupErr != nil {
+			return nil, fmt.Errorf("loading reused refresh token: %w", lookupErr)
+		}
+		if revokeErr := RevokeFamily(db, reused.UserID, reused.FamilyID); revokeErr != nil {
+			return nil, fmt.Errorf("revoking reused refresh token family: %w", revokeErr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// RevokeFamily revokes every outstanding refresh token of a device login.
+func RevokeFamily(db *gorm.DB, userID string, familyID uuid.UUID) error {
+	return db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND family_id = ? AND revoked_at IS NULL", userID, familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// FamilyRevoked reports whether a refresh token family was revoked by logout or reuse
+// detection. Access tokens carrying that family ID stop working immediately.
+func FamilyRevoked(db *gorm.DB, familyID string) (bool, error) {
+	var count int64
+	err := db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NOT NULL", familyID).
+		Limit(1).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// FamilyOf looks up the family of a refresh token owned by userID.
+func FamilyOf(db *gorm.DB, userID, refreshToken string) (uuid.UUID, error) {
+	var token models.RefreshToken
+	if err := db.Where("token_hash = ? AND user_id = ?", hashToken(refreshToken), userID).First(&token).Error; err != nil {
+		return uuid.Nil, ErrInvalidRefreshToken
+	}
+	return token.FamilyID, nil
+}
+
+// checkRotatable rejects refresh tokens that were revoked, expired or already rotated.
+// A rotated token is only presented again when it was stolen, hence ErrRefreshTokenReused.
+func checkRotatable(token *models.RefreshToken) error {
+	if token.RevokedAt != nil || token.IsExpired() {
+		return ErrInvalidRefreshToken
+	}
+	if token.UsedAt != nil {
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+func issue(db *gorm.DB, userID, deviceID string, familyID uuid.UUID) (*TokenPair, error) {
+	cfg := config.Get()
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(raw)
+
+	record := models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		DeviceID:  deviceID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(cfg.RefreshTokenTTL),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return nil, err
+	}
+
+	accessToken, expiresAt, err := NewAccessToken(userID, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+	}, nil
+}
+
+// NewAccessToken mints a short-lived access token. The family ID ("fid") lets logout
+// revoke the refresh tokens of the device that made the request.
+func NewAccessToken(userID string, familyID uuid.UUID) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(config.Get().AccessTokenTTL)
+
+	claims := jwt.MapClaims{
+		"userId": userID,
+		"exp":    expiresAt.Unix(),
+		"iat":    now.Unix(),
+		"nbf":    now.Unix(),
+	}
+	if familyID != uuid.Nil {
+		claims["fid"] = familyID.String()
+	}
+
+	tokenString, err := signToken(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return tokenString, expiresAt, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"clipsync/backend/internal/models"
+)
+
+func TestCheckRotatable(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		token   models.RefreshToken
+		wantErr error
+	}{
+		{"fresh", models.RefreshToken{ExpiresAt: future}, nil},
+		{"used", models.RefreshToken{ExpiresAt: future, UsedAt: &past}, ErrRefreshTokenReused},
+		{"revoked", models.RefreshToken{ExpiresAt: future, RevokedAt: &past}, ErrInvalidRefreshToken},
+		{"expired", models.RefreshToken{ExpiresAt: past}, ErrInvalidRefreshToken},
+		{"used after revocation", models.RefreshToken{ExpiresAt: future, UsedAt: &past, RevokedAt: &past}, ErrInvalidRefreshToken},
+		{"used after expiry", models.RefreshToken{ExpiresAt: past, UsedAt: &past}, ErrInvalidRefreshToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkRotatable(&tt.token); err != tt.wantErr {
+				t.Errorf("checkRotatable() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"same token", "abc", "abc", true},
+		{"different tokens", "abc", "abd", false},
+		{"case sensitive", "abc", "ABC", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ha, hb := hashToken(tt.a), hashToken(tt.b)
+			if len(ha) != 64 {
+				t.Fatalf("hashToken(%q) has length %d, want 64 hex characters", tt.a, len(ha))
+			}
+			if (ha == hb) != tt.same {
+				t.Errorf("hashToken(%q) == hashToken(%q) is %v, want %v", tt.a, tt.b, ha == hb, tt.same)
+			}
+		})
+	}
+}
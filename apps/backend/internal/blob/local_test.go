@@ -0,0 +1,178 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// failingReader returns its content, then an error, like a connection dropped mid-chunk.
+type failingReader struct{ r io.Reader }
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestLocalAppend(t *testing.T) {
+	type step struct {
+		offset   int64
+		chunk    io.Reader
+		wantSize int64
+		wantErr  error // nil, ErrOffset, or errAny
+	}
+	errAny := errors.New("any error")
+
+	tests := []struct {
+		name        string
+		steps       []step
+		wantContent string
+	}{
+		{"single chunk", []step{{0, strings.NewReader("hello"), 5, nil}}, "hello"},
+		{"sequential chunks", []step{
+			{0, strings.NewReader("hello "), 6, nil},
+			{6, strings.NewReader("world"), 11, nil},
+		}, "hello world"},
+		{"resume before an unrecorded chunk", []step{
+			{0, strings.NewReader("hello "), 6, nil},
+			{6, strings.NewReader("wrld"), 10, nil},
+			{6, strings.NewReader("world"), 11, nil},
+		}, "hello world"},
+		{"restart from zero", []step{
+			{0, strings.NewReader("first try"), 9, nil},
+			{0, strings.NewReader("second"), 6, nil},
+		}, "second"},
+		{"offset beyond size", []step{
+			{0, strings.NewReader("hello"), 5, nil},
+			{7, strings.NewReader("world"), 5, ErrOffset},
+		}, "hello"},
+		{"offset beyond a new blob", []step{{3, strings.NewReader("abc"), 0, ErrOffset}}, ""},
+		{"interrupted chunk is dropped", []step{
+			{0, strings.NewReader("hello "), 6, nil},
+			{6, failingReader{strings.NewReader("wor")}, 6, errAny},
+			{6, strings.NewReader("world"), 11, nil},
+		}, "hello world"},
+		{"empty chunk", []step{
+			{0, strings.NewReader("hello"), 5, nil},
+			{5, strings.NewReader(""), 5, nil},
+		}, "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewLocal(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			for i, s := range tt.steps {
+				size, err := store.Append(ctx, "uploads/a", s.offset, s.chunk)
+				switch {
+				case s.wantErr == errAny && err == nil, s.wantErr != errAny && err != s.wantErr:
+					t.Fatalf("step %d: Append() error = %v, want %v", i, err, s.wantErr)
+				}
+				if size != s.wantSize {
+					t.Fatalf("step %d: Append() size = %d, want %d", i, size, s.wantSize)
+				}
+			}
+			if got := readAll(t, store, "uploads/a"); got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+			if size, err := store.Size(ctx, "uploads/a"); err != nil || size != int64(len(tt.wantContent)) {
+				t.Errorf("Size() = %d, %v, want %d", size, err, len(tt.wantContent))
+			}
+		})
+	}
+}
+
+func TestLocalKeys(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr error
+	}{
+		{"attachments/user-1/abc_def.jpg", nil},
+		{"plain", nil},
+		{"../escape", ErrInvalidKey},
+		{"a/../../escape", ErrInvalidKey},
+		{"a/./b", ErrInvalidKey},
+		{"/absolute", ErrInvalidKey},
+		{"trailing/", ErrInvalidKey},
+		{"a//b", ErrInvalidKey},
+		{"back\\slash", ErrInvalidKey},
+		{".hidden", ErrInvalidKey},
+		{"", ErrInvalidKey},
+	}
+
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if _, err := store.Put(ctx, tt.key, strings.NewReader("x")); err != tt.wantErr {
+				t.Fatalf("Put(%q) error = %v, want %v", tt.key, err, tt.wantErr)
+			}
+			if _, err := store.Append(ctx, tt.key, 0, strings.NewReader("x")); err != tt.wantErr {
+				t.Errorf("Append(%q) error = %v, want %v", tt.key, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocalMissing(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := store.Put(ctx, "kept", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put(ctx, "kept", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, store, "kept"); got != "new" {
+		t.Errorf("content after Put = %q, want %q", got, "new")
+	}
+	if err := store.Delete(ctx, "kept"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		op   func() error
+		want error
+	}{
+		{"open deleted", func() error { _, err := store.Open(ctx, "kept"); return err }, ErrNotFound},
+		{"size of missing", func() error { _, err := store.Size(ctx, "missing"); return err }, ErrNotFound},
+		{"delete missing", func() error { return store.Delete(ctx, "missing") }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); err != tt.want {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func readAll(t *testing.T, store Store, key string) string {
+	t.Helper()
+	r, err := store.Open(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
@@ -0,0 +1,42 @@
+// Package blob stores binary objects such as message attachments outside the database.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrOffset     = errors.New("blob offset beyond its size")
+	ErrInvalidKey = errors.New("invalid blob key")
+	validKey      = regexp.MustCompile(`^[A-Za-z0-9_-]+(/[A-Za-z0-9_.-]+)*$`)
+)
+
+// Store is a flat key-value store of blobs. Keys are slash-separated paths of letters,
+// digits, '_', '-' and '.'. Blobs can be written in sequential appends, so an interrupted
+// upload resumes where its owner last recorded progress.
+type Store interface {
+	// Append writes r to key at offset, creating it at offset 0, and returns the new size.
+	// Content beyond offset is discarded: the caller's recorded offset wins over bytes of a
+	// chunk it never recorded. Offsets past the current size fail with ErrOffset.
+	Append(ctx context.Context, key string, offset int64, r io.Reader) (int64, error)
+	// Put replaces key with the content of r.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Size returns the current length of key.
+	Size(ctx context.Context, key string) (int64, error)
+	// Open reads key. The caller closes the returned reader.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes key. Missing keys are not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+func checkKey(key string) error {
+	if !validKey.MatchString(key) || strings.Contains("/"+key+"/", "/../") || strings.Contains("/"+key+"/", "/./") {
+		return ErrInvalidKey
+	}
+	return nil
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"log"
 
+	"clipsync/backend/internal/atrest"
 	"clipsync/backend/internal/config"
 	"clipsync/backend/internal/models"
+	"clipsync/backend/internal/phone"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -34,6 +36,13 @@ func Initialize() (*gorm.DB, error) {
 	}
 	fmt.Println("Database connection successful!")
 
+	if err := atrest.Init(db, cfg); err != nil {
+		return nil, fmt.Errorf("failed to load at-rest keys: %w", err)
+	}
+	if !atrest.Enabled() {
+		log.Println("At-rest encryption disabled: clip and message content is stored as plaintext")
+	}
+
 	DB = db
 	return db, nil
 }
@@ -75,6 +84,34 @@ func RunMigrations(db *gorm.DB) error {
 	}
 	log.Println("SecureClip table migrated successfully")
 
+	log.Println("Migrating SecureClipVersion table...")
+	if err := db.AutoMigrate(&models.SecureClipVersion{}); err != nil {
+		log.Printf("Error migrating SecureClipVersion: %v", err)
+		return err
+	}
+	log.Println("SecureClipVersion table migrated successfully")
+
+	log.Println("Migrating VaultKeyWrapper table...")
+	if err := db.AutoMigrate(&models.VaultKeyWrapper{}); err != nil {
+		log.Printf("Error migrating VaultKeyWrapper: %v", err)
+		return err
+	}
+	log.Println("VaultKeyWrapper table migrated successfully")
+
+	log.Println("Migrating UserKeyPair table...")
+	if err := db.AutoMigrate(&models.UserKeyPair{}); err != nil {
+		log.Printf("Error migrating UserKeyPair: %v", err)
+		return err
+	}
+	log.Println("UserKeyPair table migrated successfully")
+
+	log.Println("Migrating SecureShare table...")
+	if err := db.AutoMigrate(&models.SecureShare{}); err != nil {
+		log.Printf("Error migrating SecureShare: %v", err)
+		return err
+	}
+	log.Println("SecureShare table migrated successfully")
+
 	log.Println("Migrating SyncedMessage table...")
 	if err := db.AutoMigrate(&models.SyncedMessage{}); err != nil {
 		log.Printf("Error migrating SyncedMessage: %v", err)
@@ -82,6 +119,140 @@ func RunMigrations(db *gorm.DB) error {
 	}
 	log.Println("SyncedMessage table migrated successfully")
 
+	// State changes made before the feed was ordered by transaction come first
+	if err := db.Exec("UPDATE synced_messages SET state_xid = 0 WHERE state_updated_at IS NOT NULL AND state_xid IS NULL").Error; err != nil {
+		log.Printf("Error backfilling message state order: %v", err)
+		return err
+	}
+
+	log.Println("Creating message search indexes...")
+	createMessageSearchIndexes(db)
+
+	log.Println("Backfilling message thread keys...")
+	if err := backfillThreadKeys(db); err != nil {
+		log.Printf("Error backfilling thread keys: %v", err)
+		return err
+	}
+
+	log.Println("Backfilling message fingerprints...")
+	if err := backfillMessageFingerprints(db); err != nil {
+		log.Printf("Error backfilling message fingerprints: %v", err)
+		return err
+	}
+
+	log.Println("Migrating RefreshToken table...")
+	if err := db.AutoMigrate(&models.RefreshToken{}); err != nil {
+		log.Printf("Error migrating RefreshToken: %v", err)
+		return err
+	}
+	log.Println("RefreshToken table migrated successfully")
+
+	log.Println("Migrating APIToken table...")
+	if err := db.AutoMigrate(&models.APIToken{}); err != nil {
+		log.Printf("Error migrating APIToken: %v", err)
+		return err
+	}
+	log.Println("APIToken table migrated successfully")
+
+	log.Println("Migrating UserDataKey table...")
+	if err := db.AutoMigrate(&models.UserDataKey{}); err != nil {
+		log.Printf("Error migrating UserDataKey: %v", err)
+		return err
+	}
+	log.Println("UserDataKey table migrated successfully")
+
+	log.Println("Migrating OTPCode table...")
+	if err := db.AutoMigrate(&models.OTPCode{}); err != nil {
+		log.Printf("Error migrating OTPCode: %v", err)
+		return err
+	}
+	log.Println("OTPCode table migrated successfully")
+
+	log.Println("Migrating OutboundMessage table...")
+	if err := db.AutoMigrate(&models.OutboundMessage{}); err != nil {
+		log.Printf("Error migrating OutboundMessage: %v", err)
+		return err
+	}
+	log.Println("OutboundMessage table migrated successfully")
+
+	log.Println("Migrating MessageSettings table...")
+	if err := db.AutoMigrate(&models.MessageSettings{}, &models.StarredThread{}); err != nil {
+		log.Printf("Error migrating MessageSettings: %v", err)
+		return err
+	}
+	log.Println("MessageSettings table migrated successfully")
+
+	log.Println("Migrating Notification table...")
+	if err := db.AutoMigrate(&models.Notification{}, &models.NotificationRule{}); err != nil {
+		log.Printf("Error migrating Notification: %v", err)
+		return err
+	}
+	log.Println("Notification table migrated successfully")
+
+	log.Println("Migrating MessageAttachment table...")
+	if err := db.AutoMigrate(&models.MessageAttachment{}); err != nil {
+		log.Printf("Error migrating MessageAttachment: %v", err)
+		return err
+	}
+	log.Println("MessageAttachment table migrated successfully")
+
+	log.Println("Migrating MessageTombstone table...")
+	if err := db.AutoMigrate(&models.MessageTombstone{}); err != nil {
+		log.Printf("Error migrating MessageTombstone: %v", err)
+		return err
+	}
+	log.Println("MessageTombstone table migrated successfully")
+
+	log.Println("Migrating Contact table...")
+	if err := db.AutoMigrate(&models.Contact{}, &models.ContactAddress{}); err != nil {
+		log.Printf("Error migrating Contact: %v", err)
+		return err
+	}
+	log.Println("Contact table migrated successfully")
+
 	log.Println("All migrations completed successfully!")
 	return nil
 }
+
+// backfillThreadKeys sets the thread key of messages synced before threads existed.
+func backfillThreadKeys(db *gorm.DB) error {
+	var addresses []string
+	if err := db.Model(&models.SyncedMessage{}).
+		Where("thread_key IS NULL OR thread_key = ''").
+		Distinct().Pluck("address", &addresses).Error; err != nil {
+		return err
+	}
+	region := config.Get().DefaultPhoneRegion
+	for _, address := range addresses {
+		if err := db.Model(&models.SyncedMessage{}).
+			Where("address = ? AND (thread_key IS NULL OR thread_key = '')", address).
+			Update("thread_key", phone.Normalize(address, region)).Error; err != nil {
+			return err
+		}
+	}
+	log.Printf("Backfilled thread keys for %d addresses", len(addresses))
+	return nil
+}
+
+// createMessageSearchIndexes adds trigram indexes for substring search on messages. They
+// need the pg_trgm extension; without it search still works, only slower. Bodies sealed at
+// rest are ciphertext, so their index is dropped while at-rest encryption is on.
+func createMessageSearchIndexes(db *gorm.DB) {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("pg_trgm unavailable, skipping message search indexes: %v", err)
+		return
+	}
+	columns := []string{"body", "sender", "address", "search_index"}
+	if atrest.Enabled() {
+		columns = columns[1:]
+		if err := db.Exec("DROP INDEX IF EXISTS idx_synced_messages_body_trgm").Error; err != nil {
+			log.Printf("Error dropping body search index: %v", err)
+		}
+	}
+	for _, column := range columns {
+		stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_synced_messages_%s_trgm ON synced_messages USING gin (%s gin_trgm_ops)", column, column)
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Error creating %s search index: %v", column, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const fingerprintBackfillBatch = 500
+
+// backfillMessageFingerprints moves messages to the current fingerprint scheme, so the first
+// re-sync after an upgrade matches them instead of inserting duplicates. Rows deduplicated by
+// a client fingerprint keep it, since the client value cannot be recovered from its hash.
+func backfillMessageFingerprints(db *gorm.DB) error {
+	key := []byte(config.Get().MessageFingerprintKey)
+	upgraded := 0
+	for {
+		var batch []models.SyncedMessage
+		if err := db.Where("fingerprint_version < ?", models.MessageFingerprintVersion).
+			Order("id").Limit(fingerprintBackfillBatch).Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, m := range batch {
+			updates := map[string]interface{}{"fingerprint_version": models.MessageFingerprintVersion}
+			if fingerprint, ok := upgradedFingerprint(key, &m); ok {
+				// Rows synced twice before deduplication would collide; the later copy keeps its old key
+				var taken int64
+				if err := db.Model(&models.SyncedMessage{}).
+					Where("user_id = ? AND fingerprint = ? AND id <> ?", m.UserID, fingerprint, m.ID).
+					Count(&taken).Error; err != nil {
+					return err
+				}
+				if taken == 0 {
+					updates["fingerprint"] = fingerprint
+					upgraded++
+				}
+			}
+			if err := db.Model(&models.SyncedMessage{}).Where("id = ?", m.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("Backfilled fingerprints of %d messages", upgraded)
+	return nil
+}
+
+// upgradedFingerprint returns the current fingerprint of a row whose stored one is missing
+// or was derived by the server under the first scheme.
+func upgradedFingerprint(key []byte, m *models.SyncedMessage) (string, bool) {
+	deviceMessageID := ""
+	if m.DeviceMessageID != nil {
+		deviceMessageID = *m.DeviceMessageID
+	}
+	if deviceMessageID == "" && m.Encrypted {
+		return "", false // Ciphertext differs per upload; only a client fingerprint identifies it
+	}
+	if m.Fingerprint != nil && *m.Fingerprint != legacyMessageFingerprint(m.DeviceID, deviceMessageID, m.Address, m.ReceivedAt.UnixMilli(), m.Body) {
+		return "", false
+	}
+	return models.MessageFingerprint(key, deviceMessageID, "", m.Address, m.ReceivedAt, m.Body), true
+}
+
+// legacyMessageFingerprint is the first, unkeyed scheme: native IDs scoped to the device,
+// otherwise address, receive time and a SHA-256 of the body.
+func legacyMessageFingerprint(deviceID, deviceMessageID, address string, receivedAtMillis int64, body string) string {
+	var key string
+	if deviceMessageID != "" {
+		key = "device\x00" + deviceID + "\x00" + deviceMessageID
+	} else {
+		bodyHash := sha256.Sum256([]byte(body))
+		key = "content\x00" + strings.ToLower(strings.TrimSpace(address)) + "\x00" +
+			strconv.FormatInt(receivedAtMillis, 10) + "\x00" + hex.EncodeToString(bodyHash[:])
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
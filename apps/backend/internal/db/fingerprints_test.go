@@ -0,0 +1,93 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"clipsync/backend/internal/models"
+)
+
+func TestUpgradedFingerprint(t *testing.T) {
+	key := []byte("fingerprint-key")
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ptr := func(s string) *string { return &s }
+	legacyNative := legacyMessageFingerprint("device-1", "sms-42", "+15551234567", at.UnixMilli(), "hi")
+	legacyContent := legacyMessageFingerprint("device-1", "", "+15551234567", at.UnixMilli(), "hi")
+
+	tests := []struct {
+		name    string
+		message models.SyncedMessage
+		want    string // "" when the stored fingerprint is kept
+	}{
+		{
+			name:    "legacy native ID",
+			message: models.SyncedMessage{DeviceID: "device-1", DeviceMessageID: ptr("sms-42"), Address: "+15551234567", ReceivedAt: at, Body: "hi", Fingerprint: &legacyNative},
+			want:    models.MessageFingerprint(key, "sms-42", "", "+15551234567", at, ""),
+		},
+		{
+			name:    "legacy content",
+			message: models.SyncedMessage{DeviceID: "device-1", Address: "+15551234567", ReceivedAt: at, Body: "hi", Fingerprint: &legacyContent},
+			want:    models.MessageFingerprint(key, "", "", "+15551234567", at, "hi"),
+		},
+		{
+			name:    "no fingerprint yet",
+			message: models.SyncedMessage{DeviceID: "device-1", Address: "+15551234567", ReceivedAt: at, Body: "hi"},
+			want:    models.MessageFingerprint(key, "", "", "+15551234567", at, "hi"),
+		},
+		{
+			name:    "client fingerprint",
+			message: models.SyncedMessage{DeviceID: "device-1", Address: "+15551234567", ReceivedAt: at, Body: "hi", Fingerprint: ptr("client-derived")},
+		},
+		{
+			name:    "legacy native ID of another device",
+			message: models.SyncedMessage{DeviceID: "device-2", DeviceMessageID: ptr("sms-42"), Address: "+15551234567", ReceivedAt: at, Body: "hi", Fingerprint: &legacyNative},
+		},
+		{
+			name:    "encrypted without native ID",
+			message: models.SyncedMessage{DeviceID: "device-1", Address: "+15551234567", ReceivedAt: at, Body: "ciphertext", Encrypted: true},
+		},
+		{
+			name:    "encrypted with native ID",
+			message: models.SyncedMessage{DeviceID: "device-1", DeviceMessageID: ptr("sms-42"), Address: "+15551234567", ReceivedAt: at, Body: "ciphertext", Encrypted: true},
+			want:    models.MessageFingerprint(key, "sms-42", "", "+15551234567", at, ""),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := upgradedFingerprint(key, &tt.message)
+			if ok != (tt.want != "") {
+				t.Fatalf("upgradedFingerprint() upgraded = %v, want %v", ok, tt.want != "")
+			}
+			if got != tt.want {
+				t.Errorf("upgradedFingerprint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacyMessageFingerprint(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC).UnixMilli()
+	base := legacyMessageFingerprint("device-1", "", "+15551234567", at, "hi")
+
+	tests := []struct {
+		name string
+		fp   string
+		same bool
+	}{
+		{"same content", legacyMessageFingerprint("device-1", "", "+15551234567", at, "hi"), true},
+		{"content ignores the device", legacyMessageFingerprint("device-2", "", "+15551234567", at, "hi"), true},
+		{"content normalises the address", legacyMessageFingerprint("device-1", "", " +15551234567 ", at, "hi"), true},
+		{"other body", legacyMessageFingerprint("device-1", "", "+15551234567", at, "hello"), false},
+		{"other time", legacyMessageFingerprint("device-1", "", "+15551234567", at+1, "hi"), false},
+		{"native ID", legacyMessageFingerprint("device-1", "sms-42", "+15551234567", at, "hi"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (tt.fp == base) != tt.same {
+				t.Errorf("equal = %v, want %v", tt.fp == base, tt.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Package dbtest fakes the database for tests of code that runs SQL through gorm. Each
+// test scripts the statements it expects; nothing needs a running Postgres.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Query answers the first statement containing Match, once.
+type Query struct {
+	Match    string
+	Columns  []string
+	Rows     [][]driver.Value
+	Affected int64
+	Err      error
+}
+
+// Statement is an executed statement with its arguments.
+type Statement struct {
+	SQL  string
+	Args []interface{}
+}
+
+// Log records the statements a fake database executed.
+type Log struct {
+	t      *testing.T
+	mu     sync.Mutex
+	script []Query
+	used   []bool
+	stmts  []Statement
+}
+
+// Statements returns the executed statements in order.
+func (l *Log) Statements() []Statement {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Statement(nil), l.stmts...)
+}
+
+// New returns a gorm handle whose statements are answered from script. Unscripted
+// statements, and scripted ones never executed, fail the test.
+func New(t *testing.T, script ...Query) (*gorm.DB, *Log) {
+	t.Helper()
+	l := &Log{t: t, script: script, used: make([]bool, len(script))}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(connector{l})}), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for i, q := range l.script {
+			if !l.used[i] {
+				t.Errorf("scripted statement %q was not executed", q.Match)
+			}
+		}
+	})
+	return db, l
+}
+
+func (l *Log) answer(query string, args []driver.NamedValue) (Query, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stmt := Statement{SQL: query}
+	for _, a := range args {
+		stmt.Args = append(stmt.Args, a.Value)
+	}
+	l.stmts = append(l.stmts, stmt)
+	for i, q := range l.script {
+		if !l.used[i] && strings.Contains(query, q.Match) {
+			l.used[i] = true
+			return q, q.Err
+		}
+	}
+	l.t.Errorf("unexpected statement: %s", query)
+	return Query{}, errors.New("unexpected statement")
+}
+
+type connector struct{ log *Log }
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return conn{c.log}, nil }
+func (c connector) Driver() driver.Driver                        { return nil }
+
+type conn struct{ log *Log }
+
+func (c conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, err := c.log.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{columns: q.Columns, rows: q.Rows}, nil
+}
+
+func (c conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	q, err := c.log.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(q.Affected), nil
+}
+
+func (c conn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("dbtest: prepare not supported")
+}
+func (c conn) Close() error              { return nil }
+func (c conn) Begin() (driver.Tx, error) { return tx{}, nil }
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,69 @@
+// Package events fans out per-user notifications to connected clients (see the SSE
+// endpoint in handlers.EventsHandler). Delivery is best effort and in-process only.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is how many events a slow client may lag behind before events are dropped.
+const subscriberBuffer = 32
+
+// Event is one notification. Scope is the API token scope needed to receive it.
+type Event struct {
+	Type  string      `json:"type"`
+	Scope string      `json:"-"`
+	Data  interface{} `json:"data"`
+	At    time.Time   `json:"at"`
+}
+
+// Hub keeps the live subscriptions of every user.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan Event]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[string]map[chan Event]struct{})}
+}
+
+// Subscribe registers a listener for userID. Call the returned function to unsubscribe.
+func (h *Hub) Subscribe(userID string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[chan Event]struct{})
+	}
+	h.subs[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[userID][ch]; ok {
+			delete(h.subs[userID], ch)
+			if len(h.subs[userID]) == 0 {
+				delete(h.subs, userID)
+			}
+			close(ch)
+		}
+	}
+}
+
+// Publish delivers e to every subscriber of userID without blocking.
+func (h *Hub) Publish(userID string, e Event) {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[userID] {
+		select {
+		case ch <- e:
+		default: // Subscriber is not keeping up
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package media inspects uploaded attachments and renders image thumbnails.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"strings"
+
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// ThumbnailSize bounds the longer side of a thumbnail in pixels.
+	ThumbnailSize = 320
+	// maxPixels keeps decoding of hostile images (decompression bombs) bounded.
+	maxPixels = 40_000_000
+)
+
+var ErrTooLarge = errors.New("image dimensions too large")
+
+// DetectType sniffs the MIME type of content from its first bytes.
+func DetectType(r io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(head[:n]), ";")
+	return mimeType, nil
+}
+
+// IsImage reports whether a thumbnail can be rendered for mimeType.
+func IsImage(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Thumbnail decodes an image and returns a JPEG scaled to fit ThumbnailSize, with the
+// original dimensions.
+func Thumbnail(r io.ReadSeeker) (thumb []byte, width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, 0, 0, ErrTooLarge
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scale(src, ThumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), cfg.Width, cfg.Height, nil
+}
+
+// scale shrinks src to fit within size x size by averaging the source pixels behind each
+// destination pixel. Smaller images are only flattened onto white.
+func scale(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if w > size || h > size {
+		if w >= h {
+			dw, dh = size, max(1, h*size/w)
+		} else {
+			dw, dh = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*w/dw, b.Min.X+max((x+1)*w/dw, x*w/dw+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			// Composite premultiplied color over white, since JPEG has no alpha
+			white := 0xffff*n - a
+			dst.Set(x, y, color.RGBA64{
+				R: uint16((r + white) / n),
+				G: uint16((g + white) / n),
+				B: uint16((bl + white) / n),
+				A: 0xffff,
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// API token scopes. Tokens never reach the secure vault; secure:none states that explicitly
+// and is checked on the vault routes on its own, independent of the full-access rule.
+const (
+	ScopeClipsRead          = "clips:read"
+	ScopeClipsWrite         = "clips:write"
+	ScopeMessagesRead       = "messages:read"
+	ScopeMessagesWrite      = "messages:write"
+	ScopeNotificationsRead  = "notifications:read"
+	ScopeNotificationsWrite = "notifications:write"
+	ScopeContactsRead       = "contacts:read"
+	ScopeContactsWrite      = "contacts:write"
+	ScopeSecureNone         = "secure:none"
+)
+
+var ValidScopes = []string{ScopeClipsRead, ScopeClipsWrite, ScopeMessagesRead, ScopeMessagesWrite, ScopeNotificationsRead, ScopeNotificationsWrite, ScopeContactsRead, ScopeContactsWrite, ScopeSecureNone}
+
+// APIToken is a user-managed personal access token for scripts and CI machines.
+// Only the SHA-256 hash of the token is stored; Prefix is kept to identify it in lists.
+type APIToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID     string     `gorm:"type:varchar(255);not null;index" json:"userId"`
+	Name       string     `gorm:"type:varchar(100);not null" json:"name"`
+	Prefix     string     `gorm:"type:varchar(16);not null" json:"prefix"`
+	TokenHash  string     `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`
+	Scopes     string     `gorm:"type:text;not null" json:"-"` // Space-separated scope list
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+func (t *APIToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+func (t *APIToken) IsExpired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+func IsValidScope(scope string) bool {
+	for _, s := range ValidScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -10,14 +10,19 @@ import (
 type Clip struct {
 	ID            uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	UserID        string    `gorm:"type:varchar(255);not null;index" json:"userId"` // Better-Auth user ID (string)
-	Content       string    `gorm:"type:text;not null" json:"content"`
-	ContentPreview string   `gorm:"type:varchar(200)" json:"contentPreview"`
+	Content       string    `gorm:"type:text;not null;serializer:atrest" json:"content"` // Sealed at rest when configured
+	ContentPreview string   `gorm:"type:text;serializer:atrest" json:"contentPreview"`   // First 200 bytes of content
 	CopiedAt      time.Time `gorm:"not null" json:"copiedAt"`
 	IsFavorite    bool      `gorm:"default:false" json:"isFavorite"`
 	IsPinned      bool      `gorm:"default:false;index" json:"isPinned"`
 	Tags          []string  `gorm:"type:text[]" json:"tags"`
 	DeviceName    *string   `json:"deviceName"`
 	Synced        bool      `gorm:"default:false" json:"synced"`
+	Encrypted     bool      `gorm:"default:false;index" json:"encrypted"` // Content is client-side ciphertext under the vault key (E2E mode)
+	Nonce         *string   `gorm:"type:varchar(32)" json:"nonce,omitempty"`
+	BlindIndex    string    `gorm:"type:text" json:"-"` // Space-separated client-computed search tokens for encrypted content
+	SearchIndex   string    `gorm:"type:text" json:"-"` // Space-separated server search tokens while content is sealed at rest
+	ExpiresAt     *time.Time `gorm:"index" json:"expiresAt,omitempty"` // Short-lived clips (e.g. one-time codes) are hidden after this
 	CreatedAt     time.Time `json:"createdAt"`
 	UpdatedAt     time.Time `json:"updatedAt"`
 }
@@ -30,7 +35,10 @@ func (c *Clip) BeforeCreate(tx *gorm.DB) error {
 	if c.ID == uuid.Nil {
 		c.ID = uuid.New()
 	}
-	if c.ContentPreview == "" && len(c.Content) > 200 {
+	if c.Encrypted {
+		// The server cannot build a preview of ciphertext
+		c.ContentPreview = ""
+	} else if c.ContentPreview == "" && len(c.Content) > 200 {
 		c.ContentPreview = c.Content[:200]
 	} else if c.ContentPreview == "" {
 		c.ContentPreview = c.Content
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Contact address kinds.
+const (
+	ContactPhone = "phone"
+	ContactEmail = "email"
+)
+
+// Contact is an address book entry pushed from the user's phone. Key is the phone's
+// stable lookup key. Deleted contacts stay as tombstones so other clients can sync the
+// deletion incrementally.
+type Contact struct {
+	ID          uuid.UUID        `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID      string           `gorm:"type:varchar(255);not null;uniqueIndex:idx_contacts_user_key,priority:1;index:idx_contacts_user_updated,priority:1" json:"-"`
+	Key         string           `gorm:"column:contact_key;type:varchar(255);not null;uniqueIndex:idx_contacts_user_key,priority:2" json:"key"`
+	DeviceID    string           `gorm:"type:varchar(255)" json:"deviceId"`
+	DisplayName string           `gorm:"type:text;serializer:atrest" json:"displayName"`
+	SearchIndex string           `gorm:"type:text" json:"-"` // Keyed word hashes of the name while sealed at rest
+	Addresses   []ContactAddress `gorm:"foreignKey:ContactID;constraint:OnDelete:CASCADE" json:"addresses"`
+	PhotoKey    *string          `gorm:"type:varchar(255)" json:"-"`                  // Blob store key of the photo
+	PhotoHash   string           `gorm:"type:varchar(64)" json:"photoHash,omitempty"` // SHA-256 of the photo, so the phone can skip re-sending it
+	Generation  string           `gorm:"type:varchar(64)" json:"-"`                   // Full sync that last saw the contact
+	CreatedAt   time.Time        `json:"createdAt"`
+	UpdatedAt   time.Time        `gorm:"index:idx_contacts_user_updated,priority:2" json:"updatedAt"`
+	DeletedAt   gorm.DeletedAt   `gorm:"index" json:"deletedAt,omitempty"`
+
+	PhotoURL string `gorm:"-" json:"photoUrl,omitempty"` // Signed download URL, set by handlers
+}
+
+func (Contact) TableName() string {
+	return "contacts"
+}
+
+func (c *Contact) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// ContactAddress is a phone number or email of a contact. ThreadKey is the address
+// normalized like message addresses, which is how senders are matched to contacts.
+type ContactAddress struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"-"`
+	ContactID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	UserID    string    `gorm:"type:varchar(255);not null;index:idx_contact_addresses_thread,priority:1" json:"-"`
+	Kind      string    `gorm:"type:varchar(8);not null" json:"kind"`
+	Value     string    `gorm:"type:varchar(255);not null" json:"value"`
+	Label     string    `gorm:"type:varchar(64)" json:"label,omitempty"` // "mobile", "work", ...
+	ThreadKey string    `gorm:"type:varchar(255);not null;index:idx_contact_addresses_thread,priority:2" json:"threadKey"`
+}
+
+func (ContactAddress) TableName() string {
+	return "contact_addresses"
+}
+
+func (a *ContactAddress) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
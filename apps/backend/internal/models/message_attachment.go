@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Attachment upload states. Attachments are announced with their message and uploaded
+// afterwards in chunks; they become ready once Size bytes have arrived.
+const (
+	AttachmentUploading = "uploading"
+	AttachmentReady     = "ready"
+)
+
+// MessageAttachment is a photo or other MMS part of a synced message. Content lives in the
+// blob store under StorageKey, not in the database.
+type MessageAttachment struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID       string     `gorm:"type:varchar(255);not null;index" json:"-"`
+	MessageID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"messageId"`
+	Position     int        `gorm:"not null;default:0" json:"position"` // Order within the message
+	FileName     string     `gorm:"type:varchar(255)" json:"fileName"`
+	MimeType     string     `gorm:"type:varchar(127);not null" json:"mimeType"`
+	Size         int64      `gorm:"not null" json:"size"`
+	UploadedSize int64      `gorm:"not null;default:0" json:"uploadedSize"`
+	Status       string     `gorm:"type:varchar(16);not null;index" json:"status"`
+	StorageKey   string     `gorm:"type:varchar(255);not null" json:"-"`
+	ThumbnailKey *string    `gorm:"type:varchar(255)" json:"-"`
+	Width        int        `json:"width,omitempty"`
+	Height       int        `json:"height,omitempty"`
+	Encrypted    bool       `gorm:"default:false" json:"encrypted"` // Content is ciphertext (E2E mode)
+	Nonce        *string    `gorm:"type:varchar(32)" json:"nonce,omitempty"`
+	WrappedKey   *string    `gorm:"type:text" json:"wrappedKey,omitempty"` // Content key under the vault key; nil when the content is under the vault key itself
+	KeyNonce     *string    `gorm:"type:varchar(32)" json:"keyNonce,omitempty"`
+	CompletedAt  *time.Time `json:"completedAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+
+	// Signed download URLs, set by handlers
+	URL          string     `gorm:"-" json:"url,omitempty"`
+	ThumbnailURL string     `gorm:"-" json:"thumbnailUrl,omitempty"`
+	URLExpiresAt *time.Time `gorm:"-" json:"urlExpiresAt,omitempty"`
+}
+
+func (MessageAttachment) TableName() string {
+	return "message_attachments"
+}
+
+func (a *MessageAttachment) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	if a.Status == "" {
+		a.Status = AttachmentUploading
+	}
+	if a.StorageKey == "" {
+		a.StorageKey = "attachments/" + a.ID.String()
+	}
+	return nil
+}
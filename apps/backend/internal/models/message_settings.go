@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MessageSettings holds a user's message retention overrides. A nil limit falls back to the
+// server default; 0 keeps messages regardless of that limit.
+type MessageSettings struct {
+	UserID         string    `gorm:"type:varchar(255);primary_key" json:"-"`
+	RetentionCount *int      `json:"retentionCount"` // Newest messages kept outside starred threads
+	RetentionDays  *int      `json:"retentionDays"`  // Messages received longer ago are deleted, outside starred threads
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+func (MessageSettings) TableName() string {
+	return "message_settings"
+}
+
+// StarredThread exempts a conversation from message retention.
+type StarredThread struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_starred_threads_user_thread" json:"-"`
+	ThreadKey string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_starred_threads_user_thread" json:"threadKey"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (StarredThread) TableName() string {
+	return "starred_threads"
+}
+
+func (s *StarredThread) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// MessageTombstone remembers the fingerprint of a message deleted by the user or by
+// retention, so the next re-sync from the phone does not bring it back.
+type MessageTombstone struct {
+	UserID      string    `gorm:"type:varchar(255);primaryKey" json:"-"`
+	Fingerprint string    `gorm:"type:varchar(64);primaryKey" json:"-"`
+	ThreadKey   string    `gorm:"type:varchar(255);not null;default:''" json:"threadKey"`
+	ReceivedAt  time.Time `gorm:"not null;index" json:"receivedAt"` // Lets retention drop tombstones past its cutoff
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func (MessageTombstone) TableName() string {
+	return "message_tombstones"
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Notification rule actions. A rule for AppPackage "*" sets the default for apps without
+// their own rule; without any rule every app is mirrored.
+const (
+	NotificationAllow = "allow"
+	NotificationDeny  = "deny"
+
+	NotificationRuleDefault = "*"
+)
+
+// NotificationAction is a button on an Android notification.
+type NotificationAction struct {
+	Key        string `json:"key"`
+	Title      string `json:"title"`
+	AllowsText bool   `json:"allowsText,omitempty"` // Inline reply
+}
+
+// Notification is an app notification mirrored from the user's phone. Key is the Android
+// notification key and identifies the notification across updates on that device.
+type Notification struct {
+	ID          uuid.UUID            `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID      string               `gorm:"type:varchar(255);not null;uniqueIndex:idx_notifications_key,priority:1;index:idx_notifications_user_posted,priority:1" json:"userId"`
+	DeviceID    string               `gorm:"type:varchar(255);not null;uniqueIndex:idx_notifications_key,priority:2" json:"deviceId"`
+	Key         string               `gorm:"column:notification_key;type:varchar(255);not null;uniqueIndex:idx_notifications_key,priority:3" json:"key"`
+	AppPackage  string               `gorm:"type:varchar(255);not null;index" json:"appPackage"`
+	AppName     string               `gorm:"type:varchar(255)" json:"appName"`
+	Title       string               `gorm:"type:text;serializer:atrest" json:"title"`
+	Text        string               `gorm:"type:text;serializer:atrest" json:"text"` // Ciphertext of title and text when encrypted
+	Category    string               `gorm:"type:varchar(64)" json:"category,omitempty"`
+	Actions     []NotificationAction `gorm:"type:jsonb;serializer:json" json:"actions"`
+	Encrypted   bool                 `gorm:"default:false" json:"encrypted"`
+	Nonce       *string              `gorm:"type:varchar(32)" json:"nonce,omitempty"`
+	PostedAt    time.Time            `gorm:"not null;index:idx_notifications_user_posted,priority:2" json:"postedAt"`
+	RemovedAt   *time.Time           `gorm:"index" json:"removedAt"`   // Cleared on the phone
+	DismissedAt *time.Time           `gorm:"index" json:"dismissedAt"` // Dismissed on another device; the phone clears it next
+	CreatedAt   time.Time            `json:"createdAt"`
+	UpdatedAt   time.Time            `json:"updatedAt"`
+}
+
+func (Notification) TableName() string {
+	return "notifications"
+}
+
+func (n *Notification) BeforeCreate(tx *gorm.DB) error {
+	if n.ID == uuid.Nil {
+		n.ID = uuid.New()
+	}
+	return nil
+}
+
+// Active reports whether the notification is still shown on the phone and not dismissed.
+func (n *Notification) Active() bool {
+	return n.RemovedAt == nil && n.DismissedAt == nil
+}
+
+// NotificationRule allows or denies mirroring an app's notifications.
+type NotificationRule struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID     string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_notification_rules_app" json:"-"`
+	AppPackage string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_notification_rules_app" json:"appPackage"`
+	Action     string    `gorm:"type:varchar(8);not null" json:"action"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+func (NotificationRule) TableName() string {
+	return "notification_rules"
+}
+
+func (r *NotificationRule) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
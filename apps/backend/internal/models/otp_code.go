@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OTPCode is a one-time code extracted from a synced SMS, kept until it expires.
+type OTPCode struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID     string    `gorm:"type:varchar(255);not null;index" json:"userId"`
+	MessageID  uuid.UUID `gorm:"type:uuid;not null;index" json:"messageId"`
+	Code       string    `gorm:"type:text;not null;serializer:atrest" json:"code"`
+	Sender     string    `gorm:"type:varchar(255)" json:"sender"`
+	ThreadKey  string    `gorm:"type:varchar(255)" json:"threadKey"`
+	ReceivedAt time.Time `gorm:"not null" json:"receivedAt"`
+	ExpiresAt  time.Time `gorm:"not null;index" json:"expiresAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (OTPCode) TableName() string {
+	return "otp_codes"
+}
+
+func (o *OTPCode) BeforeCreate(tx *gorm.DB) error {
+	if o.ID == uuid.Nil {
+		o.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Outbound message states. Queued messages are claimed by the phone, which reports them
+// sent or failed; failures are retried until MaxAttempts, and anything still undelivered
+// at ExpiresAt is expired.
+const (
+	OutboundQueued    = "queued"
+	OutboundClaimed   = "claimed"
+	OutboundSent      = "sent"
+	OutboundFailed    = "failed"
+	OutboundExpired   = "expired"
+	OutboundCancelled = "cancelled"
+)
+
+// OutboundMessage is an SMS composed on another client and relayed through the user's phone.
+type OutboundMessage struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID          string     `gorm:"type:varchar(255);not null;index:idx_outbound_queue,priority:1;uniqueIndex:idx_outbound_client_request,priority:1" json:"userId"`
+	DeviceID        string     `gorm:"type:varchar(255);not null;index:idx_outbound_queue,priority:2" json:"deviceId"` // Phone that sends it
+	Address         string     `gorm:"type:varchar(255);not null" json:"address"`
+	ThreadKey       string     `gorm:"type:varchar(255);not null;index:idx_outbound_queue,priority:3" json:"threadKey"`
+	Body            string     `gorm:"type:text;not null;serializer:atrest" json:"body"`
+	Encrypted       bool       `gorm:"default:false" json:"encrypted"` // Body is ciphertext under the vault key (E2E mode)
+	Nonce           *string    `gorm:"type:varchar(32)" json:"nonce,omitempty"`
+	ClientRequestID *string    `gorm:"type:varchar(64);uniqueIndex:idx_outbound_client_request,priority:2" json:"clientRequestId,omitempty"` // Makes enqueue retries idempotent
+	Status          string     `gorm:"type:varchar(16);not null;index" json:"status"`
+	Attempts        int        `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts     int        `gorm:"not null" json:"maxAttempts"`
+	Error           string     `gorm:"type:text" json:"error,omitempty"` // Last failure reported by the phone
+	ClaimedAt       *time.Time `json:"claimedAt,omitempty"`
+	LeaseExpiresAt  *time.Time `json:"leaseExpiresAt,omitempty"` // A claim not reported by then is retried
+	SentAt          *time.Time `json:"sentAt,omitempty"`
+	ExpiresAt       time.Time  `gorm:"not null" json:"expiresAt"`
+	CreatedAt       time.Time  `gorm:"index:idx_outbound_queue,priority:4" json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+func (OutboundMessage) TableName() string {
+	return "outbound_messages"
+}
+
+func (m *OutboundMessage) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	if m.Status == "" {
+		m.Status = OutboundQueued
+	}
+	return nil
+}
+
+// Pending reports whether the message may still be delivered.
+func (m *OutboundMessage) Pending() bool {
+	return m.Status == OutboundQueued || m.Status == OutboundClaimed
+}
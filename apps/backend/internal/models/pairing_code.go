@@ -13,6 +13,8 @@ type PairingCode struct {
 	UserID    string    `gorm:"type:varchar(255);not null;index" json:"userId"`
 	ExpiresAt time.Time `gorm:"not null;index" json:"expiresAt"`
 	Used      bool      `gorm:"default:false;index" json:"used"`
+	Nonce     *string   `gorm:"type:varchar(64);uniqueIndex" json:"-"`       // Single-use nonce bound into the signed QR token
+	PublicKey *string   `gorm:"type:varchar(64)" json:"publicKey,omitempty"` // Base64 X25519 ephemeral key of the issuing device
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
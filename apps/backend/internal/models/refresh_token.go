@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RefreshToken is one link in a rotating refresh token chain. Every refresh marks the
+// presented token used and issues a new one in the same family; presenting a used token
+// again means it was stolen, so the whole family is revoked.
+// Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    string     `gorm:"type:varchar(255);not null;index" json:"userId"`
+	FamilyID  uuid.UUID  `gorm:"type:uuid;not null;index" json:"familyId"` // Shared by all rotations of one device login
+	DeviceID  string     `gorm:"type:varchar(255)" json:"deviceId"`
+	TokenHash string     `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"` // Hex SHA-256 of the opaque token
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	RevokedAt *time.Time `gorm:"index" json:"revokedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+func (r *RefreshToken) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (r *RefreshToken) IsExpired() bool {
+	return time.Now().After(r.ExpiresAt)
+}
@@ -7,14 +7,39 @@ import (
 	"gorm.io/gorm"
 )
 
+// Common secure clip type tags. Clients may use others; the tag is only used for filtering.
+const (
+	SecureItemNote     = "note"
+	SecureItemPassword = "password"
+	SecureItemCard     = "card"
+	SecureItemTOTP     = "totp"
+)
+
 // SecureClip stores encrypted password/secret data. Decryption happens client-side only.
+// The optional metadata blob (title, category, URL, username) is encrypted separately so
+// lists can be rendered without downloading and decrypting every payload.
 type SecureClip struct {
-	ID               uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	UserID           string    `gorm:"type:varchar(255);not null;index" json:"userId"`
-	EncryptedPayload string    `gorm:"type:text;not null" json:"encryptedPayload"` // Base64 AES-GCM ciphertext
-	Nonce            string    `gorm:"type:varchar(32);not null" json:"nonce"`     // Base64 96-bit nonce
-	CreatedAt        time.Time `json:"createdAt"`
-	UpdatedAt        time.Time `json:"updatedAt"`
+	ID                uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID            string    `gorm:"type:varchar(255);not null;index" json:"userId"`
+	ItemType          string    `gorm:"type:varchar(32);not null;default:'note';index" json:"itemType"` // Plaintext, client-chosen type tag
+	EncryptedPayload  string    `gorm:"type:text;not null" json:"encryptedPayload"`                     // Base64 AES-GCM ciphertext
+	Nonce             string    `gorm:"type:varchar(32);not null" json:"nonce"`                         // Base64 96-bit nonce
+	EncryptedMetadata *string   `gorm:"type:text" json:"encryptedMetadata"`                             // Base64 AES-GCM ciphertext of a JSON metadata object
+	MetadataNonce     *string   `gorm:"type:varchar(32)" json:"metadataNonce"`                          // Base64 96-bit nonce
+	Revision          int       `gorm:"not null;default:1" json:"revision"`                             // Bumped on every ciphertext change, for optimistic concurrency
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// SecureClipSummary is the list view of a secure clip: everything except the payload.
+type SecureClipSummary struct {
+	ID                uuid.UUID `json:"id"`
+	ItemType          string    `json:"itemType"`
+	EncryptedMetadata *string   `json:"encryptedMetadata"`
+	MetadataNonce     *string   `json:"metadataNonce"`
+	Revision          int       `json:"revision"`
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
 }
 
 func (SecureClip) TableName() string {
@@ -25,5 +50,16 @@ func (s *SecureClip) BeforeCreate(tx *gorm.DB) error {
 	if s.ID == uuid.Nil {
 		s.ID = uuid.New()
 	}
+	if s.Revision == 0 {
+		s.Revision = 1
+	}
+	if s.ItemType == "" {
+		s.ItemType = SecureItemNote
+	}
 	return nil
 }
+
+// HasMetadata reports whether the clip carries an encrypted metadata blob.
+func (s *SecureClip) HasMetadata() bool {
+	return s.EncryptedMetadata != nil && *s.EncryptedMetadata != ""
+}
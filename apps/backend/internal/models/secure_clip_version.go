@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SecureClipVersion is an append-only snapshot of a secure clip's ciphertext before it was
+// overwritten. Like the clip itself it is only decryptable client-side.
+type SecureClipVersion struct {
+	ID                uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	SecureClipID      uuid.UUID `gorm:"type:uuid;not null;index" json:"secureClipId"`
+	UserID            string    `gorm:"type:varchar(255);not null;index" json:"userId"`
+	Revision          int       `gorm:"not null" json:"revision"` // Revision of the clip this snapshot was taken from
+	ItemType          string    `gorm:"type:varchar(32);not null" json:"itemType"`
+	EncryptedPayload  string    `gorm:"type:text;not null" json:"encryptedPayload"`
+	Nonce             string    `gorm:"type:varchar(32);not null" json:"nonce"`
+	EncryptedMetadata *string   `gorm:"type:text" json:"encryptedMetadata"`
+	MetadataNonce     *string   `gorm:"type:varchar(32)" json:"metadataNonce"`
+	CreatedAt         time.Time `json:"createdAt"` // When the snapshot was replaced
+}
+
+func (SecureClipVersion) TableName() string {
+	return "secure_clip_versions"
+}
+
+func (v *SecureClipVersion) BeforeCreate(tx *gorm.DB) error {
+	if v.ID == uuid.Nil {
+		v.ID = uuid.New()
+	}
+	return nil
+}
+
+// NewSecureClipVersion snapshots the current ciphertext of a clip.
+func NewSecureClipVersion(clip *SecureClip) *SecureClipVersion {
+	return &SecureClipVersion{
+		SecureClipID:      clip.ID,
+		UserID:            clip.UserID,
+		Revision:          clip.Revision,
+		ItemType:          clip.ItemType,
+		EncryptedPayload:  clip.EncryptedPayload,
+		Nonce:             clip.Nonce,
+		EncryptedMetadata: clip.EncryptedMetadata,
+		MetadataNonce:     clip.MetadataNonce,
+	}
+}
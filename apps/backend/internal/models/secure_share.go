@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserKeyPair is a user's X25519 key pair for receiving shared secure clips. The private
+// key is encrypted client-side under the vault key; the server only stores ciphertext.
+type UserKeyPair struct {
+	ID                  uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID              string    `gorm:"type:varchar(255);not null;uniqueIndex" json:"userId"`
+	PublicKey           string    `gorm:"type:varchar(64);not null" json:"publicKey"`       // Base64 X25519 public key
+	EncryptedPrivateKey string    `gorm:"type:text;not null" json:"encryptedPrivateKey"`    // Base64 AES-GCM ciphertext under the vault key
+	PrivateKeyNonce     string    `gorm:"type:varchar(32);not null" json:"privateKeyNonce"` // Base64 96-bit nonce
+	CreatedAt           time.Time `json:"createdAt"`
+	UpdatedAt           time.Time `json:"updatedAt"`
+}
+
+func (UserKeyPair) TableName() string {
+	return "user_key_pairs"
+}
+
+func (k *UserKeyPair) BeforeCreate(tx *gorm.DB) error {
+	if k.ID == uuid.Nil {
+		k.ID = uuid.New()
+	}
+	return nil
+}
+
+// SecureShare is a copy of a secure clip encrypted to another user's public key.
+// The client derives the shared key from X25519(sender private, recipient public);
+// both public keys are recorded so the recipient can derive it and detect stale shares.
+type SecureShare struct {
+	ID                 uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	SenderID           string     `gorm:"type:varchar(255);not null;index" json:"senderId"`
+	RecipientID        string     `gorm:"type:varchar(255);not null;index" json:"recipientId"`
+	SourceClipID       *uuid.UUID `gorm:"type:uuid;index" json:"sourceClipId"` // Sender's clip this was shared from, if any
+	SenderPublicKey    string     `gorm:"type:varchar(64);not null" json:"senderPublicKey"`
+	RecipientPublicKey string     `gorm:"type:varchar(64);not null" json:"recipientPublicKey"`
+	ItemType           string     `gorm:"type:varchar(32);not null;default:'note'" json:"itemType"`
+	EncryptedPayload   string     `gorm:"type:text;not null" json:"encryptedPayload,omitempty"`
+	Nonce              string     `gorm:"type:varchar(32);not null" json:"nonce,omitempty"`
+	EncryptedMetadata  *string    `gorm:"type:text" json:"encryptedMetadata,omitempty"`
+	MetadataNonce      *string    `gorm:"type:varchar(32)" json:"metadataNonce,omitempty"`
+	CreatedAt          time.Time  `json:"createdAt"`
+}
+
+func (SecureShare) TableName() string {
+	return "secure_shares"
+}
+
+func (s *SecureShare) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	if s.ItemType == "" {
+		s.ItemType = SecureItemNote
+	}
+	return nil
+}
@@ -1,6 +1,11 @@
 package models
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,13 +15,30 @@ import (
 // SyncedMessage stores SMS/messages synced from the user's phone (Android only).
 type SyncedMessage struct {
 	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	UserID     string    `gorm:"type:varchar(255);not null;index" json:"userId"`
-	Body       string    `gorm:"type:text;not null" json:"body"`
+	UserID     string    `gorm:"type:varchar(255);not null;index;uniqueIndex:idx_synced_messages_user_fingerprint,priority:1;index:idx_synced_messages_thread,priority:1;index:idx_synced_messages_user_received,priority:1;index:idx_synced_messages_user_device,priority:1" json:"userId"`
+	Body       string    `gorm:"type:text;not null;serializer:atrest" json:"body"` // Sealed at rest when configured
 	Sender     string    `gorm:"type:varchar(255)" json:"sender"`      // phone number or name
 	Address    string    `gorm:"type:varchar(255);index" json:"address"` // canonical address (e.g. phone)
-	ReceivedAt time.Time `gorm:"not null;index" json:"receivedAt"`     // when message was received on device
-	DeviceID   string    `gorm:"type:varchar(255)" json:"deviceId"`
+	ThreadKey  string    `gorm:"type:varchar(255);index:idx_synced_messages_thread,priority:2" json:"threadKey"` // Normalized address (E.164 for phone numbers)
+	ReceivedAt time.Time `gorm:"not null;index;index:idx_synced_messages_thread,priority:3;index:idx_synced_messages_user_received,priority:2;index:idx_synced_messages_user_device,priority:3" json:"receivedAt"`     // when message was received on device
+	ReadAt     *time.Time `gorm:"index" json:"readAt"` // nil while unread
+	ArchivedAt *time.Time `gorm:"index" json:"archivedAt"` // nil unless archived
+	StateUpdatedAt *time.Time `gorm:"index" json:"stateUpdatedAt"` // Last read/archive change, for syncing state between devices
+	StateXID *int64 `gorm:"column:state_xid;index" json:"-"` // Transaction of the last state change; orders the state feed by commit
+	DeviceID   string    `gorm:"type:varchar(255);index:idx_synced_messages_user_device,priority:2" json:"deviceId"`
+	DeviceMessageID *string `gorm:"type:varchar(255)" json:"deviceMessageId,omitempty"` // Native ID from the phone's SMS provider
+	// Fingerprint identifies the message across re-syncs (see MessageFingerprint); unique per
+	// user. The migration backfills rows synced before deduplication or under an older scheme.
+	Fingerprint *string `gorm:"type:varchar(64);uniqueIndex:idx_synced_messages_user_fingerprint,priority:2" json:"-"`
+	FingerprintVersion int `gorm:"not null;default:0" json:"-"` // Scheme of Fingerprint; see MessageFingerprintVersion
+	Encrypted  bool      `gorm:"default:false;index" json:"encrypted"` // Body is client-side ciphertext under the vault key (E2E mode)
+	Nonce      *string   `gorm:"type:varchar(32)" json:"nonce,omitempty"`
+	BlindIndex string    `gorm:"type:text" json:"-"` // Space-separated client-computed search tokens for encrypted bodies
+	SearchIndex string   `gorm:"type:text" json:"-"` // Space-separated server search tokens while the body is sealed at rest
 	CreatedAt  time.Time `json:"createdAt"`
+	Attachments []MessageAttachment `gorm:"-" json:"attachments,omitempty"` // Loaded by handlers, with signed URLs
+	SenderName  string `gorm:"-" json:"senderName,omitempty"` // Contact name of the address, resolved at read time
+	ContactID   string `gorm:"-" json:"contactId,omitempty"`
 }
 
 func (SyncedMessage) TableName() string {
@@ -29,3 +51,31 @@ func (m *SyncedMessage) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// MessageFingerprintVersion is the fingerprint scheme of rows written by MessageFingerprint;
+// the migration moves older rows to it.
+const MessageFingerprintVersion = 2
+
+// MessageFingerprint derives the deduplication key of a synced message. The phone's native
+// message ID is preferred, combined with address and receive time rather than the device ID,
+// so a reinstall (which registers a new device) still matches the messages it synced before.
+// Otherwise a client-supplied fingerprint is used (required for E2E bodies, whose ciphertext
+// differs on every upload), and as a last resort address, receive time and the plaintext
+// body. Server-derived keys are HMACed with key so short bodies such as one-time codes
+// cannot be brute-forced from a database dump; client fingerprints are opaque already.
+func MessageFingerprint(key []byte, deviceMessageID, clientFingerprint, address string, receivedAt time.Time, body string) string {
+	if deviceMessageID == "" && clientFingerprint != "" {
+		sum := sha256.Sum256([]byte("client\x00" + clientFingerprint))
+		return hex.EncodeToString(sum[:])
+	}
+
+	message := strings.ToLower(strings.TrimSpace(address)) + "\x00" + strconv.FormatInt(receivedAt.UnixMilli(), 10) + "\x00"
+	if deviceMessageID != "" {
+		message = "native\x00" + message + deviceMessageID
+	} else {
+		message = "content\x00" + message + body
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
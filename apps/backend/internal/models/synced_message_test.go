@@ -0,0 +1,65 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageFingerprint(t *testing.T) {
+	key := []byte("fingerprint-key")
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	type input struct {
+		key             []byte
+		deviceMessageID string
+		clientFP        string
+		address         string
+		receivedAt      time.Time
+		body            string
+	}
+	native := input{key, "sms-42", "", "+15551234567", at, "Your code is 1234"}
+	content := input{key, "", "", "+15551234567", at, "Your code is 1234"}
+	client := input{key, "", "client-fp", "+15551234567", at, "ciphertext-1"}
+
+	with := func(in input, edit func(*input)) input {
+		edit(&in)
+		return in
+	}
+
+	tests := []struct {
+		name string
+		a, b input
+		same bool
+	}{
+		{"native ID is stable", native, native, true},
+		{"native ID ignores the body", native, with(native, func(in *input) { in.body = "edited" }), true},
+		{"native ID normalises the address", native, with(native, func(in *input) { in.address = "  +15551234567 " }), true},
+		{"other native ID", native, with(native, func(in *input) { in.deviceMessageID = "sms-43" }), false},
+		{"native ID at another time", native, with(native, func(in *input) { in.receivedAt = at.Add(time.Second) }), false},
+		{"native ID from another address", native, with(native, func(in *input) { in.address = "+15557654321" }), false},
+		{"native ID wins over client fingerprint", native, with(native, func(in *input) { in.clientFP = "client-fp" }), true},
+		{"sub-millisecond time differences", content, with(content, func(in *input) { in.receivedAt = at.Add(time.Microsecond) }), true},
+		{"content scheme uses the body", content, with(content, func(in *input) { in.body = "Your code is 9999" }), false},
+		{"address case", with(content, func(in *input) { in.address = "ACME" }), with(content, func(in *input) { in.address = "acme" }), true},
+		{"content differs from native", content, with(content, func(in *input) { in.deviceMessageID = "x" }), false},
+		{"client fingerprint ignores ciphertext", client, with(client, func(in *input) { in.body = "ciphertext-2" }), true},
+		{"other client fingerprint", client, with(client, func(in *input) { in.clientFP = "client-fp-2" }), false},
+		{"client fingerprint is unkeyed", client, with(client, func(in *input) { in.key = []byte("other-key") }), true},
+		{"server fingerprints are keyed", content, with(content, func(in *input) { in.key = []byte("other-key") }), false},
+	}
+
+	fp := func(in input) string {
+		return MessageFingerprint(in.key, in.deviceMessageID, in.clientFP, in.address, in.receivedAt, in.body)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := fp(tt.a), fp(tt.b)
+			if len(a) != 64 {
+				t.Fatalf("fingerprint %q is not 64 hex characters", a)
+			}
+			if (a == b) != tt.same {
+				t.Errorf("fingerprints equal = %v, want %v", a == b, tt.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserDataKey is a per-user AES-256 key for at-rest encryption of clip and message content.
+// It is stored wrapped by a server master key; MasterKeyID names that key so master keys
+// can be rotated by re-wrapping. Ciphertexts record the ID of the data key that sealed them.
+type UserDataKey struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID      string     `gorm:"type:varchar(255);not null;index" json:"userId"`
+	MasterKeyID string     `gorm:"type:varchar(64);not null;index" json:"masterKeyId"`
+	WrappedKey  string     `gorm:"type:text;not null" json:"-"` // Base64 nonce || AES-GCM ciphertext
+	RetiredAt   *time.Time `json:"retiredAt"`                   // Set on rotation; still used to decrypt
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+func (UserDataKey) TableName() string {
+	return "user_data_keys"
+}
+
+func (k *UserDataKey) BeforeCreate(tx *gorm.DB) error {
+	if k.ID == uuid.Nil {
+		k.ID = uuid.New()
+	}
+	return nil
+}
@@ -1,19 +1,109 @@
 package models
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-// UserVault stores the salt for key derivation. The master password is never stored.
-// Client derives encryption key from: PBKDF2(masterPassword, salt)
+// Supported vault key derivation functions. Derivation always happens client-side;
+// the server only stores the parameters so every client derives the same key.
+const (
+	KDFPBKDF2SHA256 = "pbkdf2-sha256"
+	KDFArgon2id     = "argon2id"
+
+	// KDFDescriptorVersion is the layout version of KDFParams.
+	KDFDescriptorVersion = 1
+
+	// Parameters every client used before descriptors were stored.
+	LegacyPBKDF2Iterations = 100000
+)
+
+// KDFParams describes how the client derives the vault key from the master password and salt.
+// For argon2id, Iterations is the time cost and Memory is in KiB.
+type KDFParams struct {
+	Version     int    `gorm:"not null;default:1" json:"version"`
+	Algorithm   string `gorm:"type:varchar(32);not null;default:'pbkdf2-sha256'" json:"algorithm"`
+	Iterations  int    `gorm:"not null;default:100000" json:"iterations"`
+	Memory      int    `gorm:"not null;default:0" json:"memory,omitempty"`
+	Parallelism int    `gorm:"not null;default:0" json:"parallelism,omitempty"`
+}
+
+// DefaultKDFParams matches what existing clients derive with, so vaults created
+// without an explicit descriptor stay readable everywhere.
+func DefaultKDFParams() KDFParams {
+	return KDFParams{
+		Version:    KDFDescriptorVersion,
+		Algorithm:  KDFPBKDF2SHA256,
+		Iterations: LegacyPBKDF2Iterations,
+	}
+}
+
+// Validate enforces minimum strength and sane upper bounds for each algorithm.
+func (k KDFParams) Validate() error {
+	if k.Version != KDFDescriptorVersion {
+		return fmt.Errorf("unsupported KDF descriptor version %d", k.Version)
+	}
+	switch k.Algorithm {
+	case KDFPBKDF2SHA256:
+		if k.Iterations < LegacyPBKDF2Iterations || k.Iterations > 10000000 {
+			return fmt.Errorf("pbkdf2 iterations must be between %d and 10000000", LegacyPBKDF2Iterations)
+		}
+		if k.Memory != 0 || k.Parallelism != 0 {
+			return errors.New("pbkdf2 does not take memory or parallelism")
+		}
+	case KDFArgon2id:
+		if k.Iterations < 2 || k.Iterations > 100 {
+			return errors.New("argon2id iterations must be between 2 and 100")
+		}
+		if k.Memory < 19456 || k.Memory > 4194304 {
+			return errors.New("argon2id memory must be between 19456 and 4194304 KiB")
+		}
+		if k.Parallelism < 1 || k.Parallelism > 16 {
+			return errors.New("argon2id parallelism must be between 1 and 16")
+		}
+	default:
+		return fmt.Errorf("unsupported KDF algorithm %q", k.Algorithm)
+	}
+	return nil
+}
+
+// CanReplace reports whether k may replace current during a re-key. Moving from
+// PBKDF2 to Argon2id is always an upgrade; within one algorithm no cost may decrease.
+func (k KDFParams) CanReplace(current KDFParams) bool {
+	if k.Algorithm != current.Algorithm {
+		return k.Algorithm == KDFArgon2id
+	}
+	return k.Iterations >= current.Iterations &&
+		k.Memory >= current.Memory &&
+		k.Parallelism >= current.Parallelism
+}
+
+// UserVault stores the salt and KDF descriptor for key derivation. The master password is never stored.
+// Client derives encryption key from: KDF(masterPassword, salt) using the stored parameters.
 // Salt is stored as base64-encoded random bytes.
 type UserVault struct {
 	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	UserID    string    `gorm:"type:varchar(255);not null;uniqueIndex" json:"userId"`
-	Salt      string    `gorm:"type:varchar(88);not null" json:"salt"` // Base64-encoded salt for PBKDF2
+	Salt      string    `gorm:"type:varchar(88);not null" json:"salt"` // Base64-encoded salt for the KDF
+	KDF       KDFParams `gorm:"embedded;embeddedPrefix:kdf_" json:"kdf"`
+	KeyScheme string    `gorm:"type:varchar(16);not null;default:'password'" json:"keyScheme"` // See KeySchemePassword / KeySchemeWrapped
+
+	// Key verification. Verifier is a known plaintext encrypted under the vault key, so a
+	// client can detect a wrong master password before touching any clip. KeyFingerprint is a
+	// client-computed key-check value that writes must present; it is never returned.
+	Verifier       *string `gorm:"type:text" json:"verifier"`
+	VerifierNonce  *string `gorm:"type:varchar(32)" json:"verifierNonce"`
+	KeyFingerprint *string `gorm:"type:varchar(88)" json:"-"`
+
+	// E2EEnabled requires ordinary clips and message bodies to be uploaded encrypted under
+	// the vault key as well. Rows stored before it was turned on stay plaintext until migrated.
+	E2EEnabled bool `gorm:"not null;default:false" json:"e2eEnabled"`
+
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
@@ -26,5 +116,25 @@ func (v *UserVault) BeforeCreate(tx *gorm.DB) error {
 	if v.ID == uuid.Nil {
 		v.ID = uuid.New()
 	}
+	if v.KDF.Algorithm == "" {
+		v.KDF = DefaultKDFParams()
+	}
+	if v.KeyScheme == "" {
+		v.KeyScheme = KeySchemePassword
+	}
 	return nil
 }
+
+// HasVerifier reports whether the vault has a key-check value that writes must match.
+func (v *UserVault) HasVerifier() bool {
+	return v.KeyFingerprint != nil && *v.KeyFingerprint != ""
+}
+
+// MatchesFingerprint checks a client-supplied key fingerprint in constant time.
+// Vaults created before verifiers existed accept any fingerprint.
+func (v *UserVault) MatchesFingerprint(fingerprint string) bool {
+	if !v.HasVerifier() {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(*v.KeyFingerprint), []byte(fingerprint)) == 1
+}
@@ -0,0 +1,82 @@
+package models
+
+import "testing"
+
+func TestKDFParamsValidate(t *testing.T) {
+	argon := func(iterations, memory, parallelism int) KDFParams {
+		return KDFParams{Version: KDFDescriptorVersion, Algorithm: KDFArgon2id, Iterations: iterations, Memory: memory, Parallelism: parallelism}
+	}
+	pbkdf2 := func(iterations int) KDFParams {
+		return KDFParams{Version: KDFDescriptorVersion, Algorithm: KDFPBKDF2SHA256, Iterations: iterations}
+	}
+
+	tests := []struct {
+		name    string
+		params  KDFParams
+		wantErr bool
+	}{
+		{"default", DefaultKDFParams(), false},
+		{"pbkdf2 stronger", pbkdf2(600000), false},
+		{"pbkdf2 upper bound", pbkdf2(10000000), false},
+		{"pbkdf2 below legacy", pbkdf2(LegacyPBKDF2Iterations - 1), true},
+		{"pbkdf2 above bound", pbkdf2(10000001), true},
+		{"pbkdf2 with memory", KDFParams{Version: KDFDescriptorVersion, Algorithm: KDFPBKDF2SHA256, Iterations: LegacyPBKDF2Iterations, Memory: 65536}, true},
+		{"pbkdf2 with parallelism", KDFParams{Version: KDFDescriptorVersion, Algorithm: KDFPBKDF2SHA256, Iterations: LegacyPBKDF2Iterations, Parallelism: 1}, true},
+		{"argon2id minimum", argon(2, 19456, 1), false},
+		{"argon2id typical", argon(3, 65536, 4), false},
+		{"argon2id maximum", argon(100, 4194304, 16), false},
+		{"argon2id one pass", argon(1, 65536, 4), true},
+		{"argon2id too many passes", argon(101, 65536, 4), true},
+		{"argon2id too little memory", argon(3, 19455, 4), true},
+		{"argon2id too much memory", argon(3, 4194305, 4), true},
+		{"argon2id no parallelism", argon(3, 65536, 0), true},
+		{"argon2id too much parallelism", argon(3, 65536, 17), true},
+		{"unknown algorithm", KDFParams{Version: KDFDescriptorVersion, Algorithm: "scrypt", Iterations: 16384}, true},
+		{"unknown version", KDFParams{Version: 2, Algorithm: KDFPBKDF2SHA256, Iterations: LegacyPBKDF2Iterations}, true},
+		{"missing version", KDFParams{Algorithm: KDFPBKDF2SHA256, Iterations: LegacyPBKDF2Iterations}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.params.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKDFParamsCanReplace(t *testing.T) {
+	argon := func(iterations, memory, parallelism int) KDFParams {
+		return KDFParams{Version: KDFDescriptorVersion, Algorithm: KDFArgon2id, Iterations: iterations, Memory: memory, Parallelism: parallelism}
+	}
+	pbkdf2 := func(iterations int) KDFParams {
+		return KDFParams{Version: KDFDescriptorVersion, Algorithm: KDFPBKDF2SHA256, Iterations: iterations}
+	}
+
+	tests := []struct {
+		name    string
+		next    KDFParams
+		current KDFParams
+		want    bool
+	}{
+		{"same pbkdf2", pbkdf2(100000), pbkdf2(100000), true},
+		{"more pbkdf2 iterations", pbkdf2(600000), pbkdf2(100000), true},
+		{"fewer pbkdf2 iterations", pbkdf2(100000), pbkdf2(600000), false},
+		{"pbkdf2 to argon2id", argon(2, 19456, 1), pbkdf2(600000), true},
+		{"argon2id to pbkdf2", pbkdf2(10000000), argon(2, 19456, 1), false},
+		{"same argon2id", argon(3, 65536, 4), argon(3, 65536, 4), true},
+		{"stronger argon2id", argon(4, 131072, 4), argon(3, 65536, 4), true},
+		{"fewer argon2id passes", argon(2, 131072, 4), argon(3, 65536, 4), false},
+		{"less argon2id memory", argon(4, 32768, 4), argon(3, 65536, 4), false},
+		{"less argon2id parallelism", argon(4, 131072, 2), argon(3, 65536, 4), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.next.CanReplace(tt.current); got != tt.want {
+				t.Errorf("CanReplace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
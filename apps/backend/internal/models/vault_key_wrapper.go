@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Vault key schemes. With "password" (legacy) clips are encrypted directly with the key
+// derived from the master password. With "wrapped" a random vault key encrypts the clips
+// and is stored several times in VaultKeyWrapper rows, each wrapped by a different key.
+const (
+	KeySchemePassword = "password"
+	KeySchemeWrapped  = "wrapped"
+)
+
+// Wrapper kinds. Password and recovery wrappers derive their wrapping key with a KDF from a
+// secret the user knows; device wrappers use a random key kept on one device.
+const (
+	WrapperPassword = "password"
+	WrapperRecovery = "recovery"
+	WrapperDevice   = "device"
+)
+
+// VaultKeyWrapper stores the vault key encrypted (wrapped) by one client-derived key.
+// The server never sees the vault key or any wrapping key.
+type VaultKeyWrapper struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID     string     `gorm:"type:varchar(255);not null;index" json:"userId"`
+	Kind       string     `gorm:"type:varchar(16);not null;index" json:"kind"`
+	Label      string     `gorm:"type:varchar(100)" json:"label"`                   // e.g. device name
+	LookupID   *string    `gorm:"type:varchar(88);index" json:"lookupId,omitempty"` // Client-derived public ID of a recovery code
+	WrappedKey string     `gorm:"type:text;not null" json:"wrappedKey,omitempty"`   // Base64 AES-GCM ciphertext of the vault key
+	Nonce      string     `gorm:"type:varchar(32);not null" json:"nonce,omitempty"` // Base64 96-bit nonce
+	Salt       *string    `gorm:"type:varchar(88)" json:"salt,omitempty"`           // KDF salt (password/recovery)
+	KDF        *KDFParams `gorm:"serializer:json;type:text" json:"kdf,omitempty"`   // KDF parameters (password/recovery)
+	ConsumedAt *time.Time `json:"consumedAt,omitempty"`                             // Set once a recovery code is used
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func (VaultKeyWrapper) TableName() string {
+	return "vault_key_wrappers"
+}
+
+func (w *VaultKeyWrapper) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return nil
+}
+
+// UsesKDF reports whether the wrapping key is derived from a user secret.
+func (w *VaultKeyWrapper) UsesKDF() bool {
+	return w.Kind == WrapperPassword || w.Kind == WrapperRecovery
+}
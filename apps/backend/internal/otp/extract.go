@@ -0,0 +1,129 @@
+// Package otp recognizes one-time passcodes in SMS bodies.
+package otp
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keywords mark a message as carrying a code. A candidate number is only accepted next to
+// one of them, which keeps amounts, dates and order numbers out.
+var keywords = []string{
+	// English
+	"code", "otp", "passcode", "password", "verification", "verify", "one-time",
+	"one time", "2fa", "two-factor", "authentication", "security", "login", "log in", "sign-in", "sign in", "token",
+	// Spanish / Portuguese
+	"código", "codigo", "verificación", "verificacion", "verificação", "contraseña", "senha",
+	// French
+	"vérification", "mot de passe", "identification",
+	// German
+	"bestätigung", "bestaetigung", "sicherheitscode", "kennwort",
+	// Italian / Dutch
+	"codice", "verifica", "verificatie", "inlogcode",
+	// Russian / Ukrainian / Turkish / Indonesian
+	"код", "пароль", "doğrulama", "dogrulama", "şifre", "kode", "sandi",
+	// Chinese / Japanese / Korean
+	"验证码", "驗證碼", "校验码", "动态码", "認証コード", "確認コード", "인증번호", "인증 번호", "인증코드",
+}
+
+var (
+	// 4-8 digits, optionally split once by a space or dash ("123 456", "123-456"),
+	// optionally after a letter prefix ("G-123456"). Longer runs are phone or card numbers.
+	// CJK text does not put spaces between words, so a code may follow a CJK character directly.
+	candidate = regexp.MustCompile(`(?:^|[^\p{L}\p{N}.,:/]|[\p{Han}\p{Hiragana}\p{Katakana}\p{Hangul}])(?:[A-Z]{1,3}-)?(\d{3,4}[ -]\d{3,4}|\d{4,8})(?:$|[^\p{N}.,:/]|[.,:](?:\s|$))`)
+
+	// Currency markers before a number, e.g. "$1234" or "EUR 1234"
+	currencyBefore = regexp.MustCompile(`(?i)(?:[$€£¥₹₽]|usd|eur|gbp|inr|rs\.?)\s*$`)
+
+	// Validity phrases: "expires in 10 minutes", "valid for 1 hour", "5分钟内有效"
+	validity      = regexp.MustCompile(`(?i)(?:valid|expires?|expiring|vence|válido|valable|gültig|scade|действителен|有效)[^\d]{0,20}(\d{1,3})\s*(min|minutes?|mins?|minutos?|minuten|minuti|мин|分钟|分|h|hours?|hrs?|horas?|heures?|stunden?)`)
+	validityAfter = regexp.MustCompile(`(\d{1,3})\s*(分钟|分|時間|시간|분)\s*(?:内|以内|이내)?\s*(?:有效|有効|유효)`)
+)
+
+// Match is an extracted code with how long the message says it is valid.
+type Match struct {
+	Code     string
+	Validity time.Duration // 0 when the message does not say
+}
+
+// Extract returns the most likely one-time code in body.
+func Extract(body string) (Match, bool) {
+	lower := strings.ToLower(body)
+
+	var keywordAt []int
+	for _, kw := range keywords {
+		for from := 0; ; {
+			i := strings.Index(lower[from:], kw)
+			if i < 0 {
+				break
+			}
+			keywordAt = append(keywordAt, from+i)
+			from += i + len(kw)
+		}
+	}
+	if len(keywordAt) == 0 {
+		return Match{}, false
+	}
+
+	best, bestScore := "", -1
+	for _, loc := range candidate.FindAllStringSubmatchIndex(body, -1) {
+		start, end := loc[2], loc[3]
+		if currencyBefore.MatchString(body[:start]) {
+			continue
+		}
+		code := strings.NewReplacer(" ", "", "-", "").Replace(body[start:end])
+		if len(code) < 4 || len(code) > 8 || looksLikeYear(code) {
+			continue
+		}
+
+		distance := len(body)
+		for _, k := range keywordAt {
+			d := start - k
+			if d < 0 {
+				d = -d * 2 // Codes usually follow the keyword
+			}
+			if d < distance {
+				distance = d
+			}
+		}
+		score := 1000 - distance
+		if len(code) == 6 {
+			score += 50
+		}
+		if score > bestScore {
+			best, bestScore = code, score
+		}
+	}
+	if best == "" {
+		return Match{}, false
+	}
+	return Match{Code: best, Validity: parseValidity(body)}, true
+}
+
+func looksLikeYear(code string) bool {
+	if len(code) != 4 {
+		return false
+	}
+	n, _ := strconv.Atoi(code)
+	return n >= 1990 && n <= 2099
+}
+
+func parseValidity(body string) time.Duration {
+	m := validity.FindStringSubmatch(body)
+	if m == nil {
+		if m = validityAfter.FindStringSubmatch(body); m == nil {
+			return 0
+		}
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n == 0 {
+		return 0
+	}
+	unit := strings.ToLower(m[2])
+	if strings.HasPrefix(unit, "h") || strings.HasPrefix(unit, "stunde") || unit == "時間" || unit == "시간" {
+		return time.Duration(n) * time.Hour
+	}
+	return time.Duration(n) * time.Minute
+}
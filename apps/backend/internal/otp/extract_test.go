@@ -0,0 +1,58 @@
+package otp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		code     string // "" when no code should be found
+		validity time.Duration
+	}{
+		{"english", "Your verification code is 482913.", "482913", 0},
+		{"english validity", "Your login code: 7391. It expires in 10 minutes.", "7391", 10 * time.Minute},
+		{"split with space", "Use code 123 456 to sign in", "123456", 0},
+		{"split with dash", "Your security code is 123-456", "123456", 0},
+		{"letter prefix", "G-583920 is your Google verification code.", "583920", 0},
+		{"hour validity", "Your one-time passcode is 55120, valid for 1 hour", "55120", time.Hour},
+		{"spanish", "Tu código de verificación es 902113", "902113", 0},
+		{"german", "Ihr Bestätigungscode lautet 44821", "44821", 0},
+		{"russian", "Ваш код: 3812", "3812", 0},
+		{"chinese after han", "您的验证码是123456，5分钟内有效", "123456", 5 * time.Minute},
+		{"chinese before han", "验证码：845120（10分钟内有效）", "845120", 10 * time.Minute},
+		{"japanese", "認証コードは482910です", "482910", 0},
+		{"korean", "[Web발신] 인증번호[583920]를 입력해주세요", "583920", 0},
+		{"korean no space", "인증번호583920 3분 이내 유효", "583920", 3 * time.Minute},
+		{"prefers six digits", "Code 4821 for order, or verify with 902113", "902113", 0},
+		{"no keyword", "Meet me at 1830 by the station", "", 0},
+		{"amount", "Your code payment of $1234 was received", "", 0},
+		{"year", "Security notice 2024", "", 0},
+		{"phone number", "Call 5551234567 for your verification", "", 0},
+		{"decimal", "Verification fee 12.3456 applied", "", 0},
+		{"letters attached", "Your code is ABC123456", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := Extract(tt.body)
+			if tt.code == "" {
+				if ok {
+					t.Fatalf("Extract(%q) = %q, want no match", tt.body, m.Code)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("Extract(%q) found no code, want %q", tt.body, tt.code)
+			}
+			if m.Code != tt.code {
+				t.Errorf("Extract(%q) code = %q, want %q", tt.body, m.Code, tt.code)
+			}
+			if m.Validity != tt.validity {
+				t.Errorf("Extract(%q) validity = %v, want %v", tt.body, m.Validity, tt.validity)
+			}
+		})
+	}
+}
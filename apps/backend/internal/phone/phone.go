@@ -0,0 +1,95 @@
+// Package phone normalizes message addresses so every spelling of one number maps to
+// the same conversation thread.
+package phone
+
+import (
+	"strings"
+	"unicode"
+)
+
+type region struct {
+	callingCode   string
+	trunkPrefix   string // Dropped from national numbers ("" where it is part of the number)
+	intlPrefix    string // Dialed before a country code from inside the region
+	nationalDigit int    // Length of a national significant number, 0 if variable
+}
+
+// regions covers the dialing plans the apps ship in. Numbers from other regions still
+// normalize when written in international form.
+var regions = map[string]region{
+	"US": {"1", "1", "011", 10},
+	"CA": {"1", "1", "011", 10},
+	"GB": {"44", "0", "00", 0},
+	"IE": {"353", "0", "00", 0},
+	"DE": {"49", "0", "00", 0},
+	"FR": {"33", "0", "00", 9},
+	"ES": {"34", "", "00", 9},
+	"IT": {"39", "", "00", 0},
+	"NL": {"31", "0", "00", 9},
+	"AU": {"61", "0", "0011", 9},
+	"NZ": {"64", "0", "00", 0},
+	"IN": {"91", "0", "00", 10},
+	"BR": {"55", "0", "00", 0},
+	"MX": {"52", "", "00", 10},
+	"JP": {"81", "0", "010", 0},
+	"CN": {"86", "0", "00", 0},
+	"SG": {"65", "", "000", 8},
+	"ZA": {"27", "0", "00", 9},
+}
+
+// maxShortCode is the longest number treated as an SMS short code rather than a phone number.
+const maxShortCode = 6
+
+// SupportedRegion reports whether code (ISO 3166-1 alpha-2) can be used as default region.
+func SupportedRegion(code string) bool {
+	_, ok := regions[strings.ToUpper(code)]
+	return ok
+}
+
+// Normalize returns the thread key of address: E.164 ("+14155550100") for phone numbers,
+// interpreting national numbers in defaultRegion. Short codes keep their digits and
+// alphanumeric sender IDs (e.g. "AMAZON") are upper-cased, since they have no E.164 form.
+func Normalize(address, defaultRegion string) string {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return ""
+	}
+
+	var digits strings.Builder
+	plus := false
+	for i, r := range address {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			plus = true
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')' || r == '/':
+		case unicode.IsLetter(r) || r == '@':
+			// Alphanumeric sender ID or email address
+			return strings.ToUpper(strings.Join(strings.Fields(address), " "))
+		}
+	}
+	number := digits.String()
+	if number == "" {
+		return strings.ToUpper(address)
+	}
+	if plus {
+		return "+" + number
+	}
+	if len(number) <= maxShortCode {
+		return number
+	}
+
+	reg, ok := regions[strings.ToUpper(defaultRegion)]
+	if !ok {
+		return number
+	}
+	if strings.HasPrefix(number, reg.intlPrefix) {
+		return "+" + strings.TrimPrefix(number, reg.intlPrefix)
+	}
+	if reg.trunkPrefix != "" && strings.HasPrefix(number, reg.trunkPrefix) &&
+		(reg.nationalDigit == 0 || len(number) == reg.nationalDigit+len(reg.trunkPrefix)) {
+		number = strings.TrimPrefix(number, reg.trunkPrefix)
+	}
+	return "+" + reg.callingCode + number
+}
@@ -0,0 +1,62 @@
+package phone
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		region  string
+		want    string
+	}{
+		{"US national", "(415) 555-0100", "US", "+14155550100"},
+		{"US with trunk prefix", "1-415-555-0100", "US", "+14155550100"},
+		{"US international prefix", "011 44 20 7946 0958", "US", "+442079460958"},
+		{"E.164", "+44 20 7946 0958", "US", "+442079460958"},
+		{"E.164 ignores region", "+14155550100", "GB", "+14155550100"},
+		{"GB national", "020 7946 0958", "GB", "+442079460958"},
+		{"GB international prefix", "00 1 415 555 0100", "GB", "+14155550100"},
+		{"lower-case region", "020 7946 0958", "gb", "+442079460958"},
+		{"DE mobile", "0151/23456789", "DE", "+4915123456789"},
+		{"AU mobile", "0412 345 678", "AU", "+61412345678"},
+		{"AU international prefix", "0011 1 415 555 0100", "AU", "+14155550100"},
+		{"ES without trunk prefix", "612 345 678", "ES", "+34612345678"},
+		{"dots", "415.555.0100", "US", "+14155550100"},
+		{"short code", "72975", "US", "72975"},
+		{"short code with trunk digit", "12345", "US", "12345"},
+		{"alphanumeric sender", "Amazon", "US", "AMAZON"},
+		{"alphanumeric sender spacing", "  Bank   of  X ", "US", "BANK OF X"},
+		{"email", "Someone@Example.com", "US", "SOMEONE@EXAMPLE.COM"},
+		{"empty", "   ", "US", ""},
+		{"no digits", "***", "US", "***"},
+		{"unknown region", "4155550100", "XX", "4155550100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.address, tt.region); got != tt.want {
+				t.Errorf("Normalize(%q, %q) = %q, want %q", tt.address, tt.region, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupportedRegion(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"US", true},
+		{"gb", true},
+		{"XX", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := SupportedRegion(tt.code); got != tt.want {
+				t.Errorf("SupportedRegion(%q) = %v, want %v", tt.code, got, tt.want)
+			}
+		})
+	}
+}
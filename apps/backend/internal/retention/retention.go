@@ -0,0 +1,288 @@
+// Package retention deletes synced messages beyond each user's retention policy, the
+// attachments of deleted messages, mirrored notifications some time after they were
+// cleared, and expired one-time codes together with their auto-clips. Deleted messages
+// leave tombstones so re-syncs from the phone do not restore them.
+package retention
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"clipsync/backend/internal/blob"
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Policy limits the messages kept for a user. Zero disables a limit. Messages in
+// starred threads are never deleted and do not count towards Count.
+type Policy struct {
+	Count int `json:"retentionCount"`
+	Days  int `json:"retentionDays"`
+}
+
+// Unlimited reports whether the policy keeps everything.
+func (p Policy) Unlimited() bool {
+	return p.Count == 0 && p.Days == 0
+}
+
+// DefaultPolicy is the server-wide policy for users without overrides.
+func DefaultPolicy() Policy {
+	cfg := config.Get()
+	return Policy{Count: cfg.MessageRetentionCount, Days: cfg.MessageRetentionDays}
+}
+
+// Resolve applies a user's overrides to the server default.
+func Resolve(settings *models.MessageSettings) Policy {
+	p := DefaultPolicy()
+	if settings == nil {
+		return p
+	}
+	if settings.RetentionCount != nil {
+		p.Count = *settings.RetentionCount
+	}
+	if settings.RetentionDays != nil {
+		p.Days = *settings.RetentionDays
+	}
+	return p
+}
+
+// PolicyFor loads the effective policy of a user.
+func PolicyFor(db *gorm.DB, userID string) (Policy, error) {
+	var settings models.MessageSettings
+	err := db.Where("user_id = ?", userID).First(&settings).Error
+	if err == gorm.ErrRecordNotFound {
+		return Resolve(nil), nil
+	}
+	if err != nil {
+		return Policy{}, err
+	}
+	return Resolve(&settings), nil
+}
+
+// notStarred excludes messages of the user's starred threads.
+const notStarred = `NOT EXISTS (SELECT 1 FROM starred_threads s
+	WHERE s.user_id = synced_messages.user_id AND s.thread_key = synced_messages.thread_key)`
+
+// Cutoff returns the receive time before which the policy deletes messages, or the zero
+// time without an age limit. Pushes of older messages are skipped instead of stored.
+func (p Policy) Cutoff() time.Time {
+	if p.Days == 0 {
+		return time.Time{}
+	}
+	return time.Now().AddDate(0, 0, -p.Days)
+}
+
+// Enforce deletes the user's messages outside policy and returns how many it removed.
+func Enforce(db *gorm.DB, userID string, p Policy) (int64, error) {
+	var deleted int64
+	if cutoff := p.Cutoff(); !cutoff.IsZero() {
+		n, err := DeleteMessages(db, db.Where("user_id = ? AND received_at < ?", userID, cutoff).Where(notStarred))
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+		// Pushes this old are skipped anyway, so their tombstones are no longer needed
+		if err := db.Where("user_id = ? AND received_at < ?", userID, cutoff).
+			Where(strings.ReplaceAll(notStarred, "synced_messages.", "message_tombstones.")).
+			Delete(&models.MessageTombstone{}).Error; err != nil {
+			return deleted, err
+		}
+	}
+	if p.Count > 0 {
+		beyond := db.Model(&models.SyncedMessage{}).Select("id").
+			Where("user_id = ?", userID).Where(notStarred).
+			Order("received_at DESC, created_at DESC").Offset(p.Count)
+		n, err := DeleteMessages(db, db.Where("id IN (?)", beyond))
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteMessages deletes the messages matched by cond and records their fingerprints as
+// tombstones, in one transaction. cond is a condition built from db, e.g.
+// db.Where("user_id = ?", userID).
+func DeleteMessages(db *gorm.DB, cond *gorm.DB) (int64, error) {
+	var deleted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		matched := tx.Model(&models.SyncedMessage{}).Where(cond).Where("fingerprint IS NOT NULL").
+			Select("user_id, fingerprint, COALESCE(thread_key, ''), received_at, ?", time.Now())
+		if err := tx.Exec(`INSERT INTO message_tombstones (user_id, fingerprint, thread_key, received_at, created_at) ?
+			ON CONFLICT DO NOTHING`, matched).Error; err != nil {
+			return err
+		}
+		result := tx.Where(cond).Delete(&models.SyncedMessage{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return deleted, err
+}
+
+// Tombstones tells which pushed messages of a user were deleted before, by the user or
+// by retention, so they are not stored again.
+type Tombstones struct {
+	cutoff  time.Time
+	starred map[string]bool
+	deleted map[string]bool
+}
+
+// LoadTombstones loads the user's tombstones among fingerprints and what retention
+// would delete.
+func LoadTombstones(db *gorm.DB, userID string, fingerprints []string) (*Tombstones, error) {
+	p, err := PolicyFor(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tombstones{cutoff: p.Cutoff(), starred: map[string]bool{}, deleted: map[string]bool{}}
+	if !t.cutoff.IsZero() {
+		var threads []string
+		if err := db.Model(&models.StarredThread{}).Where("user_id = ?", userID).
+			Pluck("thread_key", &threads).Error; err != nil {
+			return nil, err
+		}
+		for _, thread := range threads {
+			t.starred[thread] = true
+		}
+	}
+	if len(fingerprints) > 0 {
+		var found []string
+		if err := db.Model(&models.MessageTombstone{}).
+			Where("user_id = ? AND fingerprint IN ?", userID, fingerprints).
+			Pluck("fingerprint", &found).Error; err != nil {
+			return nil, err
+		}
+		for _, f := range found {
+			t.deleted[f] = true
+		}
+	}
+	return t, nil
+}
+
+// Deleted reports whether a pushed message was deleted before or is past retention.
+func (t *Tombstones) Deleted(fingerprint, threadKey string, receivedAt time.Time) bool {
+	if t.deleted[fingerprint] {
+		return true
+	}
+	return receivedAt.Before(t.cutoff) && !t.starred[threadKey]
+}
+
+// PruneNotifications deletes mirrored notifications cleared before the retention window.
+func PruneNotifications(db *gorm.DB) (int64, error) {
+	cutoff := time.Now().Add(-config.Get().NotificationRetention)
+	result := db.Where("removed_at < ? OR (removed_at IS NULL AND dismissed_at < ?)", cutoff, cutoff).
+		Delete(&models.Notification{})
+	return result.RowsAffected, result.Error
+}
+
+// PruneExpired deletes one-time codes and short-lived clips (such as OTP auto-clips) past
+// their expiry. Reads already hide them; this removes the codes from storage.
+func PruneExpired(db *gorm.DB) (int64, error) {
+	now := time.Now()
+	codes := db.Where("expires_at < ?", now).Delete(&models.OTPCode{})
+	if codes.Error != nil {
+		return 0, codes.Error
+	}
+	clips := db.Where("expires_at IS NOT NULL AND expires_at < ?", now).Delete(&models.Clip{})
+	return codes.RowsAffected + clips.RowsAffected, clips.Error
+}
+
+// PruneAttachments deletes the attachments, content included, of messages that no longer
+// exist. An empty userID prunes every user.
+func PruneAttachments(ctx context.Context, db *gorm.DB, blobs blob.Store, userID string) (int64, error) {
+	query := db.Where("NOT EXISTS (SELECT 1 FROM synced_messages m WHERE m.id = message_attachments.message_id)")
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	var orphans []models.MessageAttachment
+	if err := query.Limit(1000).Find(&orphans).Error; err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, a := range orphans {
+		// Content first: a row without content is retried, content without a row is lost track of
+		if err := blobs.Delete(ctx, a.StorageKey); err != nil {
+			return deleted, err
+		}
+		if a.ThumbnailKey != nil {
+			if err := blobs.Delete(ctx, *a.ThumbnailKey); err != nil {
+				return deleted, err
+			}
+		}
+		if err := db.Delete(&a).Error; err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// EnforceAll applies every user's policy once.
+func EnforceAll(db *gorm.DB) (int64, error) {
+	var userIDs []string
+	if err := db.Model(&models.SyncedMessage{}).Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+		return 0, err
+	}
+	var overrides []models.MessageSettings
+	if err := db.Find(&overrides).Error; err != nil {
+		return 0, err
+	}
+	byUser := make(map[string]*models.MessageSettings, len(overrides))
+	for i := range overrides {
+		byUser[overrides[i].UserID] = &overrides[i]
+	}
+
+	var total int64
+	for _, userID := range userIDs {
+		p := Resolve(byUser[userID])
+		if p.Unlimited() {
+			continue
+		}
+		deleted, err := Enforce(db, userID, p)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Run enforces retention every interval until ctx is done.
+func Run(ctx context.Context, db *gorm.DB, blobs blob.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if deleted, err := EnforceAll(db); err != nil {
+			log.Printf("Message retention failed: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Message retention deleted %d messages", deleted)
+		}
+		if deleted, err := PruneNotifications(db); err != nil {
+			log.Printf("Notification retention failed: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Notification retention deleted %d notifications", deleted)
+		}
+		if deleted, err := PruneExpired(db); err != nil {
+			log.Printf("Expired code cleanup failed: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Expired code cleanup deleted %d codes and clips", deleted)
+		}
+		if deleted, err := PruneAttachments(ctx, db, blobs, ""); err != nil {
+			log.Printf("Attachment cleanup failed: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Attachment cleanup deleted %d attachments of deleted messages", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
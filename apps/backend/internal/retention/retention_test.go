@@ -0,0 +1,136 @@
+package retention
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	_ "clipsync/backend/internal/atrest" // Registers the serializer of sealed message columns
+	"clipsync/backend/internal/config"
+	"clipsync/backend/internal/dbtest"
+	"clipsync/backend/internal/models"
+)
+
+func TestResolve(t *testing.T) {
+	t.Setenv("MESSAGE_RETENTION_COUNT", "1000")
+	t.Setenv("MESSAGE_RETENTION_DAYS", "90")
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	ptr := func(n int) *int { return &n }
+
+	tests := []struct {
+		name      string
+		settings  *models.MessageSettings
+		want      Policy
+		unlimited bool
+	}{
+		{"server default", nil, Policy{Count: 1000, Days: 90}, false},
+		{"no overrides", &models.MessageSettings{}, Policy{Count: 1000, Days: 90}, false},
+		{"count override", &models.MessageSettings{RetentionCount: ptr(50)}, Policy{Count: 50, Days: 90}, false},
+		{"days override", &models.MessageSettings{RetentionDays: ptr(7)}, Policy{Count: 1000, Days: 7}, false},
+		{"limits lifted", &models.MessageSettings{RetentionCount: ptr(0), RetentionDays: ptr(0)}, Policy{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Resolve(tt.settings)
+			if got != tt.want {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+			if got.Unlimited() != tt.unlimited {
+				t.Errorf("Unlimited() = %v, want %v", got.Unlimited(), tt.unlimited)
+			}
+		})
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	tombstones := func() dbtest.Query { return dbtest.Query{Match: "INSERT INTO message_tombstones"} }
+	deleteMessages := func(affected int64) dbtest.Query {
+		return dbtest.Query{Match: `DELETE FROM "synced_messages"`, Affected: affected}
+	}
+	pruneTombstones := dbtest.Query{Match: `DELETE FROM "message_tombstones"`}
+	failure := errors.New("connection reset")
+
+	tests := []struct {
+		name        string
+		policy      Policy
+		script      []dbtest.Query
+		wantDeleted int64
+		wantErr     error
+	}{
+		{"unlimited", Policy{}, nil, 0, nil},
+		{"age", Policy{Days: 30}, []dbtest.Query{tombstones(), deleteMessages(3), pruneTombstones}, 3, nil},
+		{"count", Policy{Count: 100}, []dbtest.Query{tombstones(), deleteMessages(2)}, 2, nil},
+		{"age and count", Policy{Count: 100, Days: 30}, []dbtest.Query{tombstones(), deleteMessages(3), pruneTombstones, tombstones(), deleteMessages(2)}, 5, nil},
+		{"tombstones fail", Policy{Days: 30}, []dbtest.Query{{Match: "INSERT INTO message_tombstones", Err: failure}}, 0, failure},
+		{"count fails after age", Policy{Count: 100, Days: 30}, []dbtest.Query{tombstones(), deleteMessages(3), pruneTombstones, tombstones(), {Match: `DELETE FROM "synced_messages"`, Err: failure}}, 3, failure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, log := dbtest.New(t, tt.script...)
+			deleted, err := Enforce(db, "user-1", tt.policy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Enforce() error = %v, want %v", err, tt.wantErr)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("Enforce() deleted = %d, want %d", deleted, tt.wantDeleted)
+			}
+
+			// Every statement is scoped to the user and spares starred threads; the only
+			// time arguments besides the tombstone timestamp are the age cutoff
+			cutoff := time.Now().AddDate(0, 0, -tt.policy.Days)
+			for _, stmt := range log.Statements() {
+				if !strings.Contains(stmt.SQL, "user_id = $") {
+					t.Errorf("statement not scoped to the user: %s", stmt.SQL)
+				}
+				if !strings.Contains(stmt.SQL, "NOT EXISTS (SELECT 1 FROM starred_threads") {
+					t.Errorf("starred threads not exempt: %s", stmt.SQL)
+				}
+				for _, arg := range stmt.Args {
+					if at, ok := arg.(time.Time); ok && time.Since(at) > time.Hour && at.Sub(cutoff).Abs() > time.Minute {
+						t.Errorf("cutoff = %v, want about %v", at, cutoff)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTombstonesDeleted(t *testing.T) {
+	now := time.Now()
+	tombstones := &Tombstones{
+		cutoff:  now.AddDate(0, 0, -30),
+		starred: map[string]bool{"+15550001": true},
+		deleted: map[string]bool{"fp-deleted": true},
+	}
+	noCutoff := &Tombstones{deleted: map[string]bool{"fp-deleted": true}}
+
+	tests := []struct {
+		name        string
+		tombstones  *Tombstones
+		fingerprint string
+		thread      string
+		receivedAt  time.Time
+		want        bool
+	}{
+		{"new message", tombstones, "fp-new", "+15550002", now, false},
+		{"deleted message", tombstones, "fp-deleted", "+15550002", now, true},
+		{"deleted message in starred thread", tombstones, "fp-deleted", "+15550001", now, true},
+		{"past retention", tombstones, "fp-new", "+15550002", now.AddDate(0, 0, -31), true},
+		{"past retention in starred thread", tombstones, "fp-new", "+15550001", now.AddDate(0, 0, -31), false},
+		{"old message without age limit", noCutoff, "fp-new", "+15550002", now.AddDate(-5, 0, 0), false},
+		{"deleted message without age limit", noCutoff, "fp-deleted", "+15550002", now, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tombstones.Deleted(tt.fingerprint, tt.thread, tt.receivedAt); got != tt.want {
+				t.Errorf("Deleted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}